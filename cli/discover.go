@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"code.dopame.me/veonik/squircy3/plugin"
+)
+
+// discoverPluginFiles returns the path of each shared library in dir,
+// including those in subdirectories if recursive is true.
+func discoverPluginFiles(dir string, recursive bool) ([]string, error) {
+	var res []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) == ".so" {
+			res = append(res, p)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		logrus.Warnf("core: plugin_path '%s' does not exist, not discovering plugins", dir)
+		return nil, nil
+	}
+	return res, err
+}
+
+// sharedPluginName returns the name of the shared library plugin at the given
+// path, using its manifest if possible and its file name otherwise.
+func sharedPluginName(p string) string {
+	if mf, err := plugin.ReadManifest(p); err == nil && mf != nil && mf.Name != "" {
+		return mf.Name
+	}
+	return strings.TrimSuffix(filepath.Base(p), ".so")
+}
+
+// allowed returns true if the discovered shared library at the given path
+// passes the configured allow and deny lists.
+// Entries in either list are matched against the file name, with or without
+// the ".so" extension, so that manifests need only be read for allowed files.
+func (manager *Manager) allowed(p string) bool {
+	base := filepath.Base(p)
+	name := strings.TrimSuffix(base, ".so")
+	matches := func(list []string) bool {
+		for _, v := range list {
+			if v == name || v == base {
+				return true
+			}
+		}
+		return false
+	}
+	if len(manager.AllowPlugins) > 0 && !matches(manager.AllowPlugins) {
+		return false
+	}
+	return !matches(manager.DenyPlugins)
+}
+
+// pluginFiles returns the paths of the shared library plugins to load.
+//
+// Paths listed in extra_plugins are always included. If plugin discovery is
+// enabled, each allowed shared library in the plugin directory is also
+// included. Each plugin is included only once, and shared libraries are
+// skipped if a plugin with the same name is linked into the executable.
+func (manager *Manager) pluginFiles() ([]string, error) {
+	dir := manager.pluginDir()
+	var candidates []string
+	for _, pl := range manager.ExtraPlugins {
+		if !filepath.IsAbs(pl) {
+			pl = filepath.Join(dir, pl)
+		}
+		candidates = append(candidates, pl)
+	}
+	if manager.DiscoverPlugins {
+		found, err := discoverPluginFiles(dir, manager.DiscoverRecursive)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to discover plugins")
+		}
+		for _, f := range found {
+			if !manager.allowed(f) {
+				logrus.Debugf("core: not loading discovered plugin %s", f)
+				continue
+			}
+			candidates = append(candidates, f)
+		}
+	}
+	linked := make(map[string]bool)
+	for _, in := range manager.LinkedPlugins {
+		if n, ok := in.(plugin.Plugin); ok {
+			linked[n.Name()] = true
+		}
+	}
+	seen := make(map[string]string)
+	var res []string
+	for _, f := range candidates {
+		n := sharedPluginName(f)
+		if linked[n] {
+			logrus.Warnf("core: plugin %s is linked into the executable, ignoring shared library %s", n, f)
+			continue
+		}
+		if prev, ok := seen[n]; ok {
+			if prev != f {
+				logrus.Warnf("core: plugin %s is already loaded from %s, ignoring shared library %s", n, prev, f)
+			}
+			continue
+		}
+		seen[n] = f
+		res = append(res, f)
+	}
+	return res, nil
+}
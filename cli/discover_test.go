@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"code.dopame.me/veonik/squircy3/plugin"
+)
+
+func TestManager_pluginFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squircy3-plugins")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"a.so", "b.so", "c.so", "linked.so", "readme.txt", "sub/d.so"} {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("failed to create dir: %s", err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatalf("failed to create file: %s", err)
+		}
+	}
+	linked := plugin.InitializeWithRequires("linked", func(*plugin.Manager) (plugin.Plugin, error) {
+		return nil, nil
+	})
+	m := &Manager{Config: Config{
+		RootDir:         dir,
+		PluginDir:       ".",
+		ExtraPlugins:    []string{"c.so"},
+		DiscoverPlugins: true,
+		DenyPlugins:     []string{"b"},
+		LinkedPlugins:   []plugin.Initializer{linked},
+	}}
+
+	files, err := m.pluginFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{filepath.Join(dir, "c.so"), filepath.Join(dir, "a.so")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	m.DiscoverRecursive = true
+	m.AllowPlugins = []string{"d.so"}
+	files, err = m.pluginFiles()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = []string{filepath.Join(dir, "c.so"), filepath.Join(dir, "sub/d.so")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
@@ -71,7 +71,9 @@ func CoreFlags(fs *flag.FlagSet, root string) {
 	extraPlugins := stringsFlag{}
 	fs.String("root", root, "path to folder containing application data")
 	fs.String("log-level", "info", "controls verbosity of logging output")
+	fs.String("shutdown-timeout", "2s", "amount of time each plugin has to shut down")
 	fs.Var(&extraPlugins, "plugin", "path to shared plugin .so file, multiple plugins may be given")
+	fs.Bool("discover-plugins", false, "load every shared plugin .so file found in the plugin path")
 	PluginOptsFlag(fs, "plugin-option", "specify extra plugin configuration option, format: key=value")
 }
 
@@ -85,6 +87,7 @@ func IRCFlags(fs *flag.FlagSet) {
 	fs.String("irc-sasl-username", "", "specify sasl username")
 	fs.String("irc-sasl-password", "", "specify sasl password")
 	fs.String("irc-server-password", "", "specify server password")
+	fs.Bool("irc-reconnect", false, "automatically reconnect to irc when the connection is lost")
 }
 
 func VMFlags(fs *flag.FlagSet) {
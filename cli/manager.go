@@ -2,11 +2,18 @@
 package cli // import "code.dopame.me/veonik/squircy3/cli"
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -24,10 +31,25 @@ type Config struct {
 	PluginDir    string   `toml:"plugin_path"`
 	ExtraPlugins []string `toml:"extra_plugins" flag:"plugin"`
 
+	// DiscoverPlugins enables loading every shared library in PluginDir.
+	DiscoverPlugins bool `toml:"discover_plugins"`
+	// DiscoverRecursive includes shared libraries in subdirectories of
+	// PluginDir when discovering plugins.
+	DiscoverRecursive bool `toml:"discover_recursive"`
+	// AllowPlugins, if not empty, limits discovered plugins to those listed.
+	// Entries are file names, with or without the ".so" extension.
+	AllowPlugins []string `toml:"allow_plugins"`
+	// DenyPlugins prevents the listed plugins from being discovered.
+	// Entries are file names, with or without the ".so" extension.
+	DenyPlugins []string `toml:"deny_plugins"`
+
 	PluginOptions map[string]interface{} `flag:"plugin_option"`
 
 	LogLevel logrus.Level `toml:"log_level"`
 
+	// ShutdownTimeout is the amount of time each plugin has to shut down.
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+
 	// Specify additional plugins that are a part of the main executable.
 	LinkedPlugins []plugin.Initializer
 }
@@ -38,6 +60,10 @@ type Manager struct {
 	Config
 
 	stop chan os.Signal
+
+	// replay is the journal to replay once started, if set.
+	replay      *os.File
+	replaySpeed float64
 }
 
 func NewManager() (*Manager, error) {
@@ -78,6 +104,20 @@ func NewManager() (*Manager, error) {
 			}
 			return lvl, nil
 		}),
+		config.WithFilteredOption("shutdown_timeout", func(s string, val config.Value) (config.Value, error) {
+			if v, ok := val.(time.Duration); ok {
+				return v, nil
+			}
+			vs, ok := val.(string)
+			if !ok {
+				return nil, errors.Errorf("expected shutdown_timeout to be string but got %T", val)
+			}
+			d, err := time.ParseDuration(vs)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to parse shutdown_timeout as duration")
+			}
+			return d, nil
+		}),
 		config.WithInitValue(&conf),
 		config.WithValuesFromFlagSet(flag.CommandLine),
 		config.WithValuesFromMap(&conf.PluginOptions))
@@ -89,6 +129,7 @@ func NewManager() (*Manager, error) {
 	if err := config.ConfigurePlugin(m, config.WithValuesFromTOMLFile(cf)); err != nil {
 		return nil, err
 	}
+	m.SetShutdownTimeout(conf.ShutdownTimeout)
 	return &Manager{
 		plugins: m,
 		stop:    make(chan os.Signal, 10),
@@ -109,27 +150,86 @@ func (manager *Manager) Plugins() *plugin.Manager {
 	return manager.plugins
 }
 
+// pluginDir returns the absolute path to the configured plugin directory.
+func (manager *Manager) pluginDir() string {
+	if filepath.IsAbs(manager.PluginDir) {
+		return manager.PluginDir
+	}
+	return filepath.Join(manager.RootDir, manager.PluginDir)
+}
+
+// ListPlugins writes a description of each shared library plugin in the
+// plugin directory to w.
+// Plugins are checked for compatibility and their manifests are read, but
+// they are not initialized.
+func (manager *Manager) ListPlugins(w io.Writer) error {
+	files, err := discoverPluginFiles(manager.pluginDir(), manager.DiscoverRecursive)
+	if err != nil {
+		return errors.Wrap(err, "unable to list plugins")
+	}
+	loading, err := manager.pluginFiles()
+	if err != nil {
+		return err
+	}
+	enabled := make(map[string]bool)
+	for _, pl := range loading {
+		enabled[pl] = true
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tNAME\tVERSION\tAPI\tREQUIRES\tENABLED\tSTATUS")
+	for _, f := range files {
+		name, version, api, requires, status := "-", "-", "-", "-", "ok"
+		mf, err := plugin.ReadManifest(f)
+		if err != nil {
+			status = err.Error()
+		} else if mf == nil {
+			status = "no manifest"
+		} else {
+			name = mf.Name
+			version = mf.Version
+			api = strconv.Itoa(mf.APIVersion)
+			if len(mf.Requires) > 0 {
+				requires = strings.Join(mf.Requires, ",")
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\n",
+			filepath.Base(f), name, version, api, requires, enabled[f], status)
+	}
+	return tw.Flush()
+}
+
+// builtinPlugins returns the Initializers for the built-in plugins other
+// than config. Their requirements also determine the order in which they are
+// shut down: irc and vm are shut down before event.
+func builtinPlugins() []plugin.Initializer {
+	return []plugin.Initializer{
+		plugin.InitializeWithRequires("event", event.Initialize),
+		plugin.InitializeWithRequires("vm", vm.Initialize, "event"),
+		plugin.InitializeWithRequires("irc", irc.Initialize, "event"),
+	}
+}
+
 func (manager *Manager) Start() error {
 	m := manager.plugins
 
 	// init the remaining built-in plugins
-	m.RegisterFunc(event.Initialize)
-	m.RegisterFunc(vm.Initialize)
-	m.RegisterFunc(irc.Initialize)
+	for _, in := range builtinPlugins() {
+		m.Register(in)
+	}
 	if err := configure(m); err != nil {
 		return errors.Wrap(err, "unable to init built-in plugins")
 	}
+	if manager.replay != nil {
+		manager.prepareReplay()
+	}
 
 	// load remaining extra plugins
-	pluginDir := manager.PluginDir
-	if !filepath.IsAbs(pluginDir) {
-		pluginDir = filepath.Join(manager.RootDir, pluginDir)
+	files, err := manager.pluginFiles()
+	if err != nil {
+		return err
 	}
-	for _, pl := range manager.ExtraPlugins {
+	for _, pl := range files {
 		logrus.Tracef("core: loading extra plugin: %s", pl)
-		if !filepath.IsAbs(pl) {
-			pl = filepath.Join(pluginDir, pl)
-		}
 		m.Register(plugin.InitializeFromFile(pl))
 	}
 	for _, pl := range manager.LinkedPlugins {
@@ -155,9 +255,66 @@ func (manager *Manager) Start() error {
 	if err != nil {
 		return errors.Wrap(err, "unable to start vm")
 	}
+	if manager.replay != nil {
+		manager.startReplay(d)
+	}
 	return nil
 }
 
+// Replay arranges for the events recorded in the journal at the given path
+// to be emitted once the Manager is started.
+//
+// IRC is disabled and events are not recorded while replaying; both take
+// effect before any extra plugins or scripts are started. Events are emitted
+// in a separate goroutine; see event.Replay for the meaning of speed.
+// Replay must be called before Start.
+func (manager *Manager) Replay(path string, speed float64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to open journal")
+	}
+	if manager.replay != nil {
+		manager.replay.Close()
+	}
+	manager.replay = f
+	manager.replaySpeed = speed
+	return nil
+}
+
+// prepareReplay disables irc and the event journal.
+// Irc remains disabled if the irc plugin is reloaded.
+func (manager *Manager) prepareReplay() {
+	m := manager.plugins
+	if err := irc.InjectNetworks(m, func(ircm *irc.Networks) {
+		ircm.SetDisabled(true)
+	}); err != nil {
+		logrus.Warnln("core: unable to disable irc for replay:", err)
+	}
+	if d, err := event.FromPlugins(m); err != nil {
+		logrus.Warnln("core: unable to disable event journal for replay:", err)
+	} else {
+		// closes the journal, if one is open
+		d.SetJournal(nil)
+	}
+}
+
+// startReplay emits the events in the journal in a separate goroutine.
+func (manager *Manager) startReplay(d *event.Dispatcher) {
+	f := manager.replay
+	speed := manager.replaySpeed
+	manager.replay = nil
+	go func() {
+		defer f.Close()
+		logrus.Infof("Replaying events from %s", f.Name())
+		n, err := event.Replay(context.Background(), f, d, speed)
+		if err != nil {
+			logrus.Errorf("core: replay stopped after %d events: %s", n, err)
+			return
+		}
+		logrus.Infof("Replayed %d events from %s", n, f.Name())
+	}()
+}
+
 func (manager *Manager) Loop() error {
 	st := make(chan os.Signal, 10)
 	signal.Notify(st, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
@@ -200,8 +357,7 @@ func (manager *Manager) Loop() error {
 
 func (manager *Manager) Shutdown() error {
 	m := manager.plugins
-	m.Shutdown()
-	return nil
+	return m.Shutdown()
 }
 
 func configure(m *plugin.Manager) error {
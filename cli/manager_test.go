@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/config"
+	"code.dopame.me/veonik/squircy3/irc"
+	"code.dopame.me/veonik/squircy3/plugin"
+)
+
+func TestManager_ListPlugins(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squircy3-plugins")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	// the test executable stands in for a compatible shared library
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("unable to find test executable: %s", err)
+	}
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("unable to read test executable: %s", err)
+	}
+	files := map[string][]byte{
+		"a.so":            b,
+		"a.manifest.json": []byte(`{"name": "alpha", "version": "1.2.3", "api_version": 1, "requires": ["vm", "event"]}`),
+		"b.so":            b,
+		"c.so":            []byte("not a shared library"),
+	}
+	for f, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), data, 0644); err != nil {
+			t.Fatalf("failed to create file: %s", err)
+		}
+	}
+	m := &Manager{Config: Config{
+		RootDir:      dir,
+		PluginDir:    ".",
+		ExtraPlugins: []string{"a.so"},
+	}}
+	var buf bytes.Buffer
+	if err := m.ListPlugins(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	expected := [][]string{
+		{"FILE", "NAME", "VERSION", "API", "REQUIRES", "ENABLED", "STATUS"},
+		{"a.so", "alpha", "1.2.3", "1", "vm,event", "true", "ok"},
+		{"b.so", "-", "-", "-", "-", "false", "no", "manifest"},
+		{"c.so", "-", "-", "-", "-", "false", "unable"},
+	}
+	for i, ex := range expected {
+		fields := strings.Fields(lines[i])
+		if len(fields) < len(ex) {
+			t.Errorf("line %d: expected at least %d fields, got %q", i, len(ex), lines[i])
+			continue
+		}
+		for j, v := range ex {
+			if fields[j] != v {
+				t.Errorf("line %d: expected field %d to be %q, got %q", i, j, v, fields[j])
+			}
+		}
+	}
+}
+
+type shutdownRecorder struct {
+	name   string
+	events chan string
+}
+
+func (p shutdownRecorder) Name() string {
+	return p.name
+}
+
+func (p shutdownRecorder) HandleShutdown() {
+	p.events <- "start " + p.name
+	time.Sleep(10 * time.Millisecond)
+	p.events <- "done " + p.name
+}
+
+func TestBuiltinPlugins_shutdownOrder(t *testing.T) {
+	events := make(chan string, 10)
+	m := plugin.NewManager()
+	// stand-ins with the same names and requirements as the built-in
+	// plugins record when each is shut down.
+	for _, in := range builtinPlugins() {
+		name := in.(interface{ Name() string }).Name()
+		p := shutdownRecorder{name: name, events: events}
+		m.Register(plugin.InitializeWithRequires(name, func(*plugin.Manager) (plugin.Plugin, error) {
+			return p, nil
+		}, in.(plugin.Dependent).Requires()...))
+	}
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("unexpected error shutting down: %s", err)
+	}
+	close(events)
+	pos := make(map[string]int)
+	i := 0
+	for e := range events {
+		pos[e] = i
+		i++
+	}
+	for _, name := range []string{"vm", "irc"} {
+		if pos["done "+name] > pos["start event"] {
+			t.Errorf("expected %s to finish shutting down before event starts", name)
+		}
+	}
+}
+
+func TestManager_prepareReplayReload(t *testing.T) {
+	m := plugin.NewManager()
+	m.RegisterFunc(config.Initialize)
+	if err := configure(m); err != nil {
+		t.Fatalf("unexpected error loading config plugin: %s", err)
+	}
+	if err := config.ConfigurePlugin(m, config.WithOption("root_path"), config.WithValuesFromTOMLFile("../testdata/builtin.toml")); err != nil {
+		t.Fatalf("unexpected error configuring: %s", err)
+	}
+	for _, in := range builtinPlugins() {
+		m.Register(in)
+	}
+	if err := configure(m); err != nil {
+		t.Fatalf("unexpected error configuring plugins: %s", err)
+	}
+	manager := &Manager{plugins: m}
+	manager.prepareReplay()
+	if err := m.Reload("irc"); err != nil {
+		t.Fatalf("unexpected error reloading irc: %s", err)
+	}
+	ircm, err := irc.NetworksFromPlugins(m)
+	if err != nil {
+		t.Fatalf("unable to get irc networks: %s", err)
+	}
+	if !ircm.Default().Disabled() {
+		t.Errorf("expected irc to remain disabled after reloading")
+	}
+}
@@ -18,6 +18,9 @@ import (
 var Version = "SNAPSHOT"
 
 var interactive bool
+var listPlugins bool
+var replayFile string
+var replaySpeed float64
 
 func unboxAll(rootDir string) (modified bool, err error) {
 	if _, err = os.Stat(filepath.Join(rootDir, "config.toml")); err == nil {
@@ -51,6 +54,9 @@ func init() {
 	printVersion := false
 	flag.BoolVar(&interactive, "interactive", false, "start interactive-read-evaluate-print (REPL) session")
 	flag.BoolVar(&printVersion, "version", false, "print version information")
+	flag.BoolVar(&listPlugins, "list-plugins", false, "list the plugins found in the plugin path and exit")
+	flag.StringVar(&replayFile, "replay", "", "replay the events recorded in the given journal file with irc disabled")
+	flag.Float64Var(&replaySpeed, "replay-speed", 0, "speed to replay events at; 1 is real time, 0 is as fast as possible")
 	cli.DefaultFlags(flag.CommandLine)
 
 	flag.Usage = func() {
@@ -85,16 +91,29 @@ func main() {
 		if err != nil {
 			logrus.Fatalln("core: error initializing squircy:", err)
 		}
+		m.LinkedPlugins = append(m.LinkedPlugins, linkedPlugins...)
 		logrus.SetLevel(m.LogLevel)
 	}
+	if listPlugins {
+		if err := m.ListPlugins(os.Stdout); err != nil {
+			logrus.Fatalln("core: error listing plugins:", err)
+		}
+		os.Exit(0)
+	}
+	if replayFile != "" {
+		if err := m.Replay(replayFile, replaySpeed); err != nil {
+			logrus.Fatalln("core: error replaying events:", err)
+		}
+	}
 	if err := m.Start(); err != nil {
 		logrus.Fatalln("core: error starting squircy:", err)
 	}
-	ircm, err := irc.FromPlugins(m.Plugins())
-	if err != nil {
+	// the version string is set again if the irc plugin is reloaded.
+	if err := irc.InjectNetworks(m.Plugins(), func(ircm *irc.Networks) {
+		ircm.SetVersionString(fmt.Sprintf("squircy3 %s", Version))
+	}); err != nil {
 		logrus.Errorln("core: failed to set irc version string:", err)
 	}
-	ircm.SetVersionString(fmt.Sprintf("squircy3 %s", Version))
 	if interactive {
 		go Repl(m)
 	}
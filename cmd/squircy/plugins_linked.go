@@ -14,8 +14,8 @@ import (
 )
 
 var linkedPlugins = []plugin.Initializer{
-	plugin.InitializerFunc(babel.Initialize),
-	plugin.InitializerFunc(discord.Initialize),
-	plugin.InitializerFunc(node_compat2.Initialize),
-	plugin.InitializerFunc(script.Initialize),
-	plugin.InitializerFunc(squircy2_compat.Initialize)}
+	plugin.InitializeWithRequires(babel.PluginName, babel.Initialize, babel.Requires()...),
+	plugin.InitializeWithRequires(discord.PluginName, discord.Initialize, discord.Requires()...),
+	plugin.InitializeWithRequires(node_compat2.PluginName, node_compat2.Initialize, node_compat2.Requires()...),
+	plugin.InitializeWithRequires(script.PluginName, script.Initialize, script.Requires()...),
+	plugin.InitializeWithRequires(squircy2_compat.PluginName, squircy2_compat.Initialize, squircy2_compat.Requires()...)}
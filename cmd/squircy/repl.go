@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/peterh/liner"
 	"github.com/sirupsen/logrus"
@@ -41,6 +45,7 @@ func Repl(manager *cli.Manager) {
 	}
 	fmt.Println("Starting javascript REPL...")
 	fmt.Println("Type 'exit' and hit enter to exit the REPL.")
+	fmt.Println("Type 'status' and hit enter to print the status of each plugin.")
 	ctrlcs := 0
 	for {
 		str, err := input.Prompt("repl> ")
@@ -58,6 +63,10 @@ func Repl(manager *cli.Manager) {
 			continue
 		}
 		input.AppendHistory(str)
+		if str == "status" {
+			printStatus(manager)
+			continue
+		}
 		v, err := jsVM.RunString(str).Await()
 		if err != nil {
 			logrus.Warnln("error:", err)
@@ -65,3 +74,25 @@ func Repl(manager *cli.Manager) {
 		fmt.Println(v)
 	}
 }
+
+// printStatus writes the status of each loaded plugin to stdout.
+func printStatus(manager *cli.Manager) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLUGIN\tCONFIGURED\tCONNECTED\tUPTIME\tLAST ERROR\tDETAILS")
+	for _, st := range manager.Plugins().Status() {
+		lastErr := "-"
+		if st.LastError != nil {
+			lastErr = st.LastError.Error()
+		}
+		var details []string
+		for k, v := range st.Details {
+			details = append(details, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(details)
+		fmt.Fprintf(w, "%s\t%t\t%t\t%s\t%s\t%s\n",
+			st.Name, st.Configured, st.Connected, st.Uptime.Truncate(time.Second), lastErr, strings.Join(details, " "))
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Warnln("failed to print status:", err)
+	}
+}
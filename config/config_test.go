@@ -2,9 +2,13 @@ package config_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 
 	"code.dopame.me/veonik/squircy3/config"
+	"code.dopame.me/veonik/squircy3/event"
+	"code.dopame.me/veonik/squircy3/irc"
 )
 
 func TestWrapWithFieldStructPointer(t *testing.T) {
@@ -80,3 +84,37 @@ func TestWrapWithFieldStructNonPointer(t *testing.T) {
 	// Hi, veonik!
 	// veonik is 30.
 }
+
+func TestWrapWithNumericValuesFromTOMLFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "squircy3-config")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	// TOML decodes every integer as int64 and every float as float64.
+	_, err = f.WriteString(`
+max_handler_failures=3
+shards=4
+send_burst=5
+send_rate=2
+reconnect_jitter=0.25
+`)
+	f.Close()
+	if err != nil {
+		t.Fatalf("failed to write temp file: %s", err)
+	}
+	ec := &event.Config{}
+	if _, err := config.Wrap(ec, config.WithValuesFromTOMLFile(f.Name())); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ec.MaxHandlerFailures != 3 || ec.Shards != 4 {
+		t.Errorf("expected integer options to be set, got %+v", ec)
+	}
+	ic := &irc.Config{}
+	if _, err := config.Wrap(ic, config.WithValuesFromTOMLFile(f.Name())); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if ic.SendBurst != 5 || ic.SendRate != 2 || ic.ReconnectJitter != 0.25 {
+		t.Errorf("expected integer and float options to be set, got burst %d, rate %f, jitter %f", ic.SendBurst, ic.SendRate, ic.ReconnectJitter)
+	}
+}
@@ -29,13 +29,16 @@ func ConfigurePlugin(m *plugin.Manager, opts ...SetupOption) error {
 
 // Initialize is a plugin.Initializer that initializes a config plugin.
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
-	p := &configPlugin{}
+	p := &configPlugin{sections: make(map[string]struct{})}
 	return p, nil
 }
 
 type configPlugin struct {
 	baseOptions []SetupOption
 	current     Config
+
+	// sections contains the names of the sections already added for plugins.
+	sections map[string]struct{}
 }
 
 // A configurablePlugin is a plugin that can be configured using this package.
@@ -51,7 +54,15 @@ func (p *configPlugin) HandlePluginInit(op plugin.Plugin) {
 	if !ok {
 		return
 	}
-	err := p.Configure(WithGenericSection(cp.Name(), cp.Options()...))
+	var opts []SetupOption
+	if _, ok := p.sections[cp.Name()]; !ok {
+		// the plugin is being initialized for the first time; add its section.
+		// otherwise the plugin is being reloaded and its existing section is
+		// refreshed from the configured sources.
+		opts = append(opts, WithGenericSection(cp.Name(), cp.Options()...))
+		p.sections[cp.Name()] = struct{}{}
+	}
+	err := p.Configure(opts...)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"math"
 	"reflect"
 
 	"github.com/fatih/structtag"
@@ -202,10 +203,12 @@ func (i *valueInspector) Set(name string, val Value) {
 			return
 		}
 	}
-	trySet(m, rv)
+	if err := trySet(m, rv); err != nil {
+		logrus.Warnf("config: unable to set option %s: %s", name, err)
+	}
 }
 
-func trySet(m reflect.Value, rv reflect.Value) {
+func trySet(m reflect.Value, rv reflect.Value) (err error) {
 	defer func() {
 		if v := recover(); v != nil {
 			logrus.Debugln("config: failed to set value using reflection:", v)
@@ -218,9 +221,115 @@ func trySet(m reflect.Value, rv reflect.Value) {
 			rv = reflect.Indirect(rv)
 		} else if have == reflect.Ptr {
 			rv = rv.Elem()
+		} else if isNumeric(want) && isNumeric(have) {
+			// TOML decodes all integers as int64 and all floats as float64.
+			rv, err = convertNumeric(rv, m.Type())
+			if err != nil {
+				return err
+			}
 		}
 	}
 	m.Set(rv)
+	return nil
+}
+
+// convertNumeric converts the numeric value rv to the numeric type t,
+// returning an error if the value cannot be represented exactly.
+func convertNumeric(rv reflect.Value, t reflect.Type) (reflect.Value, error) {
+	res := reflect.New(t).Elem()
+	lossy := func() (reflect.Value, error) {
+		return res, errors.Errorf("value %v cannot be represented as %s", rv.Interface(), t)
+	}
+	switch {
+	case isInt(t.Kind()):
+		var v int64
+		switch {
+		case isInt(rv.Kind()):
+			v = rv.Int()
+		case isUint(rv.Kind()):
+			if rv.Uint() > math.MaxInt64 {
+				return lossy()
+			}
+			v = int64(rv.Uint())
+		default:
+			f := rv.Float()
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return lossy()
+			}
+			v = int64(f)
+		}
+		if res.OverflowInt(v) {
+			return lossy()
+		}
+		res.SetInt(v)
+
+	case isUint(t.Kind()):
+		var v uint64
+		switch {
+		case isInt(rv.Kind()):
+			if rv.Int() < 0 {
+				return lossy()
+			}
+			v = uint64(rv.Int())
+		case isUint(rv.Kind()):
+			v = rv.Uint()
+		default:
+			f := rv.Float()
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return lossy()
+			}
+			v = uint64(f)
+		}
+		if res.OverflowUint(v) {
+			return lossy()
+		}
+		res.SetUint(v)
+
+	default:
+		var v float64
+		switch {
+		case isInt(rv.Kind()):
+			v = float64(rv.Int())
+		case isUint(rv.Kind()):
+			v = float64(rv.Uint())
+		default:
+			v = rv.Float()
+		}
+		if res.OverflowFloat(v) {
+			return lossy()
+		}
+		res.SetFloat(v)
+	}
+	return res, nil
+}
+
+// isInt returns true if k is a signed integer kind.
+func isInt(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// isUint returns true if k is an unsigned integer kind.
+func isUint(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isNumeric returns true if k is an integer or floating point kind.
+func isNumeric(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
 }
 
 func (i *valueInspector) valueNamed(name string) (Value, error) {
@@ -69,3 +69,88 @@ func TestConfigurable_withStruct(t *testing.T) {
 		t.Fatalf("expected value to contain 'value', got '%s'", vs)
 	}
 }
+
+type numericConfig struct {
+	Count int
+	Ratio float32
+}
+
+func TestConfigurable_withNumericConversion(t *testing.T) {
+	co := &numericConfig{}
+	s := newSetup("root", nil)
+	if err := s.apply(WithInitValue(co)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c, err := newConfigurable(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// values decoded from TOML are always int64 or float64.
+	c.Set("Count", int64(4))
+	c.Set("Ratio", float64(0.5))
+	if co.Count != 4 {
+		t.Errorf("expected Count field on struct to be 4, got %d", co.Count)
+	}
+	if co.Ratio != 0.5 {
+		t.Errorf("expected Ratio field on struct to be 0.5, got %f", co.Ratio)
+	}
+	if v, ok := c.Int("Count"); !ok || v != 4 {
+		t.Errorf("expected Int call to return 4, got %d", v)
+	}
+}
+
+type lossyConfig struct {
+	Count uint8
+	Size  int
+	Ratio float32
+}
+
+func TestConfigurable_withLossyNumericConversion(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value Value
+	}{
+		{"truncated float", "Size", float64(1.9)},
+		{"negative unsigned", "Count", int64(-1)},
+		{"unsigned overflow", "Count", int64(256)},
+		{"fractional unsigned", "Count", float64(0.5)},
+		{"float overflow", "Size", float64(1e300)},
+		{"float32 overflow", "Ratio", float64(1e300)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			co := &lossyConfig{Count: 1, Size: 2, Ratio: 3}
+			s := newSetup("root", nil)
+			if err := s.apply(WithInitValue(co)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			c, err := newConfigurable(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			c.Set(tt.key, tt.value)
+			if *co != (lossyConfig{Count: 1, Size: 2, Ratio: 3}) {
+				t.Errorf("expected struct to be unchanged, got %+v", *co)
+			}
+		})
+	}
+}
+
+func TestConfigurable_withExactNumericConversion(t *testing.T) {
+	co := &lossyConfig{}
+	s := newSetup("root", nil)
+	if err := s.apply(WithInitValue(co)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c, err := newConfigurable(s)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c.Set("Count", int64(255))
+	c.Set("Size", float64(-3))
+	c.Set("Ratio", int64(2))
+	if *co != (lossyConfig{Count: 255, Size: -3, Ratio: 2}) {
+		t.Errorf("unexpected struct values: %+v", *co)
+	}
+}
@@ -2,8 +2,13 @@
 package event // import "code.dopame.me/veonik/squircy3/event"
 
 import (
+	"context"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,6 +19,13 @@ type Event struct {
 	Data map[string]interface{}
 
 	handled bool
+
+	// ctx is non-nil if the event was emitted synchronously.
+	ctx context.Context
+
+	mu      sync.Mutex
+	replies []interface{}
+	errs    []error
 }
 
 // StopPropagation will stop any further handlers being fired for this event.
@@ -21,6 +33,68 @@ func (e *Event) StopPropagation() {
 	e.handled = true
 }
 
+// Synchronous returns true if the emitter is waiting for handlers to
+// complete, as with EmitSync and Request.
+func (e *Event) Synchronous() bool {
+	return e.ctx != nil
+}
+
+// Context returns the context the event was emitted with.
+// Events emitted with Emit use context.Background.
+func (e *Event) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+// Reply adds a value to the results returned to the emitter.
+// Replies are only returned for events emitted with Request; in other cases
+// the value is discarded.
+func (e *Event) Reply(v interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replies = append(e.replies, v)
+}
+
+// Fail reports an error to the emitter.
+// Errors are returned for events emitted with EmitSync or Request. Failing
+// does not stop further handlers from being fired; call StopPropagation as
+// well to prevent them from seeing a vetoed event.
+func (e *Event) Fail(err error) {
+	if err == nil {
+		return
+	}
+	if !e.Synchronous() {
+		logrus.Warnf("error handling event %s: %s", e.Name, err)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.errs = append(e.errs, err)
+}
+
+// result returns the replies and errors collected for the event.
+func (e *Event) result() ([]interface{}, []error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]interface{}(nil), e.replies...), append([]error(nil), e.errs...)
+}
+
+// An EmitError is returned when one or more handlers fail while handling a
+// synchronous event.
+type EmitError struct {
+	Name   string
+	Errors []error
+}
+
+func (e *EmitError) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err.Error()
+	}
+	return fmt.Sprintf("error handling event (%s): %s", e.Name, strings.Join(errs, "; "))
+}
+
 // A Handler is a uniquely identifiable function for handling an emitted event.
 type Handler interface {
 	ID() string
@@ -44,15 +118,73 @@ func (h *handlerFunc) Handle(ev *Event) {
 	h.h(ev)
 }
 
+// DefaultPriority is the priority of handlers bound without a priority.
+const DefaultPriority = 0
+
+// binding is a handler bound to an event with a priority.
+type binding struct {
+	// name is the event name or pattern the handler is bound to.
+	name     string
+	handler  Handler
+	priority int
+	// seq is incremented with each binding to preserve bind order across
+	// exact names and patterns.
+	seq uint64
+}
+
+// IsPattern returns true if the given event name contains any of the
+// special characters used in patterns.
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
 // Dispatcher binds functions to be called indirectly by unrelated code.
+//
+// Handlers may be bound to an exact event name or to a glob-style pattern,
+// such as "irc.*" or "*.MESSAGE". Patterns use the syntax of path.Match.
 type Dispatcher struct {
 	handlersIndex map[string]map[string]struct{}
-	handlers      map[string][]Handler
+	// handlers for each event or pattern, sorted by priority (highest first)
+	// then by the order they were bound.
+	handlers map[string][]binding
+	// patterns contains the bound names that are patterns.
+	patterns map[string]struct{}
+	seq      uint64
 
 	mu sync.RWMutex
 
 	emitting chan *Event
 	quit     chan struct{}
+
+	// policy determines what happens when emitting is full.
+	policy          OverflowPolicy
+	overflowTimeout time.Duration
+
+	// dropped is the total number of dropped events, accessed atomically.
+	dropped uint64
+	// dropNotice is signaled when an event is dropped.
+	dropNotice chan struct{}
+
+	// shardCount is the number of workers events are sharded across.
+	shardCount int
+	shardKey   ShardKeyFunc
+	// shards contains the queue for each worker while the Dispatcher is
+	// running, or nil if it is not sharded.
+	shards []chan *Event
+
+	// journal records emitted events, if set.
+	journal *Journal
+
+	// maxFailures is the number of consecutive panics after which a handler
+	// is unbound.
+	maxFailures int
+	failuresMu  sync.Mutex
+	failures    map[string]int
+
+	droppedMu          sync.Mutex
+	droppedByName      map[string]uint64
+	droppedSinceNotice uint64
+	lastDropped        string
 }
 
 // NewDispatcher returns an event dispatcher, ready for use.
@@ -64,9 +196,16 @@ func NewDispatcher() *Dispatcher {
 func NewDispatcherLimit(limit int) *Dispatcher {
 	return &Dispatcher{
 		handlersIndex: make(map[string]map[string]struct{}),
-		handlers:      make(map[string][]Handler),
+		handlers:      make(map[string][]binding),
+		patterns:      make(map[string]struct{}),
 		emitting:      make(chan *Event, limit),
 		quit:          make(chan struct{}),
+
+		overflowTimeout: DefaultOverflowTimeout,
+		dropNotice:      make(chan struct{}, 1),
+		droppedByName:   make(map[string]uint64),
+		failures:        make(map[string]int),
+		shardKey:        ShardByTarget,
 	}
 }
 
@@ -90,18 +229,28 @@ func (d *Dispatcher) Stop() {
 //
 // If the Dispatcher is not running when Loop is called, it will be started.
 // This method should be called in a separate goroutine. More than one worker
-// can be started by calling this method multiple times in separate goroutines.
+// can be started by calling this method multiple times in separate goroutines,
+// though events are then handled in no particular order.
+//
+// If the Dispatcher is sharded with SetShards, Loop starts the pool of
+// workers and passes each event to the worker responsible for it. Only one
+// call to Loop is necessary in this case; additional calls do not preserve
+// the order of events with the same key.
 func (d *Dispatcher) Loop() {
 	d.mu.Lock()
 	select {
 	case <-d.quit:
 		// closed, need to recreate
 		d.quit = make(chan struct{})
+		d.shards = nil
 	default:
 		// already started
 	}
 	// avoid data race by reading this inside the lock
 	quit := d.quit
+	d.startShards(quit)
+	shards := d.shards
+	key := d.shardKey
 	// "emitting" is not necessary to protect in this way as nothing
 	// ever writes to the "emitting" field.
 	d.mu.Unlock()
@@ -112,72 +261,234 @@ func (d *Dispatcher) Loop() {
 			return
 
 		case ev := <-d.emitting:
-			for _, h := range d.handlersForEvent(ev.Name) {
-				h.Handle(ev)
-				if ev.handled {
-					break
-				}
-			}
+			// events are recorded once they are taken from the queue so
+			// that dropped events are not recorded.
+			d.record(ev)
+			d.dispatch(ev, shards, key, quit)
+
+		case <-d.dropNotice:
+			d.dispatch(d.droppedEvent(), shards, key, quit)
+		}
+	}
+}
+
+// handle calls each handler bound to the event until propagation is stopped.
+// A handler that panics does not prevent the remaining handlers from being
+// called.
+func (d *Dispatcher) handle(ev *Event) {
+	for _, b := range d.handlersForEvent(ev.Name) {
+		d.call(b, ev)
+		if ev.handled {
+			break
 		}
 	}
 }
 
-// handlersForEvent returns a copy of the handlers for the given event.
-func (d *Dispatcher) handlersForEvent(name string) []Handler {
+// Running returns true if the dispatcher has not been stopped.
+func (d *Dispatcher) Running() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	select {
+	case <-d.quit:
+		return false
+	default:
+		return true
+	}
+}
+
+// Pending returns the number of emitted events waiting to be handled.
+func (d *Dispatcher) Pending() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	n := len(d.emitting)
+	for _, ch := range d.shards {
+		n += len(ch)
+	}
+	return n
+}
+
+// Bound returns the number of handlers bound across all events.
+func (d *Dispatcher) Bound() int {
 	d.mu.RLock()
 	defer d.mu.RUnlock()
-	return append([]Handler(nil), d.handlers[name]...)
+	n := 0
+	for _, hs := range d.handlers {
+		n += len(hs)
+	}
+	return n
+}
+
+// handlersForEvent returns a copy of the bindings for the given event,
+// including those bound to matching patterns, in the order they should be
+// called.
+func (d *Dispatcher) handlersForEvent(name string) []binding {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	bs := append([]binding(nil), d.handlers[name]...)
+	matched := false
+	for p := range d.patterns {
+		if p == name {
+			// already included
+			continue
+		}
+		if ok, _ := path.Match(p, name); ok {
+			bs = append(bs, d.handlers[p]...)
+			matched = true
+		}
+	}
+	if matched {
+		sort.SliceStable(bs, func(i, j int) bool {
+			if bs[i].priority != bs[j].priority {
+				return bs[i].priority > bs[j].priority
+			}
+			return bs[i].seq < bs[j].seq
+		})
+	}
+	return bs
 }
 
 // Emit will call bound handlers for the given in event.
 //
-// This method does not block unless the underlying channel becomes full.
+// This method does not block unless the underlying channel becomes full, in
+// which case the Dispatcher's OverflowPolicy determines whether the caller
+// blocks or an event is dropped. Each time events are dropped, an
+// "event.DROPPED" event is emitted containing the name of the last dropped
+// event, the number dropped since the previous notification, and the total.
 // The map received by this method is not copied; avoid writing to it once
 // it has been passed into this method.
 func (d *Dispatcher) Emit(name string, data map[string]interface{}) {
-	d.emitting <- &Event{Name: name, Data: data}
+	d.enqueue(&Event{Name: name, Data: data})
+}
+
+// EmitSync calls bound handlers for the given event in the calling goroutine,
+// returning once all handlers have completed.
+//
+// If any handlers call Fail on the Event, an *EmitError is returned
+// containing each error. If the context is done before all handlers have
+// been called, the remaining handlers are skipped and the context's error is
+// returned.
+func (d *Dispatcher) EmitSync(ctx context.Context, name string, data map[string]interface{}) error {
+	_, err := d.Request(ctx, name, data)
+	return err
+}
+
+// Request calls bound handlers for the given event in the calling goroutine
+// and returns the values passed to Reply by each handler, in order.
+//
+// Errors are returned in the same way as EmitSync. Replies made before an
+// error occurred are returned along with the error.
+func (d *Dispatcher) Request(ctx context.Context, name string, data map[string]interface{}) ([]interface{}, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ev := &Event{Name: name, Data: data, ctx: ctx}
+	d.record(ev)
+	for _, b := range d.handlersForEvent(name) {
+		if err := ctx.Err(); err != nil {
+			replies, _ := ev.result()
+			return replies, err
+		}
+		if err := d.call(b, ev); err != nil {
+			ev.Fail(err)
+		}
+		if ev.handled {
+			break
+		}
+	}
+	replies, errs := ev.result()
+	if len(errs) > 0 {
+		return replies, &EmitError{Name: name, Errors: errs}
+	}
+	return replies, nil
 }
 
-// Bind adds the given handler to the list of handlers for the event.
+// Bind adds the given handler to the list of handlers for the event with
+// the default priority.
 func (d *Dispatcher) Bind(name string, handler Handler) {
+	d.BindPriority(name, handler, DefaultPriority)
+}
+
+// BindPriority adds the given handler to the list of handlers for the event
+// with the given priority.
+//
+// Handlers with a higher priority are called before handlers with a lower
+// priority. Handlers with the same priority are called in the order they
+// were bound. A handler may call StopPropagation on the Event to prevent
+// handlers with a lower priority from being called.
+//
+// Binding a handler that is already bound to the event replaces the existing
+// binding, moving the handler to the end of those with the given priority.
+//
+// The name may be a pattern, in which case the handler is called for every
+// event with a name matching the pattern. The Name field of the Event
+// contains the name of the emitted event.
+func (d *Dispatcher) BindPriority(name string, handler Handler, priority int) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if IsPattern(name) {
+		if _, err := path.Match(name, ""); err != nil {
+			logrus.Warnf("binding handler for invalid pattern %s: %s", name, err)
+		}
+		d.patterns[name] = struct{}{}
+	}
 	id := handler.ID()
 	if _, ok := d.handlersIndex[name]; !ok {
 		d.handlersIndex[name] = make(map[string]struct{})
 	}
 	if _, ok := d.handlersIndex[name][id]; ok {
 		logrus.Warnln("rebinding handler for", name, id)
+		d.removeHandler(name, id)
 	} else {
-		logrus.Debugln("binding handler for", name, id)
+		logrus.Debugln("binding handler for", name, id, "with priority", priority)
 	}
 	d.handlersIndex[name][id] = struct{}{}
-	d.handlers[name] = append(d.handlers[name], handler)
+	hs := d.handlers[name]
+	// insert after all handlers with the same or higher priority.
+	i := sort.Search(len(hs), func(i int) bool {
+		return hs[i].priority < priority
+	})
+	hs = append(hs, binding{})
+	copy(hs[i+1:], hs[i:])
+	d.seq++
+	hs[i] = binding{name: name, handler: handler, priority: priority, seq: d.seq}
+	d.handlers[name] = hs
+}
+
+// removeHandler removes the handler with the given ID from the event.
+// The Dispatcher must be locked when calling this method.
+func (d *Dispatcher) removeHandler(name, id string) {
+	for i, b := range d.handlers[name] {
+		if b.handler.ID() == id {
+			d.handlers[name] = append(d.handlers[name][:i], d.handlers[name][i+1:]...)
+			break
+		}
+	}
+	if len(d.handlers[name]) == 0 {
+		delete(d.handlers, name)
+		delete(d.patterns, name)
+	}
 }
 
 // Unbind removes the given handler from the list of handlers for the event.
 func (d *Dispatcher) Unbind(name string, handler Handler) {
+	d.unbind(name, handler)
+}
+
+// unbind removes the handler, returning true if it was bound.
+func (d *Dispatcher) unbind(name string, handler Handler) bool {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	id := handler.ID()
 	if _, ok := d.handlersIndex[name]; !ok {
 		logrus.Debugln("not unbinding anything for", name, id)
-		return
+		return false
 	}
 	if _, ok := d.handlersIndex[name][id]; !ok {
 		logrus.Debugln("not unbinding anything for", name, id)
-		return
+		return false
 	}
 	logrus.Debugln("unbinding handler for", name, id)
 	delete(d.handlersIndex[name], id)
-	hi := -1
-	for i, h := range d.handlers[name] {
-		if h.ID() == id {
-			hi = i
-		}
-	}
-	if hi < 0 {
-		return
-	}
-	d.handlers[name] = append(d.handlers[name][:hi], d.handlers[name][hi+1:]...)
+	d.removeHandler(name, id)
+	return true
 }
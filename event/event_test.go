@@ -1,7 +1,11 @@
 package event_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -78,3 +82,499 @@ func TestEvent_StopPropagation(t *testing.T) {
 		t.Fatal("did not expect third event to fire")
 	}
 }
+
+func TestDispatcher_BindPriority(t *testing.T) {
+	d := event.NewDispatcherLimit(0)
+	go d.Loop()
+	defer d.Stop()
+	var order []string
+	bind := func(name string, priority int, stop bool) {
+		d.BindPriority("test.event", event.HandlerFunc(func(ev *event.Event) {
+			order = append(order, name)
+			if stop {
+				ev.StopPropagation()
+			}
+		}), priority)
+	}
+	bind("default1", event.DefaultPriority, false)
+	bind("low", -10, false)
+	bind("high", 10, false)
+	bind("default2", event.DefaultPriority, true)
+	bind("highest", 20, false)
+	d.Emit("test.event", nil)
+	// block until the "test.event" event is finished being emitted.
+	d.Emit("unknown", nil)
+	expected := []string{"highest", "high", "default1", "default2"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected handlers %v to fire, got %v", expected, order)
+	}
+	for i, v := range expected {
+		if order[i] != v {
+			t.Fatalf("expected handlers %v to fire, got %v", expected, order)
+		}
+	}
+}
+
+func TestDispatcher_BindPattern(t *testing.T) {
+	d := event.NewDispatcherLimit(0)
+	go d.Loop()
+	defer d.Stop()
+	var names []string
+	bind := func(pattern string) event.Handler {
+		h := event.HandlerFunc(func(ev *event.Event) {
+			names = append(names, pattern+" "+ev.Name)
+		})
+		d.Bind(pattern, h)
+		return h
+	}
+	bind("irc.*")
+	h := bind("*.MESSAGE")
+	bind("irc.PRIVMSG")
+	d.Emit("irc.PRIVMSG", nil)
+	d.Emit("discord.MESSAGE", nil)
+	d.Emit("irc.MESSAGE", nil)
+	d.Unbind("*.MESSAGE", h)
+	d.Emit("discord.MESSAGE", nil)
+	// block until the previous events are finished being emitted.
+	d.Emit("unknown", nil)
+	expected := []string{
+		"irc.* irc.PRIVMSG",
+		"irc.PRIVMSG irc.PRIVMSG",
+		"*.MESSAGE discord.MESSAGE",
+		"irc.* irc.MESSAGE",
+		"*.MESSAGE irc.MESSAGE",
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("expected handlers %v to fire, got %v", expected, names)
+	}
+	for i, v := range expected {
+		if names[i] != v {
+			t.Fatalf("expected handlers %v to fire, got %v", expected, names)
+		}
+	}
+}
+
+func TestDispatcher_Request(t *testing.T) {
+	d := event.NewDispatcher()
+	d.Bind("command.lookup", event.HandlerFunc(func(ev *event.Event) {
+		if !ev.Synchronous() {
+			t.Errorf("expected event to be synchronous")
+		}
+		if ev.Data["command"] == "help" {
+			ev.Reply("help handler")
+		}
+	}))
+	d.Bind("command.*", event.HandlerFunc(func(ev *event.Event) {
+		ev.Reply("fallback handler")
+	}))
+	// the dispatcher need not be running to emit synchronously.
+	res, err := d.Request(context.Background(), "command.lookup", map[string]interface{}{"command": "help"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res) != 2 || res[0] != "help handler" || res[1] != "fallback handler" {
+		t.Errorf("unexpected replies: %v", res)
+	}
+}
+
+func TestDispatcher_EmitSync(t *testing.T) {
+	d := event.NewDispatcher()
+	called := false
+	d.Bind("irc.PRESEND", event.HandlerFunc(func(ev *event.Event) {
+		ev.Fail(errors.New("message contains forbidden word"))
+		ev.StopPropagation()
+	}))
+	d.Bind("irc.PRESEND", event.HandlerFunc(func(ev *event.Event) {
+		called = true
+	}))
+	err := d.EmitSync(context.Background(), "irc.PRESEND", nil)
+	if e, ok := err.(*event.EmitError); !ok || len(e.Errors) != 1 {
+		t.Fatalf("expected *event.EmitError with 1 error, got %T: %v", err, err)
+	}
+	if called {
+		t.Errorf("did not expect second handler to be called")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.Bind("test.event", event.HandlerFunc(func(ev *event.Event) {
+		cancel()
+	}))
+	d.Bind("test.event", event.HandlerFunc(func(ev *event.Event) {
+		t.Errorf("did not expect handler to be called after context is canceled")
+	}))
+	if err := d.EmitSync(ctx, "test.event", nil); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDispatcher_OverflowPolicy(t *testing.T) {
+	emit := func(d *event.Dispatcher) {
+		for i := 0; i < 4; i++ {
+			d.Emit("test.event", map[string]interface{}{"i": i})
+		}
+	}
+	handled := func(d *event.Dispatcher) []interface{} {
+		handled := make(chan interface{}, 4)
+		dropped := make(chan map[string]interface{}, 1)
+		done := make(chan struct{})
+		d.Bind("test.event", event.HandlerFunc(func(ev *event.Event) {
+			handled <- ev.Data["i"]
+		}))
+		d.Bind("event.DROPPED", event.HandlerFunc(func(ev *event.Event) {
+			dropped <- ev.Data
+		}))
+		d.Bind("test.done", event.HandlerFunc(func(ev *event.Event) {
+			close(done)
+		}))
+		go d.Loop()
+		defer d.Stop()
+		select {
+		case dat := <-dropped:
+			if dat["name"] != "test.event" || dat["count"] != uint64(2) || dat["total"] != uint64(2) {
+				t.Errorf("unexpected event.DROPPED data: %v", dat)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected event.DROPPED to be emitted")
+		}
+		// wait for room in the queue so that test.done is not dropped.
+		for d.Pending() > 0 {
+			<-time.After(time.Millisecond)
+		}
+		// events are handled in order, so test.done is handled last.
+		d.Emit("test.done", nil)
+		<-done
+		close(handled)
+		var res []interface{}
+		for v := range handled {
+			res = append(res, v)
+		}
+		return res
+	}
+	expect := func(policy event.OverflowPolicy, expected ...interface{}) {
+		d := event.NewDispatcherLimit(2)
+		d.SetOverflowPolicy(policy, 10*time.Millisecond)
+		emit(d)
+		if n := d.Dropped(); n != 2 {
+			t.Errorf("%s: expected 2 dropped events, got %d", policy, n)
+		}
+		if n := d.DroppedEvents()["test.event"]; n != 2 {
+			t.Errorf("%s: expected 2 dropped test.event events, got %d", policy, n)
+		}
+		res := handled(d)
+		if len(res) != len(expected) {
+			t.Fatalf("%s: expected %v to be handled, got %v", policy, expected, res)
+		}
+		for i, v := range expected {
+			if res[i] != v {
+				t.Fatalf("%s: expected %v to be handled, got %v", policy, expected, res)
+			}
+		}
+	}
+	expect(event.OverflowDropNewest, 0, 1)
+	expect(event.OverflowDropOldest, 2, 3)
+	expect(event.OverflowBlockTimeout, 0, 1)
+
+	if p, err := event.ParseOverflowPolicy("drop-oldest"); err != nil || p != event.OverflowDropOldest {
+		t.Errorf("expected drop-oldest to parse, got %s: %v", p, err)
+	}
+	if _, err := event.ParseOverflowPolicy("nope"); err == nil {
+		t.Errorf("expected error parsing unknown policy")
+	}
+}
+
+func TestDispatcher_OverflowDropOldestUnbuffered(t *testing.T) {
+	d := event.NewDispatcherLimit(0)
+	d.SetOverflowPolicy(event.OverflowDropOldest, 0)
+	handled := make(chan interface{}, 2)
+	d.Bind("test.event", event.HandlerFunc(func(ev *event.Event) {
+		handled <- ev.Data["i"]
+	}))
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		d.Emit("test.event", map[string]interface{}{"i": 0})
+	}()
+	select {
+	case <-emitted:
+		t.Fatalf("expected emit to block until a worker is available")
+	case <-time.After(10 * time.Millisecond):
+	}
+	go d.Loop()
+	defer d.Stop()
+	<-emitted
+	select {
+	case v := <-handled:
+		if v != 0 {
+			t.Errorf("expected event 0 to be handled, got %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected event to be handled")
+	}
+	if n := d.Dropped(); n != 0 {
+		t.Errorf("expected no dropped events, got %d", n)
+	}
+}
+
+func TestDispatcher_HandlerPanic(t *testing.T) {
+	d := event.NewDispatcherLimit(0)
+	d.SetMaxFailures(2)
+	go d.Loop()
+	defer d.Stop()
+	var errs []map[string]interface{}
+	calls := 0
+	d.Bind("event.ERROR", event.HandlerFunc(func(ev *event.Event) {
+		errs = append(errs, ev.Data)
+	}))
+	h := event.HandlerFunc(func(ev *event.Event) {
+		calls++
+		panic("oh no")
+	})
+	d.Bind("test.event", h)
+	handled := 0
+	d.Bind("test.event", event.HandlerFunc(func(ev *event.Event) {
+		handled++
+	}))
+	for i := 0; i < 3; i++ {
+		d.Emit("test.event", nil)
+	}
+	// block until the previous events are finished being emitted.
+	d.Emit("unknown", nil)
+	if calls != 2 {
+		t.Errorf("expected panicking handler to be unbound after 2 calls, got %d", calls)
+	}
+	if handled != 3 {
+		t.Errorf("expected other handler to be called 3 times, got %d", handled)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 event.ERROR events, got %d", len(errs))
+	}
+	if errs[0]["name"] != "test.event" || errs[0]["handler"] != h.ID() || errs[0]["error"] != "oh no" {
+		t.Errorf("unexpected event.ERROR data: %v", errs[0])
+	}
+
+	d.Bind("test.sync", event.HandlerFunc(func(ev *event.Event) {
+		panic("oh no")
+	}))
+	err := d.EmitSync(context.Background(), "test.sync", nil)
+	if e, ok := err.(*event.EmitError); !ok || len(e.Errors) != 1 {
+		t.Fatalf("expected *event.EmitError with 1 error, got %T: %v", err, err)
+	} else if _, ok := e.Errors[0].(*event.PanicError); !ok {
+		t.Errorf("expected *event.PanicError, got %T", e.Errors[0])
+	}
+}
+
+func TestDispatcher_SetShards(t *testing.T) {
+	d := event.NewDispatcherLimit(64)
+	d.SetShards(4, event.ShardByTarget)
+	unblock := make(chan struct{})
+	done := make(chan struct{})
+	order := make(chan interface{}, 10)
+	d.Bind("irc.PRIVMSG", event.HandlerFunc(func(ev *event.Event) {
+		switch ev.Data["Target"] {
+		case "#slow":
+			<-unblock
+			order <- ev.Data["i"]
+		case "#fast":
+			close(unblock)
+		case "#done":
+			close(done)
+		}
+	}))
+	emit := func(target string, i int) {
+		d.Emit("irc.PRIVMSG", map[string]interface{}{"Target": target, "i": i})
+	}
+	for i := 0; i < 5; i++ {
+		emit("#slow", i)
+	}
+	go d.Loop()
+	defer d.Stop()
+	if event.ShardByTarget(&event.Event{Name: "irc.PRIVMSG", Data: map[string]interface{}{"Target": "#slow"}}) ==
+		event.ShardByTarget(&event.Event{Name: "irc.PRIVMSG", Data: map[string]interface{}{"Target": "#fast"}}) {
+		t.Fatalf("expected different shard keys")
+	}
+	// with 4 shards, #fast is assigned a different worker than #slow, so it
+	// is handled while #slow is blocked.
+	emit("#fast", 0)
+	for i := 0; i < 5; i++ {
+		select {
+		case v := <-order:
+			if v != i {
+				t.Fatalf("expected event %d to be handled next, got %v", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+	emit("#done", 0)
+	<-done
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	j := event.NewJournal(&buf)
+	d := event.NewDispatcher()
+	d.SetJournal(j)
+	handled := make(chan struct{})
+	d.Bind("test.nested", event.HandlerFunc(func(ev *event.Event) {
+		close(handled)
+	}))
+	go d.Loop()
+	defer d.Stop()
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	d.Emit("irc.PRIVMSG", map[string]interface{}{"Target": "#squircy", "Message": "hello", "Time": ts, "Count": 3})
+	d.EmitSync(context.Background(), "plugin.INIT", map[string]interface{}{"name": "test", "plugin": struct{}{}})
+	d.Emit("test.func", map[string]interface{}{"fn": func() {}})
+	d.Emit("test.nested", map[string]interface{}{"list": []int{1, 2}})
+	// events are recorded before they are handled.
+	<-handled
+	d.SetJournal(nil)
+	d.Emit("not.recorded", nil)
+
+	r := event.NewDispatcherLimit(0)
+	var names []string
+	var data []map[string]interface{}
+	r.Bind("*.*", event.HandlerFunc(func(ev *event.Event) {
+		names = append(names, ev.Name)
+		data = append(data, ev.Data)
+	}))
+	go r.Loop()
+	defer r.Stop()
+	n, err := event.Replay(context.Background(), &buf, r, 0)
+	if err != nil {
+		t.Fatalf("unexpected error replaying events: %s", err)
+	}
+	// block until the previous events are finished being emitted.
+	r.Emit("unknown", nil)
+	if n != 2 || len(names) != 2 {
+		t.Fatalf("expected 2 events to be replayed, got %d: %v", n, names)
+	}
+	if names[0] != "irc.PRIVMSG" || data[0]["Message"] != "hello" {
+		t.Errorf("unexpected first event: %s %v", names[0], data[0])
+	}
+	if v, ok := data[0]["Time"].(time.Time); !ok || !v.Equal(ts) {
+		t.Errorf("expected Time to be restored as %s, got %T(%v)", ts, data[0]["Time"], data[0]["Time"])
+	}
+	if v, ok := data[0]["Count"].(int); !ok || v != 3 {
+		t.Errorf("expected Count to be restored as int 3, got %T(%v)", data[0]["Count"], data[0]["Count"])
+	}
+	if names[1] != "test.nested" || !reflect.DeepEqual(data[1]["list"], []interface{}{float64(1), float64(2)}) {
+		t.Errorf("unexpected second event: %s %v", names[1], data[1])
+	}
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDispatcher_journalDropped(t *testing.T) {
+	var buf bytes.Buffer
+	d := event.NewDispatcherLimit(1)
+	d.SetOverflowPolicy(event.OverflowDropNewest, 0)
+	d.SetJournal(event.NewJournal(&buf))
+	handled := make(chan struct{})
+	d.Bind("test.queued", event.HandlerFunc(func(ev *event.Event) {
+		close(handled)
+	}))
+	// with no workers running, the second event does not fit in the queue.
+	d.Emit("test.queued", nil)
+	d.Emit("test.dropped", nil)
+	go d.Loop()
+	defer d.Stop()
+	<-handled
+	var names []string
+	if err := event.ReadJournal(&buf, func(e event.JournalEntry) error {
+		names = append(names, e.Name)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error reading journal: %s", err)
+	}
+	if !reflect.DeepEqual(names, []string{"test.queued"}) {
+		t.Errorf("expected only the handled event to be recorded, got %v", names)
+	}
+}
+
+func TestDispatcher_SetJournal(t *testing.T) {
+	d := event.NewDispatcher()
+	w := &closeRecorder{}
+	j := event.NewJournal(w)
+	d.SetJournal(j)
+	d.SetJournal(j)
+	if w.closed {
+		t.Errorf("expected journal not to be closed when set again")
+	}
+	d.SetJournal(nil)
+	if !w.closed {
+		t.Errorf("expected journal to be closed when replaced")
+	}
+}
+
+func TestDispatcher_Once(t *testing.T) {
+	d := event.NewDispatcherLimit(0)
+	go d.Loop()
+	defer d.Stop()
+	calls := 0
+	d.Once("irc.330", event.HandlerFunc(func(ev *event.Event) {
+		calls++
+	}))
+	d.Emit("irc.330", nil)
+	d.Emit("irc.330", nil)
+	// block until the previous events are finished being emitted.
+	d.Emit("unknown", nil)
+	if calls != 1 {
+		t.Errorf("expected handler to be called once, got %d", calls)
+	}
+	if n := d.Bound(); n != 0 {
+		t.Errorf("expected handler to be unbound, got %d bound", n)
+	}
+}
+
+func TestDispatcher_BindWithTimeout(t *testing.T) {
+	d := event.NewDispatcher()
+	timedOut := make(chan struct{})
+	d.OnceWithTimeout("irc.330", event.HandlerFunc(func(ev *event.Event) {
+		t.Errorf("did not expect handler to be called")
+	}), 10*time.Millisecond, func() {
+		close(timedOut)
+	})
+	select {
+	case <-timedOut:
+	case <-time.After(time.Second):
+		t.Fatalf("expected timeout callback to be called")
+	}
+	if n := d.Bound(); n != 0 {
+		t.Errorf("expected handler to be unbound, got %d bound", n)
+	}
+
+	h := d.BindWithTimeout("irc.330", event.HandlerFunc(func(ev *event.Event) {}), 10*time.Millisecond, func() {
+		t.Errorf("did not expect timeout callback to be called after unbinding")
+	})
+	d.Unbind("irc.330", h)
+	<-time.After(20 * time.Millisecond)
+}
+
+func TestGroup_UnbindAll(t *testing.T) {
+	d := event.NewDispatcher()
+	g := d.NewGroup()
+	g.Bind("irc.PRIVMSG", event.HandlerFunc(func(ev *event.Event) {}))
+	g.BindPriority("irc.*", event.HandlerFunc(func(ev *event.Event) {}), 10)
+	g.Once("irc.JOIN", event.HandlerFunc(func(ev *event.Event) {}))
+	g.BindWithTimeout("irc.PART", event.HandlerFunc(func(ev *event.Event) {}), 10*time.Millisecond, func() {
+		t.Errorf("did not expect timeout callback to be called after unbinding")
+	})
+	other := event.HandlerFunc(func(ev *event.Event) {})
+	d.Bind("irc.PRIVMSG", other)
+	if n := d.Bound(); n != 5 {
+		t.Fatalf("expected 5 handlers to be bound, got %d", n)
+	}
+	g.UnbindAll()
+	if n := d.Bound(); n != 1 {
+		t.Errorf("expected only the handler bound outside the group to remain, got %d bound", n)
+	}
+	<-time.After(20 * time.Millisecond)
+}
@@ -0,0 +1,259 @@
+package event
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A JournalEntry is a single event recorded in a Journal.
+type JournalEntry struct {
+	Name string                 `json:"name"`
+	Time time.Time              `json:"time"`
+	Data map[string]interface{} `json:"data,omitempty"`
+	// Types contains the type of each value in Data that is restored when
+	// the entry is read, keyed by the value's name. See ReadJournal.
+	Types map[string]string `json:"types,omitempty"`
+}
+
+// Types of values in a JournalEntry that are restored when read.
+const (
+	journalTypeTime = "time"
+	journalTypeInt  = "int"
+)
+
+// A Journal records events to an append-only log, one JSON-encoded
+// JournalEntry per line.
+type Journal struct {
+	w   io.Writer
+	enc *json.Encoder
+
+	mu sync.Mutex
+}
+
+// An UnserializableError is returned by Journal.Record when the event's data
+// cannot be encoded as JSON.
+type UnserializableError struct {
+	// Event is the name of the event.
+	Event string
+	// Key is the name of the value that cannot be encoded.
+	Key string
+	// Type is the type of the value.
+	Type string
+}
+
+func (e *UnserializableError) Error() string {
+	return fmt.Sprintf("event %s has value %s of type %s that cannot be encoded", e.Event, e.Key, e.Type)
+}
+
+// unrecorded contains the names of events that are never recorded because
+// their data contains live objects that cannot be meaningfully replayed.
+var unrecorded = map[string]bool{
+	"plugin.INIT":   true,
+	"plugin.UNLOAD": true,
+}
+
+// NewJournal returns a Journal that writes to w.
+func NewJournal(w io.Writer) *Journal {
+	return &Journal{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenJournal opens the file at the given path for appending, creating it
+// and its parent directories if necessary.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrapf(err, "unable (%s) to create journal directory", path)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable (%s) to open journal", path)
+	}
+	return NewJournal(f), nil
+}
+
+// Record writes the event to the journal.
+// If a value in the event's data cannot be encoded as JSON, the event is not
+// recorded and an *UnserializableError is returned.
+func (j *Journal) Record(ev *Event) error {
+	data, types, err := journalData(ev)
+	if err != nil {
+		return err
+	}
+	e := JournalEntry{Name: ev.Name, Time: time.Now(), Data: data, Types: types}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(e)
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (j *Journal) Close() error {
+	if c, ok := j.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// journalData returns the event's data and the types of the values in it
+// that should be restored when read, or an error if any value cannot be
+// encoded as JSON.
+func journalData(ev *Event) (map[string]interface{}, map[string]string, error) {
+	if ev.Data == nil {
+		return nil, nil, nil
+	}
+	var types map[string]string
+	setType := func(k, t string) {
+		if types == nil {
+			types = make(map[string]string)
+		}
+		types[k] = t
+	}
+	for k, v := range ev.Data {
+		switch v.(type) {
+		case time.Time:
+			setType(k, journalTypeTime)
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			setType(k, journalTypeInt)
+		}
+		if _, err := json.Marshal(v); err != nil {
+			return nil, nil, &UnserializableError{Event: ev.Name, Key: k, Type: fmt.Sprintf("%T", v)}
+		}
+	}
+	return ev.Data, types, nil
+}
+
+// restore converts the values in the entry's data back to the types they
+// were recorded with.
+func (e *JournalEntry) restore() error {
+	for k, t := range e.Types {
+		v, ok := e.Data[k]
+		if !ok || v == nil {
+			continue
+		}
+		switch t {
+		case journalTypeTime:
+			s, ok := v.(string)
+			if !ok {
+				return errors.Errorf("expected %s to be a time, got %T", k, v)
+			}
+			tv, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return errors.Wrapf(err, "invalid time for %s", k)
+			}
+			e.Data[k] = tv
+		case journalTypeInt:
+			f, ok := v.(float64)
+			if !ok {
+				return errors.Errorf("expected %s to be a number, got %T", k, v)
+			}
+			e.Data[k] = int(f)
+		}
+	}
+	return nil
+}
+
+// ReadJournal reads each entry from the journal in r, calling fn for each
+// one. Reading stops at the first error returned by fn.
+//
+// Values in each entry's data are decoded from JSON, so objects become
+// map[string]interface{}, arrays []interface{}, and numbers float64, except
+// that top-level values recorded as a time.Time or an integer are restored
+// as a time.Time or an int.
+func ReadJournal(r io.Reader, fn func(JournalEntry) error) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for n := 1; ; n++ {
+		var e JournalEntry
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to read journal entry %d", n)
+		}
+		if err := e.restore(); err != nil {
+			return errors.Wrapf(err, "unable to read journal entry %d", n)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// Replay emits each event read from the journal in r using the Dispatcher.
+//
+// If speed is greater than zero, Replay waits between events, preserving
+// the time between them as recorded, scaled by speed; a speed of 1 replays
+// the events in real time. Otherwise, events are emitted as fast as
+// possible.
+// Replay returns the number of events emitted.
+func Replay(ctx context.Context, r io.Reader, d *Dispatcher, speed float64) (int, error) {
+	var last time.Time
+	n := 0
+	err := ReadJournal(r, func(e JournalEntry) error {
+		if speed > 0 && !last.IsZero() {
+			if wait := time.Duration(float64(e.Time.Sub(last)) / speed); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-ctx.Done():
+					t.Stop()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		last = e.Time
+		d.Emit(e.Name, e.Data)
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// SetJournal sets the Journal used to record every event emitted with
+// Emit, EmitSync, or Request. Events emitted with Emit are recorded when they
+// are taken from the queue to be handled, so events dropped by the overflow
+// policy are not recorded. Events generated by the Dispatcher itself,
+// such as event.DROPPED and event.ERROR, are not recorded, nor are
+// plugin.INIT and plugin.UNLOAD events, or events with data that cannot be
+// encoded as JSON.
+// The previous Journal, if any, is closed. If j is nil, events are not
+// recorded.
+func (d *Dispatcher) SetJournal(j *Journal) {
+	d.mu.Lock()
+	prev := d.journal
+	d.journal = j
+	d.mu.Unlock()
+	if prev != nil && prev != j {
+		if err := prev.Close(); err != nil {
+			logrus.Warnln("event: failed to close journal:", err)
+		}
+	}
+}
+
+// record writes the event to the journal, if one is set.
+func (d *Dispatcher) record(ev *Event) {
+	if unrecorded[ev.Name] {
+		return
+	}
+	d.mu.RLock()
+	j := d.journal
+	d.mu.RUnlock()
+	if j == nil {
+		return
+	}
+	if err := j.Record(ev); err != nil {
+		if _, ok := err.(*UnserializableError); ok {
+			logrus.Debugf("event: not recording event in journal: %s", err)
+			return
+		}
+		logrus.Warnf("event: failed to record %s event in journal: %s", ev.Name, err)
+	}
+}
@@ -0,0 +1,188 @@
+package event
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// An OverflowPolicy determines what happens when an event is emitted while
+// the Dispatcher's queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the emitter until there is room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest pending event to make room.
+	// If the queue has no capacity, there are no pending events to discard,
+	// so the emitter blocks as with OverflowBlock.
+	OverflowDropOldest
+	// OverflowDropNewest discards the event being emitted.
+	OverflowDropNewest
+	// OverflowBlockTimeout blocks the emitter until there is room in the
+	// queue or the timeout elapses, in which case the event being emitted is
+	// discarded.
+	OverflowBlockTimeout
+)
+
+// DefaultOverflowTimeout is the timeout used by OverflowBlockTimeout when
+// none is specified.
+const DefaultOverflowTimeout = time.Second
+
+var overflowPolicyNames = map[OverflowPolicy]string{
+	OverflowBlock:        "block",
+	OverflowDropOldest:   "drop-oldest",
+	OverflowDropNewest:   "drop-newest",
+	OverflowBlockTimeout: "block-timeout",
+}
+
+func (p OverflowPolicy) String() string {
+	if n, ok := overflowPolicyNames[p]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// ParseOverflowPolicy returns the OverflowPolicy with the given name.
+// Valid names are "block", "drop-oldest", "drop-newest", and "block-timeout".
+// An empty name is the same as "block".
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return OverflowBlock, nil
+	}
+	for p, n := range overflowPolicyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return OverflowBlock, errors.Errorf("unknown overflow policy: %s", name)
+}
+
+// SetOverflowPolicy sets the policy used when the Dispatcher's queue is
+// full. The timeout is only used by OverflowBlockTimeout; if it is zero,
+// DefaultOverflowTimeout is used.
+func (d *Dispatcher) SetOverflowPolicy(policy OverflowPolicy, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultOverflowTimeout
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.policy = policy
+	d.overflowTimeout = timeout
+}
+
+// OverflowPolicy returns the policy used when the Dispatcher's queue is full.
+func (d *Dispatcher) OverflowPolicy() OverflowPolicy {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.policy
+}
+
+// Dropped returns the total number of events discarded due to the
+// Dispatcher's queue being full.
+func (d *Dispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+// DroppedEvents returns the number of events discarded due to the
+// Dispatcher's queue being full, keyed by event name.
+func (d *Dispatcher) DroppedEvents() map[string]uint64 {
+	d.droppedMu.Lock()
+	defer d.droppedMu.Unlock()
+	res := make(map[string]uint64, len(d.droppedByName))
+	for k, v := range d.droppedByName {
+		res[k] = v
+	}
+	return res
+}
+
+// enqueue adds the event to the queue, applying the overflow policy if the
+// queue is full.
+func (d *Dispatcher) enqueue(ev *Event) {
+	d.mu.RLock()
+	policy := d.policy
+	timeout := d.overflowTimeout
+	d.mu.RUnlock()
+	switch policy {
+	case OverflowDropNewest:
+		select {
+		case d.emitting <- ev:
+		default:
+			d.drop(ev)
+		}
+
+	case OverflowDropOldest:
+		if cap(d.emitting) == 0 {
+			// nothing is ever pending; wait for a worker.
+			d.emitting <- ev
+			return
+		}
+		for {
+			select {
+			case d.emitting <- ev:
+				return
+			default:
+			}
+			select {
+			case old := <-d.emitting:
+				d.drop(old)
+			default:
+				// a worker took the oldest event first; try again.
+			}
+		}
+
+	case OverflowBlockTimeout:
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case d.emitting <- ev:
+		case <-t.C:
+			d.drop(ev)
+		}
+
+	default:
+		d.emitting <- ev
+	}
+}
+
+// drop records that the event was discarded and notifies workers that an
+// event.DROPPED event should be handled.
+func (d *Dispatcher) drop(ev *Event) {
+	total := atomic.AddUint64(&d.dropped, 1)
+	d.droppedMu.Lock()
+	d.droppedByName[ev.Name]++
+	d.droppedSinceNotice++
+	d.lastDropped = ev.Name
+	d.droppedMu.Unlock()
+	logrus.Debugf("event: dropped %s event, %d total dropped", ev.Name, total)
+	select {
+	case d.dropNotice <- struct{}{}:
+	default:
+		// a notification is already pending
+	}
+}
+
+// droppedEvent returns an event.DROPPED event describing the events dropped
+// since the last notification.
+//
+// Notifications are coalesced: if events are dropped faster than workers can
+// handle the notifications, a single event.DROPPED event is emitted with the
+// number of events dropped since the previous one.
+func (d *Dispatcher) droppedEvent() *Event {
+	d.droppedMu.Lock()
+	defer d.droppedMu.Unlock()
+	count := d.droppedSinceNotice
+	d.droppedSinceNotice = 0
+	return &Event{
+		Name: "event.DROPPED",
+		Data: map[string]interface{}{
+			"name":  d.lastDropped,
+			"count": count,
+			"total": atomic.LoadUint64(&d.dropped),
+		},
+	}
+}
@@ -1,27 +1,54 @@
 package event
 
 import (
+	"path/filepath"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/config"
 	"code.dopame.me/veonik/squircy3/plugin"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
+// Config describes how the event plugin's Dispatcher handles overflow,
+// failing handlers, and concurrency, and whether events are recorded.
+type Config struct {
+	// OverflowPolicy is one of "block", "drop-oldest", "drop-newest", or
+	// "block-timeout".
+	OverflowPolicy string `toml:"overflow_policy"`
+	// OverflowTimeout is the amount of time to wait with the "block-timeout"
+	// policy before dropping an event.
+	OverflowTimeout string `toml:"overflow_timeout"`
+	// MaxHandlerFailures is the number of consecutive times a handler may
+	// panic before it is unbound. Zero means handlers are never unbound.
+	MaxHandlerFailures int `toml:"max_handler_failures"`
+	// Shards is the number of workers events are handled by. Events with
+	// the same shard key are always handled in order by the same worker.
+	Shards int `toml:"shards"`
+	// ShardKey is either "target" or "name". See ShardByTarget for the
+	// ordering guarantees of "target".
+	ShardKey string `toml:"shard_key"`
+	// Journal is the path to a file that every emitted event is appended to.
+	// Relative paths are relative to the root directory. If it is empty,
+	// events are not recorded.
+	Journal string `toml:"journal"`
+
+	RootDir string `flag:"root_path"`
+}
+
 // FromPlugins returns the event plugin's Dispatcher or an error if it fails.
 func FromPlugins(m *plugin.Manager) (*Dispatcher, error) {
-	plg, err := m.Lookup("event")
-	if err != nil {
+	var res *Dispatcher
+	if err := m.Resolve("event", &res); err != nil {
 		return nil, err
 	}
-	mplg, ok := plg.(*eventPlugin)
-	if !ok {
-		return nil, errors.Errorf("event: received unexpected plugin type")
-	}
-	return mplg.dispatcher, nil
+	return res, nil
 }
 
 // Initialize is a plugin.Initializer that initializes an event plugin.
 func Initialize(*plugin.Manager) (plugin.Plugin, error) {
-	p := &eventPlugin{NewDispatcher()}
+	p := &eventPlugin{dispatcher: NewDispatcher()}
 	return p, nil
 }
 
@@ -33,10 +60,86 @@ func (p *eventPlugin) Name() string {
 	return "event"
 }
 
+func (p *eventPlugin) Options() []config.SetupOption {
+	return []config.SetupOption{
+		config.WithInitValue(&Config{}),
+		config.WithInheritedOption("root_path"),
+	}
+}
+
+func (p *eventPlugin) Configure(c config.Config) error {
+	co, ok := c.Self().(*Config)
+	if !ok {
+		return errors.Errorf("event: value is not a *event.Config")
+	}
+	policy, err := ParseOverflowPolicy(co.OverflowPolicy)
+	if err != nil {
+		return errors.Wrap(err, "event: invalid overflow_policy")
+	}
+	var timeout time.Duration
+	if co.OverflowTimeout != "" {
+		timeout, err = time.ParseDuration(co.OverflowTimeout)
+		if err != nil {
+			return errors.Wrap(err, "event: invalid overflow_timeout")
+		}
+	}
+	p.dispatcher.SetOverflowPolicy(policy, timeout)
+	p.dispatcher.SetMaxFailures(co.MaxHandlerFailures)
+	key, err := ParseShardKey(co.ShardKey)
+	if err != nil {
+		return errors.Wrap(err, "event: invalid shard_key")
+	}
+	p.dispatcher.SetShards(co.Shards, key)
+	return p.setJournal(co)
+}
+
+// setJournal opens the configured journal. The Dispatcher closes the
+// previous one.
+func (p *eventPlugin) setJournal(co *Config) error {
+	var j *Journal
+	if co.Journal != "" {
+		path := co.Journal
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(co.RootDir, path)
+		}
+		var err error
+		j, err = OpenJournal(path)
+		if err != nil {
+			return errors.Wrap(err, "event: invalid journal")
+		}
+		logrus.Infof("event: recording events to journal %s", path)
+	}
+	p.dispatcher.SetJournal(j)
+	return nil
+}
+
 func (p *eventPlugin) HandlePluginInit(o plugin.Plugin) {
 	p.dispatcher.Emit("plugin.INIT", map[string]interface{}{"name": o.Name(), "plugin": o})
 }
 
+func (p *eventPlugin) HandlePluginUnload(o plugin.Plugin) {
+	p.dispatcher.Emit("plugin.UNLOAD", map[string]interface{}{"name": o.Name(), "plugin": o})
+}
+
+func (p *eventPlugin) Services() map[string]interface{} {
+	return map[string]interface{}{"event": p.dispatcher}
+}
+
+func (p *eventPlugin) Status() plugin.Status {
+	return plugin.Status{
+		Configured: true,
+		Details: map[string]interface{}{
+			"running":  p.dispatcher.Running(),
+			"pending":  p.dispatcher.Pending(),
+			"handlers": p.dispatcher.Bound(),
+			"policy":   p.dispatcher.OverflowPolicy().String(),
+			"dropped":  p.dispatcher.Dropped(),
+			"shards":   p.dispatcher.Shards(),
+		},
+	}
+}
+
 func (p *eventPlugin) HandleShutdown() {
 	p.dispatcher.Stop()
+	p.dispatcher.SetJournal(nil)
 }
@@ -0,0 +1,94 @@
+package event
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A PanicError is reported when a handler panics while handling an event.
+type PanicError struct {
+	// Event is the name of the event being handled.
+	Event string
+	// Handler is the ID of the handler that panicked.
+	Handler string
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler (%s) panicked handling %s: %v", e.Handler, e.Event, e.Value)
+}
+
+// SetMaxFailures sets the number of consecutive times a handler may panic
+// before it is automatically unbound. If n is zero or less, handlers are
+// never unbound.
+func (d *Dispatcher) SetMaxFailures(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxFailures = n
+}
+
+// call calls the bound handler, recovering from any panic.
+// If the handler panics, an "event.ERROR" event is handled immediately by
+// the calling goroutine and the error is returned.
+func (d *Dispatcher) call(b binding, ev *Event) (err *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Event: ev.Name, Handler: b.handler.ID(), Value: r, Stack: debug.Stack()}
+		}
+		d.recordResult(b, err)
+		if err != nil {
+			d.reportPanic(err)
+		}
+	}()
+	b.handler.Handle(ev)
+	return nil
+}
+
+// recordResult tracks consecutive failures for the bound handler, unbinding
+// it if it has failed too many times.
+func (d *Dispatcher) recordResult(b binding, err *PanicError) {
+	key := b.name + "\x00" + b.handler.ID()
+	d.failuresMu.Lock()
+	if err == nil {
+		delete(d.failures, key)
+		d.failuresMu.Unlock()
+		return
+	}
+	d.failures[key]++
+	n := d.failures[key]
+	d.failuresMu.Unlock()
+	d.mu.RLock()
+	max := d.maxFailures
+	d.mu.RUnlock()
+	if max <= 0 || n < max {
+		return
+	}
+	logrus.Warnf("event: unbinding handler %s for %s after %d consecutive failures", b.handler.ID(), b.name, n)
+	d.Unbind(b.name, b.handler)
+	d.failuresMu.Lock()
+	delete(d.failures, key)
+	d.failuresMu.Unlock()
+}
+
+// reportPanic logs the error and handles an "event.ERROR" event describing
+// it. Panics while handling "event.ERROR" are logged but not reported again.
+func (d *Dispatcher) reportPanic(err *PanicError) {
+	logrus.Errorf("event: %s\n%s", err, err.Stack)
+	if err.Event == "event.ERROR" {
+		return
+	}
+	ev := &Event{
+		Name: "event.ERROR",
+		Data: map[string]interface{}{
+			"name":    err.Event,
+			"handler": err.Handler,
+			"error":   fmt.Sprintf("%v", err.Value),
+		},
+	}
+	d.handle(ev)
+}
@@ -0,0 +1,121 @@
+package event
+
+import (
+	"hash/fnv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A ShardKeyFunc returns the key used to assign an event to a worker when
+// the Dispatcher is sharded. Events with the same key are handled in the
+// order they were emitted.
+type ShardKeyFunc func(ev *Event) string
+
+// ShardByName returns the prefix of the event's name, before the first ".".
+// All events from the same source, such as "irc" or "discord", are handled
+// in order.
+func ShardByName(ev *Event) string {
+	if i := strings.IndexByte(ev.Name, '.'); i >= 0 {
+		return ev.Name[:i]
+	}
+	return ev.Name
+}
+
+// ShardByTarget returns the prefix of the event's name combined with the
+// value of its "Target" or "ChannelID" field, if it has one. Events for the
+// same channel are handled in order while events for different channels
+// are handled in parallel. Events without a target use ShardByName.
+//
+// Ordering is only preserved between events with the same key, so events
+// without a target, such as irc.QUIT and irc.NICK, are not ordered relative
+// to events for a channel, even those caused by the same user. Use
+// ShardByName if handlers depend on that order.
+func ShardByTarget(ev *Event) string {
+	prefix := ShardByName(ev)
+	for _, k := range []string{"Target", "ChannelID"} {
+		if v, ok := ev.Data[k].(string); ok && v != "" {
+			return prefix + "/" + strings.ToLower(v)
+		}
+	}
+	return prefix
+}
+
+// ParseShardKey returns the ShardKeyFunc with the given name, either "name"
+// or "target". An empty name is the same as "target".
+func ParseShardKey(name string) (ShardKeyFunc, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "target":
+		return ShardByTarget, nil
+	case "name":
+		return ShardByName, nil
+	}
+	return nil, errors.Errorf("unknown shard key: %s", name)
+}
+
+// SetShards configures the Dispatcher to handle events with a pool of n
+// workers. Each event is assigned to a worker using the given key function,
+// so events with the same key are handled in order. If n is one or less,
+// events are handled directly by the goroutines running Loop.
+// If key is nil, ShardByTarget is used.
+//
+// Changes take effect the next time the Dispatcher is started.
+func (d *Dispatcher) SetShards(n int, key ShardKeyFunc) {
+	if key == nil {
+		key = ShardByTarget
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.shardCount = n
+	d.shardKey = key
+}
+
+// Shards returns the number of workers events are sharded across.
+func (d *Dispatcher) Shards() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.shardCount < 1 {
+		return 1
+	}
+	return d.shardCount
+}
+
+// startShards creates the worker pool if the Dispatcher is sharded and it
+// has not been started already.
+// The Dispatcher must be locked when calling this method.
+func (d *Dispatcher) startShards(quit chan struct{}) {
+	if d.shardCount <= 1 || d.shards != nil {
+		return
+	}
+	size := cap(d.emitting) / d.shardCount
+	d.shards = make([]chan *Event, d.shardCount)
+	for i := range d.shards {
+		ch := make(chan *Event, size)
+		d.shards[i] = ch
+		go func() {
+			for {
+				select {
+				case <-quit:
+					return
+				case ev := <-ch:
+					d.handle(ev)
+				}
+			}
+		}()
+	}
+}
+
+// dispatch handles the event, or passes it to the responsible worker if the
+// Dispatcher is sharded.
+func (d *Dispatcher) dispatch(ev *Event, shards []chan *Event, key ShardKeyFunc, quit chan struct{}) {
+	if len(shards) == 0 {
+		d.handle(ev)
+		return
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key(ev)))
+	select {
+	case shards[h.Sum32()%uint32(len(shards))] <- ev:
+	case <-quit:
+	}
+}
@@ -0,0 +1,205 @@
+package event
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timedHandler wraps a handler that is unbound after handling one event, or
+// after a deadline, or both.
+type timedHandler struct {
+	d       *Dispatcher
+	name    string
+	handler Handler
+	once    bool
+
+	onTimeout func()
+	timer     *time.Timer
+	mu        sync.Mutex
+
+	// done is set to 1 once the handler is no longer active, accessed
+	// atomically.
+	done int32
+}
+
+func (h *timedHandler) ID() string {
+	return fmt.Sprintf("%p", h)
+}
+
+func (h *timedHandler) Handle(ev *Event) {
+	if !h.once {
+		if atomic.LoadInt32(&h.done) == 0 {
+			h.handler.Handle(ev)
+		}
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&h.done, 0, 1) {
+		// already handled an event or timed out.
+		return
+	}
+	h.stopTimer()
+	h.d.Unbind(h.name, h)
+	h.handler.Handle(ev)
+}
+
+func (h *timedHandler) stopTimer() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+}
+
+// expire unbinds the handler and calls onTimeout if the handler is still
+// bound.
+func (h *timedHandler) expire() {
+	if !atomic.CompareAndSwapInt32(&h.done, 0, 1) {
+		return
+	}
+	if !h.d.unbind(h.name, h) {
+		// unbound before the deadline.
+		return
+	}
+	if h.onTimeout != nil {
+		h.onTimeout()
+	}
+}
+
+func (d *Dispatcher) bindTimed(name string, handler Handler, once bool, timeout time.Duration, onTimeout func()) Handler {
+	h := &timedHandler{d: d, name: name, handler: handler, once: once, onTimeout: onTimeout}
+	d.Bind(name, h)
+	if timeout > 0 {
+		h.mu.Lock()
+		h.timer = time.AfterFunc(timeout, h.expire)
+		h.mu.Unlock()
+	}
+	return h
+}
+
+// Once binds the handler to the event so that it is called at most once.
+// The handler is unbound before it is called.
+//
+// The returned Handler is the one actually bound to the event; pass it to
+// Unbind to unbind the handler before it is called.
+func (d *Dispatcher) Once(name string, handler Handler) Handler {
+	return d.bindTimed(name, handler, true, 0, nil)
+}
+
+// BindWithTimeout binds the handler to the event until the timeout elapses.
+// If the handler is still bound when the timeout elapses, it is unbound and
+// onTimeout is called, if it is not nil.
+//
+// If timeout is zero or less, the handler is never unbound automatically.
+//
+// The returned Handler is the one actually bound to the event; pass it to
+// Unbind to unbind the handler early, in which case onTimeout is not called.
+func (d *Dispatcher) BindWithTimeout(name string, handler Handler, timeout time.Duration, onTimeout func()) Handler {
+	return d.bindTimed(name, handler, false, timeout, onTimeout)
+}
+
+// OnceWithTimeout binds the handler to the event so that it is called at
+// most once, unless the timeout elapses first, in which case the handler is
+// unbound and onTimeout is called.
+func (d *Dispatcher) OnceWithTimeout(name string, handler Handler, timeout time.Duration, onTimeout func()) Handler {
+	return d.bindTimed(name, handler, true, timeout, onTimeout)
+}
+
+// A Group binds handlers using a Dispatcher, keeping track of them so that
+// they can all be unbound at once.
+type Group struct {
+	d *Dispatcher
+
+	bound []groupBinding
+	mu    sync.Mutex
+}
+
+type groupBinding struct {
+	name    string
+	handler Handler
+}
+
+// NewGroup returns a Group that binds handlers using the Dispatcher.
+func (d *Dispatcher) NewGroup() *Group {
+	return &Group{d: d}
+}
+
+func (g *Group) add(name string, h Handler) Handler {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// forget handlers that have already been called once or timed out.
+	bound := g.bound[:0]
+	for _, b := range g.bound {
+		if th, ok := b.handler.(*timedHandler); ok && atomic.LoadInt32(&th.done) == 1 {
+			continue
+		}
+		bound = append(bound, b)
+	}
+	g.bound = append(bound, groupBinding{name, h})
+	return h
+}
+
+// Bind binds the handler to the event as part of the Group.
+func (g *Group) Bind(name string, handler Handler) {
+	g.BindPriority(name, handler, DefaultPriority)
+}
+
+// BindPriority binds the handler to the event with the given priority as
+// part of the Group.
+func (g *Group) BindPriority(name string, handler Handler, priority int) {
+	g.d.BindPriority(name, handler, priority)
+	g.add(name, handler)
+}
+
+// Once binds the handler to the event as part of the Group so that it is
+// called at most once. See Dispatcher.Once.
+func (g *Group) Once(name string, handler Handler) Handler {
+	return g.add(name, g.d.Once(name, handler))
+}
+
+// BindWithTimeout binds the handler to the event as part of the Group until
+// the timeout elapses. See Dispatcher.BindWithTimeout.
+func (g *Group) BindWithTimeout(name string, handler Handler, timeout time.Duration, onTimeout func()) Handler {
+	return g.add(name, g.d.BindWithTimeout(name, handler, timeout, onTimeout))
+}
+
+// OnceWithTimeout binds the handler to the event as part of the Group so
+// that it is called at most once. See Dispatcher.OnceWithTimeout.
+func (g *Group) OnceWithTimeout(name string, handler Handler, timeout time.Duration, onTimeout func()) Handler {
+	return g.add(name, g.d.OnceWithTimeout(name, handler, timeout, onTimeout))
+}
+
+// Unbind unbinds the handler from the event and removes it from the Group.
+func (g *Group) Unbind(name string, handler Handler) {
+	g.mu.Lock()
+	for i, b := range g.bound {
+		if b.name == name && b.handler.ID() == handler.ID() {
+			g.bound = append(g.bound[:i], g.bound[i+1:]...)
+			break
+		}
+	}
+	g.mu.Unlock()
+	if th, ok := handler.(*timedHandler); ok {
+		atomic.StoreInt32(&th.done, 1)
+		th.stopTimer()
+	}
+	g.d.unbind(name, handler)
+}
+
+// UnbindAll unbinds every handler bound as part of the Group.
+// The Group may continue to be used afterward.
+func (g *Group) UnbindAll() {
+	g.mu.Lock()
+	bound := g.bound
+	g.bound = nil
+	g.mu.Unlock()
+	for _, b := range bound {
+		if th, ok := b.handler.(*timedHandler); ok {
+			// prevent the timeout callback from firing.
+			atomic.StoreInt32(&th.done, 1)
+			th.stopTimer()
+		}
+		g.d.unbind(b.name, b.handler)
+	}
+}
@@ -0,0 +1,373 @@
+package irc
+
+import (
+	"encoding/base64"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+// DefaultCapabilities are the IRCv3 capabilities requested when
+// Capabilities is not set.
+//
+// echo-message is not requested by default: with it, the server sends the
+// bot's own messages back, and handlers that reply to every message would
+// reply to themselves. When it is enabled, echoed messages are emitted with
+// Echo set.
+var DefaultCapabilities = []string{
+	"server-time",
+	"account-tag",
+	"message-tags",
+	"away-notify",
+	"extended-join",
+	"multi-prefix",
+	"batch",
+}
+
+// saslChunkSize is the maximum length of each AUTHENTICATE payload.
+const saslChunkSize = 400
+
+// capabilities negotiates IRCv3 capabilities, including SASL
+// authentication, with the server.
+type capabilities struct {
+	// wanted contains the capabilities to request if they are available.
+	wanted []string
+
+	sasl          bool
+	saslMechanism string
+	saslUsername  string
+	saslPassword  string
+
+	// available contains the capabilities advertised by the server and
+	// their values.
+	available map[string]string
+	// enabled contains the capabilities acknowledged by the server.
+	enabled map[string]bool
+	// requested is true once the initial capabilities are requested.
+	requested bool
+	// pending is the number of requests awaiting ACK or NAK.
+	pending int
+	// done is true once negotiation has ended.
+	done bool
+
+	mu sync.Mutex
+}
+
+func newCapabilities(c Config) *capabilities {
+	wanted := c.Capabilities
+	if len(wanted) == 0 {
+		wanted = DefaultCapabilities
+	}
+	// the mechanism is validated when the configuration is loaded.
+	mech, _ := saslMechanism(&c)
+	return &capabilities{
+		wanted:        append([]string{}, wanted...),
+		sasl:          c.SASL,
+		saslMechanism: mech,
+		saslUsername:  c.SASLUsername,
+		saslPassword:  c.SASLPassword,
+		available:     make(map[string]string),
+		enabled:       make(map[string]bool),
+	}
+}
+
+// Enabled returns true if the capability was acknowledged by the server.
+func (c *capabilities) Enabled(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled[name]
+}
+
+// List returns the enabled capabilities, sorted.
+func (c *capabilities) List() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]string, 0, len(c.enabled))
+	for k := range c.enabled {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// handle updates the negotiation with a message received from the server,
+// returning the lines to send in reply. An error is returned if the
+// connection cannot continue, such as when SASL authentication fails.
+func (c *capabilities) handle(e *Event) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch e.Code {
+	case "CAP":
+		if len(e.Args) < 3 {
+			return nil, nil
+		}
+		return c.handleCap(strings.ToUpper(e.Args[1]), e.Args[2:])
+
+	case "AUTHENTICATE":
+		if !c.sasl || c.done || len(e.Args) == 0 || e.Args[0] != "+" {
+			return nil, nil
+		}
+		return c.authenticate(), nil
+
+	case "903":
+		// RPL_SASLSUCCESS
+		return c.end(), nil
+
+	case "902", "904", "905", "906":
+		// ERR_NICKLOCKED, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED
+		if !c.sasl || c.done {
+			return nil, nil
+		}
+		c.done = true
+		return []string{"CAP END"}, errors.Errorf("SASL authentication failed: %s", e.Message)
+	}
+	return nil, nil
+}
+
+// handleCap handles a CAP subcommand and its parameters.
+func (c *capabilities) handleCap(cmd string, params []string) ([]string, error) {
+	list := strings.Fields(params[len(params)-1])
+	switch cmd {
+	case "LS":
+		for _, v := range list {
+			name, value := parseCap(v)
+			c.available[name] = value
+		}
+		if len(params) > 1 && params[0] == "*" {
+			// more capabilities follow.
+			return nil, nil
+		}
+		if c.requested {
+			return nil, nil
+		}
+		c.requested = true
+		var req []string
+		for _, name := range c.wanted {
+			if _, ok := c.available[name]; ok {
+				req = append(req, name)
+			}
+		}
+		if c.sasl {
+			mechs, ok := c.available["sasl"]
+			if !ok {
+				c.done = true
+				return []string{"CAP END"}, errors.New("server does not support SASL")
+			}
+			// with CAP LS 302, the server may list its mechanisms.
+			if mechs != "" && !listContains(strings.Split(mechs, ","), c.saslMechanism) {
+				c.done = true
+				return []string{"CAP END"}, errors.Errorf("server does not support SASL mechanism %s, only %s", c.saslMechanism, mechs)
+			}
+			req = append(req, "sasl")
+		}
+		if len(req) == 0 {
+			return c.end(), nil
+		}
+		c.pending++
+		return []string{"CAP REQ :" + strings.Join(req, " ")}, nil
+
+	case "ACK":
+		for _, name := range list {
+			if strings.HasPrefix(name, "-") {
+				delete(c.enabled, name[1:])
+			} else {
+				c.enabled[name] = true
+			}
+		}
+		return c.answered(nil), nil
+
+	case "NAK":
+		// the whole request is rejected, so request each capability
+		// separately to enable those that are supported.
+		var res []string
+		if len(list) > 1 {
+			for _, name := range list {
+				res = append(res, "CAP REQ :"+name)
+			}
+			c.pending += len(list)
+		} else if len(list) == 1 && list[0] == "sasl" && c.sasl && !c.done {
+			c.done = true
+			return []string{"CAP END"}, errors.New("server rejected SASL capability")
+		}
+		return c.answered(res), nil
+
+	case "NEW":
+		var res []string
+		for _, v := range list {
+			name, value := parseCap(v)
+			c.available[name] = value
+			if listContains(c.wanted, name) && !c.enabled[name] {
+				res = append(res, "CAP REQ :"+name)
+			}
+		}
+		return res, nil
+
+	case "DEL":
+		for _, name := range list {
+			delete(c.available, name)
+			delete(c.enabled, name)
+		}
+	}
+	return nil, nil
+}
+
+// parseCap returns the name and value of an advertised capability, such as
+// "sasl=PLAIN,EXTERNAL".
+func parseCap(v string) (name, value string) {
+	if i := strings.IndexByte(v, '='); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// answered records the reply to a request, ending negotiation or beginning
+// SASL authentication once every request is answered. res contains lines
+// already waiting to be sent.
+func (c *capabilities) answered(res []string) []string {
+	if c.pending > 0 {
+		c.pending--
+	}
+	if c.pending > 0 || c.done {
+		return res
+	}
+	if c.sasl && c.enabled["sasl"] {
+		return append(res, "AUTHENTICATE "+c.saslMechanism)
+	}
+	return append(res, c.end()...)
+}
+
+// end ends negotiation.
+func (c *capabilities) end() []string {
+	if c.done {
+		return nil
+	}
+	c.done = true
+	return []string{"CAP END"}
+}
+
+// listContains returns true if list contains v.
+func listContains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns the AUTHENTICATE lines containing the credentials.
+func (c *capabilities) authenticate() []string {
+	payload := ""
+	if c.saslMechanism == SASLPlain {
+		payload = base64.StdEncoding.EncodeToString([]byte(c.saslUsername + "\x00" + c.saslUsername + "\x00" + c.saslPassword))
+	}
+	// with EXTERNAL, the server identifies the bot by its client
+	// certificate, so the payload is empty.
+	var res []string
+	for len(payload) >= saslChunkSize {
+		res = append(res, "AUTHENTICATE "+payload[:saslChunkSize])
+		payload = payload[saslChunkSize:]
+	}
+	if payload == "" {
+		// an empty final chunk is sent as "+".
+		payload = "+"
+	}
+	return append(res, "AUTHENTICATE "+payload)
+}
+
+// registrationEncoding is the Encoding of a Connection. Its encoder writes
+// lines, such as CAP LS, before the first line sent to the server.
+//
+// go-ircevent sends NICK and USER as soon as it connects and offers no other
+// way to write to the connection before them. The server only suspends
+// registration until CAP END if negotiation begins first; otherwise it may
+// complete registration before SASL authentication.
+type registrationEncoding struct {
+	encoding.Encoding
+
+	lines []string
+}
+
+func (e registrationEncoding) NewEncoder() *encoding.Encoder {
+	pre := &prefixTransformer{prefix: strings.Join(e.lines, "\r\n") + "\r\n"}
+	return &encoding.Encoder{Transformer: transform.Chain(pre, e.Encoding.NewEncoder())}
+}
+
+// prefixTransformer copies its input, adding prefix before the first byte.
+type prefixTransformer struct {
+	transform.NopResetter
+
+	prefix string
+	done   bool
+}
+
+func (t *prefixTransformer) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if !t.done && len(src) > 0 {
+		if len(dst) < len(t.prefix) {
+			return 0, 0, transform.ErrShortDst
+		}
+		nDst = copy(dst, t.prefix)
+		t.done = true
+	}
+	n := copy(dst[nDst:], src)
+	nDst += n
+	nSrc = n
+	if nSrc < len(src) {
+		err = transform.ErrShortDst
+	}
+	return nDst, nSrc, err
+}
+
+// HasCapability returns true if the server acknowledged the IRCv3
+// capability.
+func (conn *Connection) HasCapability(name string) bool {
+	return conn.caps.Enabled(name)
+}
+
+// Capabilities returns the IRCv3 capabilities acknowledged by the server,
+// sorted.
+func (conn *Connection) Capabilities() []string {
+	return conn.caps.List()
+}
+
+// isEcho returns true if e is one of the bot's own messages echoed back by
+// the server.
+func (conn *Connection) isEcho(e *Event) bool {
+	switch e.Code {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+	default:
+		return false
+	}
+	if conn.state == nil || !conn.HasCapability("echo-message") {
+		return false
+	}
+	me := conn.state.Me()
+	fold := conn.state.ISupport().Fold
+	return me != "" && fold(e.Nick) == fold(me)
+}
+
+// negotiate handles capability negotiation and SASL messages received from
+// the server.
+func (conn *Connection) negotiate(e *Event) error {
+	lines, err := conn.caps.handle(e)
+	for _, l := range lines {
+		conn.send(l)
+	}
+	return err
+}
+
+// Capabilities returns the IRCv3 capabilities enabled on the current
+// connection. If the Manager is not connected, the result is empty.
+func (m *Manager) Capabilities() []string {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+	if conn == nil {
+		return []string{}
+	}
+	return conn.Capabilities()
+}
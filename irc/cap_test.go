@@ -0,0 +1,246 @@
+package irc
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func expectReply(t *testing.T, c *capabilities, line string, expected ...string) {
+	t.Helper()
+	res, err := c.handle(parseLine(line))
+	if err != nil {
+		t.Fatalf("unexpected error handling %s: %s", line, err)
+	}
+	if len(res) != len(expected) || (len(res) > 0 && !reflect.DeepEqual(res, expected)) {
+		t.Errorf("expected reply to %s to be %q, got %q", line, expected, res)
+	}
+}
+
+func TestCapabilities_negotiate(t *testing.T) {
+	c := newCapabilities(Config{})
+	expectReply(t, c, ":irc.example.com CAP * LS * :multi-prefix sasl=PLAIN,EXTERNAL unknown")
+	// echo-message is only requested if it is configured.
+	expectReply(t, c, ":irc.example.com CAP * LS :server-time echo-message extended-join batch",
+		"CAP REQ :server-time extended-join multi-prefix batch")
+	// a rejected request is retried one capability at a time.
+	expectReply(t, c, ":irc.example.com CAP * NAK :server-time extended-join multi-prefix batch",
+		"CAP REQ :server-time", "CAP REQ :extended-join", "CAP REQ :multi-prefix", "CAP REQ :batch")
+	expectReply(t, c, ":irc.example.com CAP * ACK :server-time")
+	expectReply(t, c, ":irc.example.com CAP * NAK :multi-prefix")
+	expectReply(t, c, ":irc.example.com CAP * ACK :extended-join")
+	expectReply(t, c, ":irc.example.com CAP * ACK :batch", "CAP END")
+	if caps := c.List(); !reflect.DeepEqual(caps, []string{"batch", "extended-join", "server-time"}) {
+		t.Errorf("unexpected capabilities enabled: %v", caps)
+	}
+
+	// capabilities may change after registration with cap-notify.
+	expectReply(t, c, ":irc.example.com CAP squishyjones DEL :batch")
+	expectReply(t, c, ":irc.example.com CAP squishyjones NEW :away-notify other", "CAP REQ :away-notify")
+	expectReply(t, c, ":irc.example.com CAP squishyjones ACK :away-notify")
+	if caps := c.List(); !reflect.DeepEqual(caps, []string{"away-notify", "extended-join", "server-time"}) {
+		t.Errorf("unexpected capabilities enabled: %v", caps)
+	}
+
+	c = newCapabilities(Config{Capabilities: []string{"account-tag"}})
+	expectReply(t, c, ":irc.example.com CAP * LS :server-time", "CAP END")
+}
+
+func TestCapabilities_sasl(t *testing.T) {
+	c := newCapabilities(Config{SASL: true, SASLUsername: "squishy", SASLPassword: "secret"})
+	expectReply(t, c, ":irc.example.com CAP * LS :sasl server-time", "CAP REQ :server-time sasl")
+	expectReply(t, c, ":irc.example.com CAP * ACK :server-time sasl", "AUTHENTICATE PLAIN")
+	payload := base64.StdEncoding.EncodeToString([]byte("squishy\x00squishy\x00secret"))
+	expectReply(t, c, "AUTHENTICATE +", "AUTHENTICATE "+payload)
+	expectReply(t, c, ":irc.example.com 903 squishyjones :SASL authentication successful", "CAP END")
+
+	// long credentials are sent in chunks of 400 bytes, followed by "+"
+	// if the last chunk is full.
+	c = newCapabilities(Config{SASL: true, SASLUsername: "squishy", SASLPassword: strings.Repeat("x", 284)})
+	res := c.authenticate()
+	if len(res) != 2 || len(res[0]) != len("AUTHENTICATE ")+400 || res[1] != "AUTHENTICATE +" {
+		t.Errorf("unexpected AUTHENTICATE lines: %q", res)
+	}
+
+	c = newCapabilities(Config{SASL: true})
+	expectReply(t, c, ":irc.example.com CAP * LS :sasl", "CAP REQ :sasl")
+	expectReply(t, c, ":irc.example.com CAP * ACK :sasl", "AUTHENTICATE PLAIN")
+	res, err := c.handle(parseLine(":irc.example.com 904 squishyjones :SASL authentication failed"))
+	if err == nil || !reflect.DeepEqual(res, []string{"CAP END"}) {
+		t.Errorf("expected SASL failure to end negotiation with an error, got %q %v", res, err)
+	}
+
+	c = newCapabilities(Config{SASL: true})
+	if _, err := c.handle(parseLine(":irc.example.com CAP * LS :server-time")); err == nil {
+		t.Errorf("expected error when the server does not support SASL")
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tags := map[string]string{"+draft/reply": "abc", "flag": "", "+example": "a; b\\c\r\n"}
+	if res, expected := formatTags(tags), `@+draft/reply=abc;+example=a\:\sb\\c\r\n;flag `; res != expected {
+		t.Errorf("expected %q, got %q", expected, res)
+	}
+	if res := formatTags(nil); res != "" {
+		t.Errorf("expected no tags, got %q", res)
+	}
+	if target := queueTarget("@+draft/reply=abc PRIVMSG #Squircy :hi"); target != "#squircy" {
+		t.Errorf("expected tagged message to be queued for #squircy, got %q", target)
+	}
+	if !bypassesQueue("@label=1 CAP REQ :batch") {
+		t.Errorf("expected tagged CAP to bypass the queue")
+	}
+}
+
+func TestManager_capabilities(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:     "squishyjones",
+		Username: "mrjones",
+		Network:  s.Addr(),
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.PRIVMSG")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	// negotiation begins before registration so that the server waits for
+	// CAP END.
+	for _, prefix := range []string{"CAP LS 302", "NICK ", "USER "} {
+		if l := c.Expect(""); !strings.HasPrefix(l, prefix) {
+			t.Fatalf("expected line starting with %q, got %s", prefix, l)
+		}
+	}
+	c.Send(":irc.example.com CAP * LS :account-tag message-tags server-time sasl")
+	if req := c.Expect("CAP REQ"); req != "CAP REQ :server-time account-tag message-tags" {
+		t.Errorf("unexpected request: %s", req)
+	}
+	c.Send(":irc.example.com CAP * ACK :server-time account-tag message-tags")
+	c.Expect("CAP END")
+	c.Send(":irc.example.com 001 squishyjones :Welcome to the fake network")
+
+	c.Send("@time=2021-02-03T04:05:06.789Z;account=veo;+draft/reply=abc :veonik!v@example.com PRIVMSG #squircy :hi")
+	e, err := DecodeEvent(expectEvent(t, evs, "irc.PRIVMSG"))
+	if err != nil {
+		t.Fatalf("unexpected error decoding event: %s", err)
+	}
+	if expected := time.Date(2021, 2, 3, 4, 5, 6, 789000000, time.UTC); !e.Time.Equal(expected) {
+		t.Errorf("expected time %s, got %s", expected, e.Time)
+	}
+	if e.Account != "veo" || e.Tags["+draft/reply"] != "abc" || e.Message != "hi" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if caps := m.Capabilities(); !reflect.DeepEqual(caps, []string{"account-tag", "message-tags", "server-time"}) {
+		t.Errorf("unexpected capabilities enabled: %v", caps)
+	}
+
+	err = m.Do(func(conn *Connection) error {
+		return conn.TaggedPrivmsg(map[string]string{"+draft/reply": "abc"}, "#squircy", "hello")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error sending tagged message: %s", err)
+	}
+	if l := c.Expect("@"); l != "@+draft/reply=abc PRIVMSG #squircy :hello" {
+		t.Errorf("unexpected tagged message: %s", l)
+	}
+	err = m.Do(func(conn *Connection) error {
+		return conn.TaggedPrivmsg(map[string]string{"bad key": ""}, "#squircy", "hello")
+	})
+	if err == nil {
+		t.Errorf("expected error sending invalid tag")
+	}
+}
+
+func TestManager_capabilitiesNotSupported(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, _, cleanup := newTestManager(&Config{
+		Nick:     "squishyjones",
+		Username: "mrjones",
+		Network:  s.Addr(),
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	// servers without capability negotiation ignore CAP LS.
+	c.Register()
+	err := m.Do(func(conn *Connection) error {
+		return conn.TaggedPrivmsg(map[string]string{"+draft/reply": "abc"}, "#squircy", "hello")
+	})
+	if err != ErrTagsNotSupported {
+		t.Errorf("expected ErrTagsNotSupported, got %v", err)
+	}
+}
+
+func TestManager_capabilitiesBeforeRegistration(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, _, cleanup := newTestManager(&Config{
+		Nick:           "squishyjones",
+		Username:       "mrjones",
+		Network:        s.Addr(),
+		ServerPassword: "hunter2",
+		SASL:           true,
+		SASLUsername:   "squishy",
+		SASLPassword:   "secret",
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	var lines []string
+	for i := 0; i < 4; i++ {
+		lines = append(lines, c.Expect(""))
+	}
+	expected := []string{"CAP LS 302", "PASS hunter2", "NICK squishyjones", "USER mrjones 0.0.0.0 0.0.0.0 :mrjones"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+}
+
+func TestManager_echoMessage(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:         "squishyjones",
+		Username:     "mrjones",
+		Network:      s.Addr(),
+		Capabilities: []string{"echo-message"},
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.PRIVMSG")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Expect("CAP LS 302")
+	c.Send(":irc.example.com CAP * LS :echo-message")
+	c.Expect("CAP REQ :echo-message")
+	c.Send(":irc.example.com CAP * ACK :echo-message")
+	c.Expect("CAP END")
+	c.Send(":irc.example.com 001 squishyjones :Welcome to the fake network")
+
+	for _, tt := range []struct {
+		line string
+		echo bool
+	}{
+		{":SquishyJones!mrjones@host PRIVMSG #squircy :hi", true},
+		{":veonik!v@example.com PRIVMSG #squircy :hi", false},
+	} {
+		c.Send(tt.line)
+		e, err := DecodeEvent(expectEvent(t, evs, "irc.PRIVMSG"))
+		if err != nil {
+			t.Fatalf("unexpected error decoding event: %s", err)
+		}
+		if e.Echo != tt.echo {
+			t.Errorf("%s: expected Echo to be %v", tt.line, tt.echo)
+		}
+	}
+}
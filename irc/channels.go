@@ -0,0 +1,171 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRejoinDelay is used when RejoinDelay is not set.
+const DefaultRejoinDelay = 5 * time.Second
+
+// parseChannel splits a channel with an optional key, such as "#foo key",
+// into its name and key.
+func parseChannel(s string) (name, key string) {
+	fs := strings.Fields(s)
+	switch len(fs) {
+	case 0:
+		return "", ""
+	case 1:
+		return fs[0], ""
+	}
+	return fs[0], fs[1]
+}
+
+// setKey stores the key used to join the given channel.
+func (m *Manager) setKey(name, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fold := m.state.ISupport().Fold
+	for n := range m.keys {
+		if fold(n) == fold(name) {
+			delete(m.keys, n)
+		}
+	}
+	if key != "" {
+		m.keys[name] = key
+	}
+}
+
+// key returns the key used to join the given channel, if any.
+func (m *Manager) key(name string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	fold := m.state.ISupport().Fold
+	for n, k := range m.keys {
+		if fold(n) == fold(name) {
+			return k
+		}
+	}
+	return ""
+}
+
+// pendingChannels returns the channels to join after registering: each of
+// the configured channels followed by any channels the bot was in when the
+// connection was lost, sorted. Channel names are compared using the
+// server's case mapping. The configured keys are stored.
+func (m *Manager) pendingChannels(configured []string) []string {
+	m.mu.Lock()
+	rejoin := m.lostChannels
+	m.lostChannels = nil
+	m.mu.Unlock()
+	fold := m.state.ISupport().Fold
+	var res []string
+	seen := make(map[string]struct{})
+	for _, c := range configured {
+		name, key := parseChannel(c)
+		if name == "" {
+			continue
+		}
+		k := fold(name)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		if key != "" {
+			m.setKey(name, key)
+		}
+		res = append(res, name)
+	}
+	var others []string
+	for _, name := range rejoin {
+		k := fold(name)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		others = append(others, name)
+	}
+	sort.Strings(others)
+	return append(res, others...)
+}
+
+// Channels returns the channels the bot is in, sorted by name.
+func (m *Manager) Channels() []string {
+	return m.state.Channels()
+}
+
+// Join joins the given channel. The channel may be followed by a space and
+// its key, such as "#foo key". The key is remembered so that the channel
+// can be rejoined after reconnecting or being kicked.
+func (m *Manager) Join(channel string) error {
+	name, key := parseChannel(channel)
+	if name == "" {
+		return errors.New("channel name must not be empty")
+	}
+	if key != "" {
+		m.setKey(name, key)
+	}
+	return m.Do(func(conn *Connection) error {
+		sendJoin(conn, name, m.key(name))
+		return nil
+	})
+}
+
+// sendJoin sends a JOIN for the given channel, including the key if set.
+func sendJoin(conn *Connection, name, key string) {
+	if key != "" {
+		conn.SendRawf("JOIN %s %s", name, key)
+		return
+	}
+	conn.Join(name)
+}
+
+// joinChannels joins the configured channels and any channels the bot was
+// in before the connection was lost.
+func (m *Manager) joinChannels(conn *Connection) {
+	for _, name := range m.pendingChannels(conn.current.Channels) {
+		logrus.Debugf("irc: joining %s on %s", name, m.name)
+		sendJoin(conn, name, m.key(name))
+	}
+}
+
+// handleKick rejoins the channel if the bot was kicked from it and
+// rejoin_on_kick is enabled. The State must already have handled the event.
+func (m *Manager) handleKick(conn *Connection, e *Event) {
+	if len(e.Args) < 2 || !conn.current.RejoinOnKick {
+		return
+	}
+	me := m.state.Me()
+	if me == "" {
+		me = conn.GetNick()
+	}
+	fold := m.state.ISupport().Fold
+	if fold(e.Args[1]) != fold(me) {
+		return
+	}
+	go m.rejoin(conn, e.Args[0])
+}
+
+// rejoin joins the given channel after waiting for the configured delay,
+// unless the connection is closed first.
+func (m *Manager) rejoin(conn *Connection, channel string) {
+	delay := conn.current.RejoinDelay
+	if delay <= 0 {
+		delay = DefaultRejoinDelay
+	}
+	logrus.Infof("irc: kicked from %s on %s, rejoining in %s", channel, m.name, delay)
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-conn.quitting:
+		return
+	case <-conn.done:
+		return
+	}
+	sendJoin(conn, channel, m.key(channel))
+}
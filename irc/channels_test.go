@@ -0,0 +1,131 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+func TestParseChannel(t *testing.T) {
+	for in, expected := range map[string][2]string{
+		"#foo":         {"#foo", ""},
+		"#bar key":     {"#bar", "key"},
+		"  #baz  key ": {"#baz", "key"},
+		"":             {"", ""},
+	} {
+		name, key := parseChannel(in)
+		if name != expected[0] || key != expected[1] {
+			t.Errorf("%q: expected %v, got [%s %s]", in, expected, name, key)
+		}
+	}
+}
+
+func TestManager_pendingChannels(t *testing.T) {
+	m := NewManager(&Config{}, event.NewDispatcher())
+	// channels are compared using the server's case mapping, rfc1459 by
+	// default, in which "[" and "{" are equivalent.
+	m.lostChannels = []string{"#Rejoin", "#foo", "#Ba{"}
+	res := m.pendingChannels([]string{"#foo", "#ba[ key", "#FOO"})
+	if expected := []string{"#foo", "#ba[", "#Rejoin"}; !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, got %v", expected, res)
+	}
+	if m.key("#BA{") != "key" {
+		t.Errorf("expected key for #ba[ to be stored")
+	}
+	if res := m.pendingChannels(nil); len(res) != 0 {
+		t.Errorf("expected channels to be rejoined only once, got %v", res)
+	}
+	m.setKey("#BA[", "")
+	if k := m.key("#ba["); k != "" {
+		t.Errorf("expected key for #ba[ to be removed, got %s", k)
+	}
+}
+
+// waitForChannels waits until the Manager is in the expected channels.
+func waitForChannels(t *testing.T, m *Manager, expected ...string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		ch := m.Channels()
+		if reflect.DeepEqual(ch, expected) || (len(ch) == 0 && len(expected) == 0) {
+			return
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("expected to be in channels %v, got %v", expected, ch)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestManager_Channels(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:                "squishyjones",
+		Username:            "mrjones",
+		Network:             s.Addr(),
+		Channels:            []string{"#foo", "#bar key"},
+		RejoinOnKick:        true,
+		RejoinDelay:         10 * time.Millisecond,
+		Reconnect:           true,
+		ReconnectBackoff:    10 * time.Millisecond,
+		ReconnectMaxBackoff: 10 * time.Millisecond,
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	if l := c.Expect("JOIN "); l != "JOIN #foo" {
+		t.Errorf("expected to join #foo, got %s", l)
+	}
+	if l := c.Expect("JOIN "); l != "JOIN #bar key" {
+		t.Errorf("expected to join #bar with key, got %s", l)
+	}
+	c.Send(":squishyjones!mrjones@host JOIN #foo")
+	c.Send(":squishyjones!mrjones@host JOIN #bar")
+	waitForChannels(t, m, "#bar", "#foo")
+	evs := recordEvents(d, "irc.JOIN")
+	c.Send(":someone!user@host JOIN #bar")
+	expectEvent(t, evs, "irc.JOIN")
+	if u, ok := m.User("someone"); !ok || u.Host != "host" {
+		t.Errorf("expected state to track users in joined channels, got %+v", u)
+	}
+
+	go func() {
+		if err := m.Join("#baz"); err != nil {
+			t.Errorf("unexpected error joining: %s", err)
+		}
+	}()
+	if l := c.Expect("JOIN "); l != "JOIN #baz" {
+		t.Errorf("expected to join #baz, got %s", l)
+	}
+	c.Send(":squishyjones!mrjones@host JOIN #baz")
+	c.Send(":squishyjones!mrjones@host PART #foo :bye")
+	waitForChannels(t, m, "#bar", "#baz")
+
+	c.Send(":op!user@host KICK #bar squishyjones :out")
+	waitForChannels(t, m, "#baz")
+	if l := c.Expect("JOIN "); l != "JOIN #bar key" {
+		t.Errorf("expected to rejoin #bar with key, got %s", l)
+	}
+	c.Send(":squishyjones!mrjones@host JOIN #bar")
+	waitForChannels(t, m, "#bar", "#baz")
+
+	// after reconnecting, the configured channels are joined followed by
+	// the other channels the bot was in.
+	c.Kill()
+	c = s.Accept()
+	c.Register()
+	var joins []string
+	for i := 0; i < 3; i++ {
+		joins = append(joins, c.Expect("JOIN "))
+	}
+	if expected := []string{"JOIN #foo", "JOIN #bar key", "JOIN #baz"}; !reflect.DeepEqual(joins, expected) {
+		t.Errorf("expected %v after reconnecting, got %v", expected, joins)
+	}
+}
@@ -0,0 +1,52 @@
+package irc
+
+import "strings"
+
+// ctcpDelim delimits a CTCP message.
+const ctcpDelim = "\x01"
+
+// EncodeCTCP returns the CTCP message with the given command and
+// parameters, such as "\x01VERSION\x01" or "\x01ACTION waves\x01".
+func EncodeCTCP(command, params string) string {
+	if params == "" {
+		return ctcpDelim + command + ctcpDelim
+	}
+	return ctcpDelim + command + " " + params + ctcpDelim
+}
+
+// DecodeCTCP returns the command and parameters of a CTCP message, such as
+// the text of a PRIVMSG or NOTICE. ok is false if message is not a CTCP
+// message. The closing delimiter is optional, as some clients omit it.
+func DecodeCTCP(message string) (command, params string, ok bool) {
+	if len(message) < 2 || !strings.HasPrefix(message, ctcpDelim) {
+		return "", "", false
+	}
+	message = strings.TrimSuffix(message[1:], ctcpDelim)
+	command = message
+	if i := strings.IndexByte(message, ' '); i >= 0 {
+		command, params = message[:i], message[i+1:]
+	}
+	if command == "" {
+		return "", "", false
+	}
+	return asciiUpper(command), params, true
+}
+
+// CTCP sends a CTCP request to target, such as "VERSION" or "PING".
+func (conn *Connection) CTCP(target, command, params string) {
+	conn.sendCTCP("PRIVMSG", target, command, params)
+}
+
+// CTCPReply sends a reply to a CTCP request from target.
+func (conn *Connection) CTCPReply(target, command, params string) {
+	conn.sendCTCP("NOTICE", target, command, params)
+}
+
+func (conn *Connection) sendCTCP(msgType, target, command, params string) {
+	command = asciiUpper(command)
+	if params == "" {
+		conn.sendChecked(msgType + " " + target + " :" + EncodeCTCP(command, ""))
+		return
+	}
+	conn.sendMessage(nil, msgType, target, ctcpDelim+command+" ", ctcpDelim, params)
+}
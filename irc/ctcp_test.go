@@ -0,0 +1,52 @@
+package irc
+
+import "testing"
+
+func TestEncodeCTCP(t *testing.T) {
+	if s := EncodeCTCP("VERSION", ""); s != "\x01VERSION\x01" {
+		t.Errorf("unexpected VERSION request: %q", s)
+	}
+	if s := EncodeCTCP("ACTION", "waves"); s != "\x01ACTION waves\x01" {
+		t.Errorf("unexpected ACTION: %q", s)
+	}
+}
+
+func TestDecodeCTCP(t *testing.T) {
+	tests := []struct {
+		message, command, params string
+		ok                       bool
+	}{
+		{"\x01VERSION\x01", "VERSION", "", true},
+		{"\x01ACTION waves hello\x01", "ACTION", "waves hello", true},
+		{"\x01ping 12345", "PING", "12345", true},
+		{"\x01ACTION \x01", "ACTION", "", true},
+		{"hello", "", "", false},
+		{"\x01", "", "", false},
+		{"\x01\x01", "", "", false},
+		{"\x01 waves\x01", "", "", false},
+	}
+	for _, test := range tests {
+		command, params, ok := DecodeCTCP(test.message)
+		if command != test.command || params != test.params || ok != test.ok {
+			t.Errorf("%q: expected %q, %q, %v, got %q, %q, %v", test.message, test.command, test.params, test.ok, command, params, ok)
+		}
+	}
+}
+
+func TestConnection_CTCP(t *testing.T) {
+	conn, err := newConnection(Config{Nick: "squishyjones", Username: "mrjones"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.CTCP("squishy", "version", "")
+	conn.CTCPReply("squishy", "PING", "12345")
+	expected := []string{
+		"PRIVMSG squishy :\x01VERSION\x01",
+		"NOTICE squishy :\x01PING 12345\x01",
+	}
+	for _, e := range expected {
+		if l, _ := conn.queue.pop(); l != e {
+			t.Errorf("expected %q, got %q", e, l)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package irc
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	irc "github.com/thoj/go-ircevent"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+// An Event is the payload of the "irc.<CODE>" events emitted for each
+// message received from the IRC server, such as "irc.PRIVMSG". Events from
+// networks other than the default are named "irc.<network>.<CODE>".
+//
+// Events are emitted with the map representation returned by Map so that
+// they remain accessible to scripts. Go handlers can use DecodeEvent or
+// HandlerFunc to get the typed value.
+type Event struct {
+	// Network is the name of the network the message was received from.
+	Network string
+	// Code is the IRC command or numeric reply, such as "PRIVMSG" or "001".
+	Code string
+	// Raw is the raw line received from the server.
+	Raw string
+	// Source is the full prefix of the message, usually nick!user@host.
+	Source string
+	Nick   string
+	User   string
+	Host   string
+	// Target is the first argument of the message, usually a channel or
+	// the bot's nickname.
+	Target string
+	// Message is the last argument of the message.
+	Message string
+	// Args contains all of the arguments of the message.
+	Args []string
+	// Tags contains the IRCv3 message tags sent with the message.
+	Tags map[string]string
+	// Time is when the message was sent, from the server-time tag if it
+	// is present, or otherwise when it was received.
+	Time time.Time
+	// Account is the account the source is logged in to, from the
+	// account-tag capability or an extended-join JOIN, if known.
+	Account string
+	// Echo is true if the message was sent by the bot and echoed back by
+	// the server, with the echo-message capability.
+	Echo bool
+}
+
+// NewEvent returns an Event describing the received message.
+func NewEvent(ev *irc.Event) *Event {
+	target := ""
+	if len(ev.Arguments) > 0 {
+		target = ev.Arguments[0]
+	}
+	e := &Event{
+		Code:    ev.Code,
+		Raw:     ev.Raw,
+		Source:  ev.Source,
+		Nick:    ev.Nick,
+		User:    ev.User,
+		Host:    ev.Host,
+		Target:  target,
+		Message: ev.Message(),
+		Args:    append([]string{}, ev.Arguments...),
+		Tags:    copyTags(ev.Tags),
+		Time:    time.Now(),
+		Account: ev.Tags["account"],
+	}
+	if t, err := time.Parse(time.RFC3339Nano, ev.Tags["time"]); err == nil {
+		e.Time = t
+	}
+	if e.Account == "" && ev.Code == "JOIN" && len(ev.Arguments) >= 3 && ev.Arguments[1] != "*" {
+		// extended-join includes the account after the channel.
+		e.Account = ev.Arguments[1]
+	}
+	return e
+}
+
+// copyTags returns a copy of the given tags, never nil.
+func copyTags(tags map[string]string) map[string]string {
+	res := make(map[string]string, len(tags))
+	for k, v := range tags {
+		res[k] = v
+	}
+	return res
+}
+
+// Map returns the representation of the Event used as event data.
+func (e *Event) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"Network": e.Network,
+		"User":    e.User,
+		"Host":    e.Host,
+		"Source":  e.Source,
+		"Code":    e.Code,
+		"Message": e.Message,
+		"Nick":    e.Nick,
+		"Target":  e.Target,
+		"Raw":     e.Raw,
+		"Args":    append([]string{}, e.Args...),
+		"Tags":    copyTags(e.Tags),
+		"Time":    e.Time,
+		"Account": e.Account,
+		"Echo":    e.Echo,
+	}
+}
+
+// EventFromMap returns the Event represented by the given event data.
+// Missing fields are left empty; an error is returned if a field has an
+// unexpected type.
+func EventFromMap(data map[string]interface{}) (*Event, error) {
+	e := &Event{}
+	fields := map[string]*string{
+		"Network": &e.Network,
+		"User":    &e.User,
+		"Host":    &e.Host,
+		"Source":  &e.Source,
+		"Code":    &e.Code,
+		"Message": &e.Message,
+		"Nick":    &e.Nick,
+		"Target":  &e.Target,
+		"Raw":     &e.Raw,
+		"Account": &e.Account,
+	}
+	for k, p := range fields {
+		v, ok := data[k]
+		if !ok || v == nil {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.Errorf("irc: expected event field %s to be string, got %T", k, v)
+		}
+		*p = s
+	}
+	switch v := data["Args"].(type) {
+	case nil:
+	case []string:
+		e.Args = append([]string{}, v...)
+	case []interface{}:
+		// event data decoded from JSON or exported from javascript.
+		for _, a := range v {
+			s, ok := a.(string)
+			if !ok {
+				return nil, errors.Errorf("irc: expected event field Args to contain strings, got %T", a)
+			}
+			e.Args = append(e.Args, s)
+		}
+	default:
+		return nil, errors.Errorf("irc: expected event field Args to be []string, got %T", v)
+	}
+	switch v := data["Tags"].(type) {
+	case nil:
+	case map[string]string:
+		e.Tags = copyTags(v)
+	case map[string]interface{}:
+		e.Tags = make(map[string]string, len(v))
+		for k, t := range v {
+			s, ok := t.(string)
+			if !ok {
+				return nil, errors.Errorf("irc: expected event field Tags to contain strings, got %T", t)
+			}
+			e.Tags[k] = s
+		}
+	default:
+		return nil, errors.Errorf("irc: expected event field Tags to be map[string]string, got %T", v)
+	}
+	switch v := data["Time"].(type) {
+	case nil:
+	case time.Time:
+		e.Time = v
+	case string:
+		// event data decoded from JSON.
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, errors.Wrap(err, "irc: invalid event field Time")
+		}
+		e.Time = t
+	default:
+		return nil, errors.Errorf("irc: expected event field Time to be time.Time, got %T", v)
+	}
+	switch v := data["Echo"].(type) {
+	case nil:
+	case bool:
+		e.Echo = v
+	default:
+		return nil, errors.Errorf("irc: expected event field Echo to be bool, got %T", v)
+	}
+	return e, nil
+}
+
+// DecodeEvent returns the Event carried by the given irc event.
+func DecodeEvent(ev *event.Event) (*Event, error) {
+	return EventFromMap(ev.Data)
+}
+
+// HandlerFunc returns an event.Handler that decodes the Event before
+// calling fn. Events that cannot be decoded are logged and skipped.
+func HandlerFunc(fn func(ev *event.Event, e *Event)) event.Handler {
+	return event.HandlerFunc(func(ev *event.Event) {
+		e, err := DecodeEvent(ev)
+		if err != nil {
+			ev.Fail(errors.Wrapf(err, "unable to decode %s event", ev.Name))
+			return
+		}
+		fn(ev, e)
+	})
+}
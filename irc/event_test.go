@@ -0,0 +1,86 @@
+package irc_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	ircevent "github.com/thoj/go-ircevent"
+
+	"code.dopame.me/veonik/squircy3/event"
+	"code.dopame.me/veonik/squircy3/irc"
+)
+
+func TestEventFromMap(t *testing.T) {
+	e := irc.NewEvent(&ircevent.Event{
+		Code:      "PRIVMSG",
+		Raw:       ":veonik!v@example.com PRIVMSG #squircy :hello there",
+		Source:    "veonik!v@example.com",
+		Nick:      "veonik",
+		User:      "v",
+		Host:      "example.com",
+		Arguments: []string{"#squircy", "hello there"},
+	})
+	if e.Target != "#squircy" || e.Message != "hello there" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	d, err := irc.EventFromMap(e.Map())
+	if err != nil {
+		t.Fatalf("unexpected error decoding event: %s", err)
+	}
+	if !reflect.DeepEqual(e, d) {
+		t.Errorf("expected decoded event to equal original\nexpected %+v\ngot %+v", e, d)
+	}
+
+	// tags, time, and account survive being encoded as JSON.
+	e = irc.NewEvent(&ircevent.Event{
+		Code:      "JOIN",
+		Raw:       "@time=2021-02-03T04:05:06.789Z :veonik!v@example.com JOIN #squircy veo :Veonik",
+		Source:    "veonik!v@example.com",
+		Arguments: []string{"#squircy", "veo", "Veonik"},
+		Tags:      map[string]string{"time": "2021-02-03T04:05:06.789Z"},
+	})
+	if e.Account != "veo" || e.Time.Format(time.RFC3339Nano) != "2021-02-03T04:05:06.789Z" {
+		t.Errorf("unexpected account %q and time %s", e.Account, e.Time)
+	}
+	b, err := json.Marshal(e.Map())
+	if err != nil {
+		t.Fatalf("unexpected error encoding event: %s", err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatalf("unexpected error decoding JSON: %s", err)
+	}
+	d, err = irc.EventFromMap(data)
+	if err != nil {
+		t.Fatalf("unexpected error decoding event: %s", err)
+	}
+	if !d.Time.Equal(e.Time) || d.Account != "veo" || !reflect.DeepEqual(d.Tags, e.Tags) {
+		t.Errorf("expected decoded event to equal original\nexpected %+v\ngot %+v", e, d)
+	}
+
+	// event data decoded from JSON or exported from javascript
+	d, err = irc.EventFromMap(map[string]interface{}{"Code": "JOIN", "Args": []interface{}{"#squircy"}})
+	if err != nil {
+		t.Fatalf("unexpected error decoding event: %s", err)
+	}
+	if d.Code != "JOIN" || len(d.Args) != 1 || d.Args[0] != "#squircy" {
+		t.Errorf("unexpected event: %+v", d)
+	}
+
+	if _, err := irc.EventFromMap(map[string]interface{}{"Target": 1}); err == nil {
+		t.Errorf("expected error decoding event with invalid Target")
+	}
+}
+
+func TestHandlerFunc(t *testing.T) {
+	var got *irc.Event
+	h := irc.HandlerFunc(func(ev *event.Event, e *irc.Event) {
+		got = e
+	})
+	h.Handle(&event.Event{Name: "irc.PRIVMSG", Data: map[string]interface{}{"Nick": "veonik", "Message": "hi"}})
+	if got == nil || got.Nick != "veonik" || got.Message != "hi" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+}
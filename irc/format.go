@@ -0,0 +1,152 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// mIRC formatting codes.
+const (
+	Bold          = "\x02"
+	Italic        = "\x1d"
+	Underline     = "\x1f"
+	Strikethrough = "\x1e"
+	Monospace     = "\x11"
+	Reverse       = "\x16"
+	Reset         = "\x0f"
+	Color         = "\x03"
+	HexColor      = "\x04"
+)
+
+// StripFormatting returns s with all mIRC formatting codes removed.
+func StripFormatting(s string) string {
+	if !strings.ContainsAny(s, "\x02\x1d\x1f\x1e\x11\x16\x0f\x03\x04") {
+		return s
+	}
+	var b strings.Builder
+	for _, a := range atomize(s) {
+		if !a.code {
+			b.WriteString(a.s)
+		}
+	}
+	return b.String()
+}
+
+// ansiColors contains the ANSI foreground color for each of the 16 standard
+// mIRC colors. The background color is the foreground color plus 10.
+var ansiColors = [16]int{97, 30, 34, 32, 91, 31, 35, 33, 93, 92, 36, 96, 94, 95, 90, 37}
+
+// ansi returns the ANSI escape sequence that sets the formatting, starting
+// from no formatting.
+func (f formatting) ansi() string {
+	params := []string{"0"}
+	for _, v := range []struct {
+		on   bool
+		code string
+	}{
+		{f.bold, "1"},
+		{f.italic, "3"},
+		{f.underline, "4"},
+		{f.reverse, "7"},
+		{f.strikethrough, "9"},
+	} {
+		if v.on {
+			params = append(params, v.code)
+		}
+	}
+	if f.hexColor != "" {
+		fg, bg := splitColor(f.hexColor)
+		if c, ok := ansiHexColor(fg); ok {
+			params = append(params, "38;2;"+c)
+		}
+		if c, ok := ansiHexColor(bg); ok {
+			params = append(params, "48;2;"+c)
+		}
+	} else if f.color != "" {
+		fg, bg := splitColor(f.color)
+		if n, err := strconv.Atoi(fg); err == nil && n < len(ansiColors) {
+			params = append(params, strconv.Itoa(ansiColors[n]))
+		}
+		if n, err := strconv.Atoi(bg); err == nil && n < len(ansiColors) {
+			params = append(params, strconv.Itoa(ansiColors[n]+10))
+		}
+	}
+	return "\x1b[" + strings.Join(params, ";") + "m"
+}
+
+// splitColor splits the parameters of a color code into the foreground and
+// background colors.
+func splitColor(params string) (fg, bg string) {
+	if i := strings.IndexByte(params, ','); i >= 0 {
+		return params[:i], params[i+1:]
+	}
+	return params, ""
+}
+
+// ansiHexColor returns the "r;g;b" parameters for a hex color such as
+// "FF8000".
+func ansiHexColor(hex string) (string, bool) {
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return "", false
+	}
+	return strconv.Itoa(int(n>>16)) + ";" + strconv.Itoa(int(n>>8&0xff)) + ";" + strconv.Itoa(int(n&0xff)), true
+}
+
+// FormattingToANSI returns s with its mIRC formatting codes converted to
+// ANSI escape sequences, for display in a terminal. Only the 16 standard
+// mIRC colors are converted, and monospace is ignored. If any formatting is
+// in effect at the end of s, the terminal's formatting is reset.
+//
+// Other control characters, including ESC, are removed so that text from
+// IRC cannot write its own escape sequences to the terminal.
+func FormattingToANSI(s string) string {
+	const reset = "\x1b[0m"
+	var b strings.Builder
+	var state formatting
+	changed, styled := false, false
+	for _, a := range atomize(s) {
+		if a.code {
+			state.apply(a.s)
+			changed = true
+			continue
+		}
+		if changed {
+			// write the sequence only before some text, so that
+			// consecutive codes result in a single sequence.
+			seq := state.ansi()
+			if seq != reset || styled {
+				b.WriteString(seq)
+			}
+			styled = seq != reset
+			changed = false
+		}
+		b.WriteString(stripControls(a.s))
+	}
+	if styled {
+		b.WriteString(reset)
+	}
+	return b.String()
+}
+
+// stripControls returns s without C0 and C1 control characters other than
+// tab. Invalid UTF-8 is left as is; terminals do not interpret a lone C1
+// byte as a control character in UTF-8 mode.
+func stripControls(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if !isControl(r) {
+			b.WriteString(s[i : i+n])
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// isControl returns true if r is a C0 or C1 control character other than
+// tab.
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\t' || r >= 0x7f && r <= 0x9f
+}
@@ -0,0 +1,49 @@
+package irc
+
+import "testing"
+
+func TestStripFormatting(t *testing.T) {
+	tests := []struct {
+		s, expected string
+	}{
+		{"plain text", "plain text"},
+		{Bold + "bold" + Bold + " " + Italic + "italic" + Reset, "bold italic"},
+		{"\x034,12red on blue\x03 plain", "red on blue plain"},
+		{"\x0399,99default\x03,", "default,"},
+		{"\x0312,text", ",text"},
+		{"\x031234", "34"},
+		{"\x04FF8000orange\x04", "orange"},
+		{Underline + Strikethrough + Monospace + Reverse + "ünïcode", "ünïcode"},
+	}
+	for _, test := range tests {
+		if s := StripFormatting(test.s); s != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.s, test.expected, s)
+		}
+	}
+}
+
+func TestFormattingToANSI(t *testing.T) {
+	tests := []struct {
+		name, s, expected string
+	}{
+		{"plain", "plain text", "plain text"},
+		{"bold", Bold + "bold" + Bold + " plain", "\x1b[0;1mbold\x1b[0m plain"},
+		{"trailing reset", Bold + "bold", "\x1b[0;1mbold\x1b[0m"},
+		{"combined", Bold + Italic + Underline + Reverse + Strikethrough + "all" + Reset, "\x1b[0;1;3;4;7;9mall\x1b[0m"},
+		{"color", "\x034,12red on blue\x03 plain", "\x1b[0;91;104mred on blue\x1b[0m plain"},
+		{"color keeps background", "\x031,2a\x033b", "\x1b[0;30;44ma\x1b[0;32;44mb\x1b[0m"},
+		{"extended color", "\x0352text", "text"},
+		{"hex color", "\x04FF8000,000010orange", "\x1b[0;38;2;255;128;0;48;2;0;0;16morange\x1b[0m"},
+		{"codes without text", "text" + Bold + Reset, "text"},
+		{"monospace", Monospace + "code", "code"},
+		{"escape sequence", "\x1b]0;title\x07\x1b[2Jtext\u009b1m\tend", "]0;title[2Jtext1m\tend"},
+		{"escape sequence with formatting", Bold + "\x1b[" + Bold, "\x1b[0;1m[\x1b[0m"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if s := FormattingToANSI(test.s); s != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+//go:build go1.18
+// +build go1.18
+
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzParseMessage(f *testing.F) {
+	for _, s := range []string{
+		"PING :irc.example.com",
+		":squishy!mrjones@localhost PRIVMSG #squircy :hello there",
+		"@time=2021-01-01T00:00:00.000Z;+draft/reply=abc;flag :squishy TAGMSG #squircy",
+		`@esc=a\sb\:c\\d\ne\r;bad=\x\ PRIVMSG #squircy ::)`,
+		":irc.example.com 005 squishyjones PREFIX=(ov)@+ CHANTYPES=# :are supported by this server",
+		"MODE   #squircy  +o  squishy",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		m, err := ParseMessage(line)
+		if err != nil {
+			return
+		}
+		if err := m.Validate(); err != nil {
+			t.Fatalf("parsed message is not valid: %s", err)
+		}
+		s := m.String()
+		if strings.ContainsAny(s, "\r\n\x00") {
+			t.Fatalf("serialized message contains CR, LF, or NUL: %q", s)
+		}
+		m2, err := ParseMessage(s)
+		if err != nil {
+			t.Fatalf("unable to parse serialized message %q: %s", s, err)
+		}
+		if !reflect.DeepEqual(m, m2) {
+			t.Fatalf("expected %#v, got %#v after round trip through %q", m, m2, s)
+		}
+	})
+}
+
+func FuzzSplitMessage(f *testing.F) {
+	for _, s := range []string{
+		"the quick brown fox jumps",
+		"\x02bold\x02 \x034,12red on blue\x03 \x04FF0000hex",
+		"ünïcode wörds",
+		"one\ntwo\r\nthree",
+		"two  spaces   three",
+	} {
+		f.Add(s, 10)
+	}
+	// the bytes on either side of a dropped space form a line separator
+	// when joined.
+	f.Add("00 00000000000000000\xe2 \x80\xa8", 6)
+	f.Fuzz(func(t *testing.T, text string, max int) {
+		if max < 1 || max > MaxLineLength {
+			return
+		}
+		var all []string
+		for _, l := range splitLines(text) {
+			msgs := SplitMessage(l, max)
+			var chunks []string
+			for _, m := range msgs {
+				if strings.ContainsAny(m, "\r\n\x00") {
+					t.Fatalf("message contains CR, LF, or NUL: %q", m)
+				}
+				if utf8.ValidString(text) && !utf8.ValidString(m) {
+					t.Fatalf("message splits a character: %q", m)
+				}
+				chunks = append(chunks, StripFormatting(m))
+			}
+			if !rejoins(StripFormatting(l), chunks) {
+				t.Fatalf("expected %q to be split between words, got %q", StripFormatting(l), chunks)
+			}
+			all = append(all, msgs...)
+		}
+		if res := SplitMessage(text, max); !reflect.DeepEqual(res, all) {
+			t.Fatalf("expected each line to be split separately, got %q", res)
+		}
+	})
+}
+
+// rejoins returns true if joining chunks results in text, allowing a single
+// space to be dropped between chunks and at the end of text.
+func rejoins(text string, chunks []string) bool {
+	// positions contains each offset in text where the next chunk may
+	// begin.
+	positions := map[int]bool{0: true}
+	for _, c := range chunks {
+		next := make(map[int]bool)
+		for p := range positions {
+			if strings.HasPrefix(text[p:], c) {
+				next[p+len(c)] = true
+			}
+			if strings.HasPrefix(text[p:], " "+c) {
+				next[p+1+len(c)] = true
+			}
+		}
+		positions = next
+	}
+	return positions[len(text)] || positions[len(text)-1] && strings.HasSuffix(text, " ")
+}
+
+func FuzzFormattingToANSI(f *testing.F) {
+	for _, s := range []string{
+		"plain",
+		"\x02bold\x0f \x1d\x1f\x1e\x16all",
+		"\x034,12red\x03 \x04FF8000,000000hex",
+		"\x1b[",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		res := FormattingToANSI(s)
+		if i := strings.LastIndex(res, "\x1b["); i >= 0 && !strings.HasPrefix(res[i:], "\x1b[0m") {
+			t.Fatalf("expected formatting to be reset at the end of %q", res)
+		}
+		text := stripANSI(res)
+		for _, r := range text {
+			if r < 0x20 && r != '\t' || r >= 0x7f && r <= 0x9f {
+				t.Fatalf("expected control characters to be removed, got %q", res)
+			}
+		}
+		if expected := strings.Map(func(r rune) rune {
+			if r < 0x20 && r != '\t' || r >= 0x7f && r <= 0x9f {
+				return -1
+			}
+			return r
+		}, StripFormatting(s)); utf8.ValidString(s) && text != expected {
+			t.Fatalf("expected text %q, got %q", expected, text)
+		}
+	})
+}
+
+// stripANSI removes the SGR escape sequences written by FormattingToANSI.
+func stripANSI(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "\x1b[")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String()
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+		s = s[strings.IndexByte(s, 'm')+1:]
+	}
+}
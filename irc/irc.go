@@ -2,7 +2,6 @@
 package irc // import "code.dopame.me/veonik/squircy3/irc"
 
 import (
-	"crypto/tls"
 	"log"
 	"sync"
 	"time"
@@ -10,12 +9,16 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	irc "github.com/thoj/go-ircevent"
+	"golang.org/x/text/encoding"
 
 	"code.dopame.me/veonik/squircy3/event"
 )
 
 var ErrNotConnected = errors.New("not connected")
 
+// ErrDisabled is returned when connecting while the Manager is disabled.
+var ErrDisabled = errors.New("irc is disabled")
+
 type Config struct {
 	Nick     string `toml:"nick"`
 	Username string `toml:"user"`
@@ -27,17 +30,115 @@ type Config struct {
 	SASL         bool   `toml:"sasl"`
 	SASLUsername string `toml:"sasl_username"`
 	SASLPassword string `toml:"sasl_password"`
+	// SASLMechanism is either PLAIN, the default, or EXTERNAL to
+	// authenticate with the client certificate.
+	SASLMechanism string `toml:"sasl_mechanism"`
+
+	// TLSCert and TLSKey are the paths to the PEM-encoded client certificate
+	// and key presented when TLS is enabled, such as for CertFP. If TLSKey
+	// is empty, the key is read from TLSCert. Relative paths are relative to
+	// the root directory.
+	TLSCert string `toml:"tls_cert"`
+	TLSKey  string `toml:"tls_key"`
+	// TLSCA is the path to a PEM-encoded bundle of certificate authorities
+	// to trust instead of the system's.
+	TLSCA string `toml:"tls_ca"`
+	// TLSInsecureSkipVerify disables verification of the server's
+	// certificate. It should only be used with test networks.
+	TLSInsecureSkipVerify bool `toml:"tls_insecure_skip_verify"`
+	// TLSPins contains the SHA-256 fingerprints of the server certificates
+	// to trust. If it is not empty, the server's certificate must match one
+	// of them and is not otherwise verified.
+	TLSPins []string `toml:"tls_pins"`
 
 	ServerPassword string `toml:"server_password"`
 
+	// Capabilities contains the IRCv3 capabilities to request, if the
+	// server supports them. If it is empty, DefaultCapabilities are
+	// requested.
+	Capabilities []string `toml:"capabilities"`
+
+	// Channels contains the channels to join after connecting. Each channel
+	// may be followed by a space and its key, such as "#foo key".
+	Channels []string `toml:"channels"`
+	// RejoinOnKick enables rejoining a channel after being kicked from it.
+	RejoinOnKick bool `toml:"rejoin_on_kick"`
+	// RejoinDelay is the amount of time to wait before rejoining a channel
+	// after being kicked from it.
+	RejoinDelay time.Duration `toml:"rejoin_delay"`
+
+	// Reconnect enables automatically reconnecting when the connection is
+	// lost unexpectedly.
+	Reconnect bool `toml:"reconnect"`
+	// ReconnectBackoff is the amount of time to wait before the first
+	// reconnect attempt. The wait is doubled after each failed attempt.
+	ReconnectBackoff time.Duration `toml:"reconnect_backoff"`
+	// ReconnectMaxBackoff is the maximum amount of time to wait between
+	// reconnect attempts.
+	ReconnectMaxBackoff time.Duration `toml:"reconnect_max_backoff"`
+	// ReconnectJitter randomly varies each wait by up to this fraction of
+	// it, between 0 and 1.
+	ReconnectJitter float64 `toml:"reconnect_jitter"`
+	// ReconnectMaxAttempts is the number of consecutive attempts to make
+	// before giving up. If it is zero, attempts are made indefinitely.
+	ReconnectMaxAttempts int `toml:"reconnect_max_attempts"`
+
+	// SendBurst is the number of lines that can be sent at once before
+	// lines are limited to SendRate.
+	SendBurst int `toml:"send_burst"`
+	// SendRate is the number of lines sent per second after the burst is
+	// used up. If it is negative, lines are not rate limited.
+	SendRate float64 `toml:"send_rate"`
+	// SendQueueSize is the maximum number of lines waiting to be sent.
+	// Lines sent while the queue is full are dropped.
+	SendQueueSize int `toml:"send_queue_size"`
+
+	// Networks contains the raw configuration of each [irc.networks.<name>]
+	// section. Each network inherits any option it does not set from the
+	// [irc] section. If it is empty, the [irc] section itself describes the
+	// only network.
+	Networks map[string]interface{} `toml:"networks"`
+	// DefaultNetwork is the name of the network addressed when no network is
+	// specified. If it is empty, the first network by name is used.
+	DefaultNetwork string `toml:"default_network"`
+
 	Version string
+	RootDir string `flag:"root_path"`
 }
 
 type Manager struct {
+	// name is the name of the network the Manager connects to.
+	name   string
 	config *Config
 	events *event.Dispatcher
 	conn   *Connection
 
+	// state tracks the channels and users on the network.
+	state *State
+	// keys contains the key used to join each channel, by name.
+	keys map[string]string
+	// lostChannels contains the channels the bot was in when the connection was
+	// lost, to be joined the next time it is registered.
+	lostChannels []string
+
+	// lastErr is the most recent error encountered by the connection.
+	lastErr error
+	// disabled is true if connecting is not allowed.
+	disabled bool
+
+	// isDefault is true if the Manager is for the default network, in which
+	// case its events are also emitted without the network name.
+	isDefault bool
+	// closed is closed when the Manager is shut down.
+	closed chan struct{}
+
+	// reconnecting is closed to cancel reconnecting, or nil if the Manager
+	// is not reconnecting.
+	reconnecting chan struct{}
+	// attempts is the number of consecutive reconnect attempts made since
+	// the connection was last registered with the server.
+	attempts int
+
 	mu sync.RWMutex
 }
 
@@ -56,17 +157,35 @@ func (m *Manager) SetVersionString(v string) {
 type Connection struct {
 	*irc.Connection
 
-	current  Config
+	current Config
+	queue   *sendQueue
+	caps    *capabilities
+	// state is the State of the network, used to determine the bot's own
+	// prefix.
+	state    *State
 	quitting chan struct{}
 	done     chan struct{}
+
+	// lostErr is the error that caused the connection to be lost, or nil if
+	// the connection was closed intentionally. It is set before done is
+	// closed.
+	lostErr error
+	mu      sync.Mutex
+	// quitOnce ensures QUIT is sent only once.
+	quitOnce sync.Once
 }
 
+// Connect opens the connection. Capability negotiation begins before
+// registration; see registrationEncoding.
 func (conn *Connection) Connect() error {
 	conn.Connection.Lock()
 	defer conn.Connection.Unlock()
 	return conn.Connection.Connect(conn.current.Network)
 }
 
+// Quit sends QUIT and waits for the connection to close. Lines waiting in
+// the send queue are sent first, unless they take longer than
+// quitFlushTimeout, in which case the rest are dropped.
 func (conn *Connection) Quit() (err error) {
 	select {
 	case <-conn.done:
@@ -76,9 +195,16 @@ func (conn *Connection) Quit() (err error) {
 		// already quitting, nothing to do
 
 	default:
-		logrus.Debugln("quitting")
-		conn.Connection.Quit()
-		close(conn.quitting)
+		conn.quitOnce.Do(func() {
+			if !conn.queue.flush(quitFlushTimeout, conn.done) {
+				if st := conn.queue.Stats(); st.Queued > 0 {
+					logrus.Warnf("irc: quitting with %d lines still queued", st.Queued)
+				}
+			}
+			logrus.Debugln("quitting")
+			conn.Connection.Quit()
+			conn.closeQuitting()
+		})
 	}
 	// block until done
 	select {
@@ -86,27 +212,37 @@ func (conn *Connection) Quit() (err error) {
 		break
 
 	case <-time.After(1 * time.Second):
-		go func() {
-			// go-ircevent may close irc.pwrite multiple times, so catch
-			// the ensuing panic when it happens.
-			// todo: try to fix this upstream
-			defer func() {
-				if e := recover(); err != nil {
-					if er, ok := err.(error); ok {
-						err = errors.Wrapf(er, "error during disconnect")
-						return
-					}
-					err = errors.Errorf("error during disconnect: %v", e)
-				}
-			}()
-			conn.Connection.Disconnect()
-		}()
+		go conn.disconnect()
 		return errors.Errorf("timed out waiting for quit")
 	}
 	return nil
 }
 
-func (conn *Connection) controlLoop() {
+// closeQuitting closes the quitting channel if it is not already closed.
+func (conn *Connection) closeQuitting() {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	select {
+	case <-conn.quitting:
+	default:
+		close(conn.quitting)
+	}
+}
+
+// disconnect closes the underlying connection without sending QUIT.
+func (conn *Connection) disconnect() {
+	// go-ircevent may close irc.pwrite multiple times, so catch
+	// the ensuing panic when it happens.
+	// todo: try to fix this upstream
+	defer func() {
+		if e := recover(); e != nil {
+			logrus.Debugln("recovered from panic during disconnect:", e)
+		}
+	}()
+	conn.Connection.Disconnect()
+}
+
+func (conn *Connection) controlLoop(onError func(error)) {
 	errC := conn.ErrorChan()
 	for {
 		select {
@@ -127,29 +263,105 @@ func (conn *Connection) controlLoop() {
 				return
 			}
 			logrus.Warnln("Received irc connection error:", err)
+			onError(err)
 			if err != irc.ErrDisconnected {
-				if err = conn.Quit(); err != nil {
-					logrus.Warnln("Failed to quit irc cleanly:", err)
-				}
+				// the connection is unusable, so close it without trying
+				// to send QUIT.
+				conn.lostErr = err
+				conn.closeQuitting()
+				go conn.disconnect()
 			}
 		}
 	}
 }
 
+// NewManager returns a Manager for the network named DefaultNetworkName.
 func NewManager(c *Config, ev *event.Dispatcher) *Manager {
-	m := &Manager{config: c, events: ev}
+	return NewNetworkManager(DefaultNetworkName, c, ev)
+}
+
+// NewNetworkManager returns a Manager for the named network.
+//
+// Events are emitted as "irc.<name>.<CODE>", except for the network named
+// DefaultNetworkName, whose events are emitted as "irc.<CODE>".
+func NewNetworkManager(name string, c *Config, ev *event.Dispatcher) *Manager {
+	return newNetworkManager(name, c, ev, name == DefaultNetworkName)
+}
+
+// newNetworkManager returns a Manager for the named network. If isDefault
+// is true, events are also emitted as "irc.<CODE>".
+func newNetworkManager(name string, c *Config, ev *event.Dispatcher, isDefault bool) *Manager {
+	m := &Manager{
+		name:      name,
+		config:    c,
+		events:    ev,
+		state:     NewState(),
+		keys:      make(map[string]string),
+		isDefault: isDefault,
+		closed:    make(chan struct{}),
+	}
 	if c.AutoConnect {
 		go func() {
-			<-time.After(1 * time.Second)
-			logrus.Infof("Auto-connecting...")
+			select {
+			case <-time.After(1 * time.Second):
+			case <-m.closed:
+				return
+			}
+			if m.Disabled() {
+				logrus.Infof("Not auto-connecting to %s, irc is disabled", name)
+				return
+			}
+			logrus.Infof("Auto-connecting to %s...", name)
 			if err := m.Connect(); err != nil {
-				logrus.Errorln("failed to autoconnect:", err)
+				logrus.Errorf("failed to autoconnect to %s: %s", name, err)
+				if c.Reconnect && err != ErrDisabled {
+					m.reconnect()
+				}
 			}
 		}()
 	}
 	return m
 }
 
+// Name returns the name of the network the Manager connects to.
+func (m *Manager) Name() string {
+	return m.name
+}
+
+// Network returns the address of the network the Manager connects to.
+func (m *Manager) Network() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.config.Network
+}
+
+// eventName returns the name of the event emitted for the given code.
+func (m *Manager) eventName(code string) string {
+	if m.name == DefaultNetworkName {
+		return "irc." + code
+	}
+	return "irc." + m.name + "." + code
+}
+
+// emit emits the event for the given code. If the Manager is for the
+// default network but is not named DefaultNetworkName, the event is also
+// emitted as "irc.<CODE>" with a copy of data.
+func (m *Manager) emit(code string, data map[string]interface{}) {
+	m.events.Emit(m.eventName(code), data)
+	if m.isDefault && m.name != DefaultNetworkName {
+		cp := make(map[string]interface{}, len(data))
+		for k, v := range data {
+			cp[k] = v
+		}
+		m.events.Emit("irc."+code, cp)
+	}
+}
+
+// eventData returns data for events that carry no other information.
+func (m *Manager) eventData() map[string]interface{} {
+	return map[string]interface{}{"Network": m.name}
+}
+
 func (m *Manager) Do(fn func(*Connection) error) error {
 	m.mu.RLock()
 	conn := m.conn
@@ -162,71 +374,179 @@ func (m *Manager) Do(fn func(*Connection) error) error {
 	return fn(conn)
 }
 
-func newConnection(c Config) *Connection {
+func newConnection(c Config) (*Connection, error) {
 	conn := &Connection{
 		current:  c,
+		queue:    newSendQueue(c),
+		caps:     newCapabilities(c),
 		quitting: make(chan struct{}),
 		done:     make(chan struct{}),
 	}
 	conn.Connection = irc.IRC(c.Nick, c.Username)
 	conn.Log = log.New(logrus.StandardLogger().WriterLevel(logrus.InfoLevel), "", 0)
 	if c.TLS {
+		tc, err := tlsConfig(&c)
+		if err != nil {
+			return nil, err
+		}
 		conn.UseTLS = true
-		conn.TLSConfig = &tls.Config{}
-	}
-	if c.SASL {
-		conn.UseSASL = true
-		conn.SASLLogin = c.SASLUsername
-		conn.SASLPassword = c.SASLPassword
+		conn.TLSConfig = tc
 	}
+	// capabilities and SASL are negotiated by the Connection rather than
+	// go-ircevent, which only supports requesting SASL PLAIN.
+	conn.Encoding = registrationEncoding{Encoding: encoding.Nop, lines: []string{"CAP LS 302"}}
 	conn.Password = c.ServerPassword
 	conn.QuitMessage = "farewell"
 	conn.Version = c.Version
-	return conn
+	return conn, nil
+}
+
+// SetDisabled prevents or allows connecting to IRC.
+// Disabling the Manager does not disconnect an existing connection.
+func (m *Manager) SetDisabled(disabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disabled = disabled
+	if disabled {
+		m.cancelReconnect()
+	}
 }
 
+// Disabled returns true if connecting to IRC is not allowed.
+func (m *Manager) Disabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.disabled
+}
+
+var errAlreadyConnected = errors.New("already connected")
+
 func (m *Manager) Connect() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.connect()
+}
+
+// connect opens a new connection.
+// The Manager must be locked when calling this method.
+func (m *Manager) connect() error {
+	if m.disabled {
+		return ErrDisabled
+	}
 	if m.conn != nil {
-		return errors.New("already connected")
-	}
-	m.conn = newConnection(*m.config)
-	m.conn.AddCallback("*", func(ev *irc.Event) {
-		m.events.Emit("irc."+ev.Code, map[string]interface{}{
-			"User":    ev.User,
-			"Host":    ev.Host,
-			"Source":  ev.Source,
-			"Code":    ev.Code,
-			"Message": ev.Message(),
-			"Nick":    ev.Nick,
-			"Target":  ev.Arguments[0],
-			"Raw":     ev.Raw,
-			"Args":    append([]string{}, ev.Arguments...),
-		})
-	})
-	err := m.conn.Connect()
-	if err == nil {
-		go m.conn.controlLoop()
-		go func() {
-			m.events.Emit("irc.CONNECT", nil)
-			<-m.conn.done
-			m.events.Emit("irc.DISCONNECT", nil)
+		return errAlreadyConnected
+	}
+	conn, err := newConnection(*m.config)
+	if err != nil {
+		m.lastErr = err
+		return err
+	}
+	conn.state = m.state
+	conn.AddCallback("*", func(ev *irc.Event) {
+		e := NewEvent(ev)
+		e.Network = m.name
+		e.Echo = conn.isEcho(e)
+		if err := conn.negotiate(e); err != nil {
+			logrus.Errorf("irc: %s: %s", m.name, err)
+			m.setLastError(err)
+			go conn.Quit()
+		}
+		m.state.Handle(e)
+		switch ev.Code {
+		case "001":
+			// registered with the server, the connection is healthy.
 			m.mu.Lock()
-			defer m.mu.Unlock()
-			m.conn = nil
-		}()
-	} else {
+			m.attempts = 0
+			m.mu.Unlock()
+			m.joinChannels(conn)
+
+		case "KICK":
+			m.handleKick(conn, e)
+		}
+		m.emit(ev.Code, e.Map())
+	})
+	m.conn = conn
+	err = conn.Connect()
+	if err != nil {
 		m.conn = nil
+		m.lastErr = err
+		return err
 	}
-	return err
+	go conn.controlLoop(m.setLastError)
+	go conn.sendLoop()
+	go func() {
+		m.emit("CONNECT", m.eventData())
+		<-conn.done
+		var rejoin []string
+		if conn.lostErr != nil {
+			rejoin = m.state.Channels()
+		}
+		m.state.Reset()
+		m.emit("DISCONNECT", m.eventData())
+		m.mu.Lock()
+		m.lostChannels = rejoin
+		if m.conn == conn {
+			m.conn = nil
+		}
+		reconnect := conn.lostErr != nil && m.config.Reconnect && !m.disabled
+		m.mu.Unlock()
+		if reconnect {
+			m.reconnect()
+		}
+	}()
+	return nil
 }
 
-func (m *Manager) Disconnect() error {
+func (m *Manager) setLastError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastErr = err
+}
+
+// LastError returns the most recent connection error, or nil if there is none.
+func (m *Manager) LastError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastErr
+}
+
+// Connected returns true if the Manager has an active connection.
+func (m *Manager) Connected() bool {
 	m.mu.RLock()
 	conn := m.conn
 	m.mu.RUnlock()
+	return conn != nil && conn.Connected()
+}
+
+// shutdown disables the Manager, stopping any pending auto-connect or
+// reconnect, and closes the connection, if any.
+func (m *Manager) shutdown() error {
+	m.mu.Lock()
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+	m.disabled = true
+	m.cancelReconnect()
+	conn := m.conn
+	m.mu.Unlock()
 	if conn == nil {
+		return nil
+	}
+	return conn.Quit()
+}
+
+// Disconnect closes the connection, cancelling any pending reconnect.
+func (m *Manager) Disconnect() error {
+	m.mu.Lock()
+	conn := m.conn
+	cancelled := m.cancelReconnect()
+	m.mu.Unlock()
+	if conn == nil {
+		if cancelled {
+			return nil
+		}
 		return ErrNotConnected
 	}
 	return conn.Quit()
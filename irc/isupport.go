@@ -0,0 +1,155 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ISupport contains the features a server advertises with RPL_ISUPPORT
+// (005). Until a feature is advertised, the defaults described by RFC 1459
+// are assumed.
+type ISupport struct {
+	// Tokens contains the value of each advertised token by name. Tokens
+	// advertised without a value have an empty value.
+	Tokens map[string]string
+	// CaseMapping is used to compare nicks and channel names, such as
+	// "rfc1459" or "ascii".
+	CaseMapping string
+	// PrefixModes and Prefixes contain the channel membership modes and
+	// their prefixes, most significant first, such as "ov" and "@+".
+	PrefixModes string
+	Prefixes    string
+	// ChanTypes contains the characters channel names begin with.
+	ChanTypes string
+	// ChanModes contains the channel modes in the four groups described by
+	// the CHANMODES token: lists, modes that always take a parameter, modes
+	// that take a parameter only when set, and modes without a parameter.
+	ChanModes [4]string
+}
+
+// NewISupport returns an ISupport containing the defaults.
+func NewISupport() *ISupport {
+	i := &ISupport{Tokens: make(map[string]string)}
+	for _, name := range []string{"CASEMAPPING", "PREFIX", "CHANTYPES", "CHANMODES"} {
+		i.reset(name)
+	}
+	return i
+}
+
+// reset restores the default for the named token.
+func (i *ISupport) reset(name string) {
+	switch name {
+	case "CASEMAPPING":
+		i.CaseMapping = "rfc1459"
+	case "PREFIX":
+		i.PrefixModes, i.Prefixes = "ov", "@+"
+	case "CHANTYPES":
+		i.ChanTypes = "#&"
+	case "CHANMODES":
+		i.ChanModes = [4]string{"beI", "k", "l", "imnpst"}
+	}
+}
+
+// copy returns a copy of the ISupport.
+func (i *ISupport) copy() *ISupport {
+	res := *i
+	res.Tokens = make(map[string]string, len(i.Tokens))
+	for k, v := range i.Tokens {
+		res.Tokens[k] = v
+	}
+	return &res
+}
+
+// Parse applies the tokens of an RPL_ISUPPORT reply, such as
+// "PREFIX=(ov)@+" or "-EXCEPTS", not including the bot's nick or the
+// trailing text. A token prefixed with "-" is no longer supported, and its
+// default is restored.
+func (i *ISupport) Parse(tokens []string) {
+	for _, t := range tokens {
+		if strings.HasPrefix(t, "-") {
+			delete(i.Tokens, t[1:])
+			i.reset(t[1:])
+			continue
+		}
+		name, value := t, ""
+		if j := strings.IndexByte(t, '='); j >= 0 {
+			name, value = t[:j], unescapeISupport(t[j+1:])
+		}
+		if name == "" {
+			continue
+		}
+		i.Tokens[name] = value
+		switch name {
+		case "CASEMAPPING":
+			if value != "" {
+				i.CaseMapping = strings.ToLower(value)
+			}
+		case "PREFIX":
+			if value == "" {
+				i.PrefixModes, i.Prefixes = "", ""
+			} else if j := strings.IndexByte(value, ')'); strings.HasPrefix(value, "(") && j > 0 && len(value)-j-1 == j-1 {
+				i.PrefixModes, i.Prefixes = value[1:j], value[j+1:]
+			}
+		case "CHANTYPES":
+			i.ChanTypes = value
+		case "CHANMODES":
+			groups := strings.Split(value, ",")
+			for j := 0; j < len(i.ChanModes); j++ {
+				if j < len(groups) {
+					i.ChanModes[j] = groups[j]
+				} else {
+					i.ChanModes[j] = ""
+				}
+			}
+		}
+	}
+}
+
+// unescapeISupport replaces the \xHH escapes in a token's value.
+func unescapeISupport(v string) string {
+	if !strings.Contains(v, `\x`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+3 < len(v) && v[i+1] == 'x' {
+			if n, err := strconv.ParseUint(v[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+// Fold returns name in lowercase according to the case mapping, so that
+// equivalent nicks and channel names compare equal.
+func (i *ISupport) Fold(name string) string {
+	var upper byte
+	switch i.CaseMapping {
+	case "ascii":
+		upper = 'Z'
+	case "strict-rfc1459":
+		upper = ']'
+	case "rfc1459":
+		upper = '^'
+	default:
+		return strings.ToLower(name)
+	}
+	b := []byte(name)
+	for j, c := range b {
+		// rfc1459 treats [\]^ as the uppercase of {|}~, which directly
+		// follow the ASCII lowercase letters.
+		if c >= 'A' && c <= upper {
+			b[j] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// IsChannel returns true if name is a channel name.
+func (i *ISupport) IsChannel(name string) bool {
+	return name != "" && strings.IndexByte(i.ChanTypes, name[0]) >= 0
+}
@@ -0,0 +1,69 @@
+package irc
+
+import "testing"
+
+func TestISupport_Parse(t *testing.T) {
+	i := NewISupport()
+	if i.CaseMapping != "rfc1459" || i.Prefixes != "@+" || i.ChanTypes != "#&" {
+		t.Fatalf("unexpected defaults: %+v", i)
+	}
+	i.Parse([]string{"CASEMAPPING=ascii", "PREFIX=(qaohv)~&@%+", "CHANTYPES=#", "CHANMODES=beI,k,l,imnpst", "NETWORK=Example\\x20Net", "EXCEPTS", "=bogus"})
+	if i.CaseMapping != "ascii" {
+		t.Errorf("expected ascii case mapping, got %q", i.CaseMapping)
+	}
+	if i.PrefixModes != "qaohv" || i.Prefixes != "~&@%+" {
+		t.Errorf("unexpected prefixes: %q %q", i.PrefixModes, i.Prefixes)
+	}
+	if i.ChanTypes != "#" {
+		t.Errorf("unexpected channel types: %q", i.ChanTypes)
+	}
+	if v, ok := i.Tokens["NETWORK"]; !ok || v != "Example Net" {
+		t.Errorf("expected unescaped NETWORK token, got %q", v)
+	}
+	if v, ok := i.Tokens["EXCEPTS"]; !ok || v != "" {
+		t.Errorf("expected EXCEPTS token without a value, got %q, %v", v, ok)
+	}
+	if _, ok := i.Tokens[""]; ok {
+		t.Errorf("expected token without a name to be ignored")
+	}
+
+	i.Parse([]string{"PREFIX=(ov)@", "-CASEMAPPING", "-EXCEPTS"})
+	if i.PrefixModes != "qaohv" {
+		t.Errorf("expected mismatched PREFIX to be ignored, got %q", i.PrefixModes)
+	}
+	if i.CaseMapping != "rfc1459" {
+		t.Errorf("expected negated CASEMAPPING to restore the default, got %q", i.CaseMapping)
+	}
+	if _, ok := i.Tokens["EXCEPTS"]; ok {
+		t.Errorf("expected negated EXCEPTS to be removed")
+	}
+}
+
+func TestISupport_Fold(t *testing.T) {
+	tests := []struct {
+		mapping, name, expected string
+	}{
+		{"rfc1459", "Squishy[]\\^", "squishy{}|~"},
+		{"strict-rfc1459", "Squishy[]\\^", "squishy{}|^"},
+		{"ascii", "Squishy[]\\^", "squishy[]\\^"},
+		{"rfc7613", "SQUISHYÉ", "squishyé"},
+	}
+	for _, test := range tests {
+		i := NewISupport()
+		i.Parse([]string{"CASEMAPPING=" + test.mapping})
+		if s := i.Fold(test.name); s != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.mapping, test.expected, s)
+		}
+	}
+}
+
+func TestISupport_IsChannel(t *testing.T) {
+	i := NewISupport()
+	if !i.IsChannel("#squircy") || !i.IsChannel("&local") || i.IsChannel("squishy") || i.IsChannel("") {
+		t.Errorf("unexpected result with default channel types")
+	}
+	i.Parse([]string{"CHANTYPES=!"})
+	if i.IsChannel("#squircy") || !i.IsChannel("!squircy") {
+		t.Errorf("unexpected result with CHANTYPES=!")
+	}
+}
@@ -0,0 +1,261 @@
+package irc
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	irc "github.com/thoj/go-ircevent"
+)
+
+// ErrMalformedMessage is returned when parsing a line that is not a valid
+// IRC message.
+var ErrMalformedMessage = errors.New("malformed message")
+
+// A Message is a single line of the IRC protocol, such as
+// "@time=... :nick!user@host PRIVMSG #channel :hello there".
+type Message struct {
+	// Tags contains the IRCv3 message tags, unescaped.
+	Tags map[string]string
+	// Source is the prefix of the message without the leading ":", usually
+	// nick!user@host or a server name. It is empty if the message has no
+	// prefix.
+	Source string
+	// Command is the command or numeric reply, such as "PRIVMSG" or "001".
+	Command string
+	// Params contains the parameters of the message. The last parameter is
+	// the trailing parameter, if the message has one.
+	Params []string
+}
+
+// NewMessage returns a Message with the given command and parameters.
+// Use Validate to check that it can be sent.
+func NewMessage(command string, params ...string) *Message {
+	return &Message{Command: command, Params: params}
+}
+
+// Validate returns an error if the message cannot be sent as a single line
+// of the IRC protocol. Only the last parameter may be empty, contain a
+// space, or begin with ":", and no part of the message may contain CR, LF,
+// or NUL.
+func (m *Message) Validate() error {
+	if !validCommand(m.Command) {
+		return errors.Wrapf(ErrMalformedMessage, "invalid command %q", m.Command)
+	}
+	if strings.IndexByte(m.Source, ' ') >= 0 {
+		return errors.Wrapf(ErrMalformedMessage, "invalid source %q", m.Source)
+	}
+	if strings.ContainsAny(m.Source, "\r\n\x00") {
+		return errors.Wrap(ErrMalformedMessage, "source contains CR, LF, or NUL")
+	}
+	for i, p := range m.Params {
+		if strings.ContainsAny(p, "\r\n\x00") {
+			return errors.Wrapf(ErrMalformedMessage, "param %d contains CR, LF, or NUL", i)
+		}
+		if i < len(m.Params)-1 && (p == "" || strings.IndexByte(p, ' ') >= 0 || p[0] == ':') {
+			return errors.Wrapf(ErrMalformedMessage, "param %d %q is not the last and is empty, contains a space, or begins with \":\"", i, p)
+		}
+	}
+	return nil
+}
+
+// validCommand returns true if command is not empty and contains only
+// letters and digits.
+func validCommand(command string) bool {
+	if command == "" {
+		return false
+	}
+	for i := 0; i < len(command); i++ {
+		if c := command[i]; !isDigit(c) && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMessage parses a single line received from or sent to an IRC
+// server. A trailing CR LF is ignored. Commands must contain only letters
+// and digits, and are returned in uppercase.
+func ParseMessage(line string) (*Message, error) {
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if strings.ContainsAny(line, "\r\n\x00") {
+		return nil, errors.Wrap(ErrMalformedMessage, "line contains CR, LF, or NUL")
+	}
+	m := &Message{}
+	// next returns the next space-separated word, skipping leading spaces.
+	next := func() string {
+		line = strings.TrimLeft(line, " ")
+		word := line
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			word, line = line[:i], line[i+1:]
+		} else {
+			line = ""
+		}
+		return word
+	}
+	if strings.HasPrefix(line, "@") {
+		m.Tags = parseTags(next()[1:])
+	}
+	if strings.HasPrefix(strings.TrimLeft(line, " "), ":") {
+		m.Source = next()[1:]
+	}
+	m.Command = asciiUpper(next())
+	if m.Command == "" {
+		return nil, errors.Wrap(ErrMalformedMessage, "missing command")
+	}
+	if !validCommand(m.Command) {
+		return nil, errors.Wrapf(ErrMalformedMessage, "invalid command %q", m.Command)
+	}
+	for {
+		line = strings.TrimLeft(line, " ")
+		if line == "" {
+			break
+		}
+		if line[0] == ':' {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		m.Params = append(m.Params, next())
+	}
+	return m, nil
+}
+
+// parseTags parses the tags part of a message, not including the "@". nil
+// is returned if there are no tags.
+func parseTags(raw string) map[string]string {
+	var res map[string]string
+	for _, t := range strings.Split(raw, ";") {
+		k, v := t, ""
+		if i := strings.IndexByte(t, '='); i >= 0 {
+			k, v = t[:i], unescapeTagValue(t[i+1:])
+		}
+		if k == "" {
+			continue
+		}
+		if res == nil {
+			res = make(map[string]string)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// unescapeTagValue reverses the escaping of a message tag value. An
+// unknown escape is replaced by the escaped character, and a trailing
+// backslash is removed.
+func unescapeTagValue(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		i++
+		if i == len(v) {
+			break
+		}
+		switch v[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
+// asciiUpper returns s with ASCII letters in uppercase.
+func asciiUpper(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'a' && c <= 'z' {
+			return strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' {
+					return r - 'a' + 'A'
+				}
+				return r
+			}, s)
+		}
+	}
+	return s
+}
+
+// Param returns the parameter at index i, or the empty string if there is
+// no such parameter.
+func (m *Message) Param(i int) string {
+	if i < 0 || i >= len(m.Params) {
+		return ""
+	}
+	return m.Params[i]
+}
+
+// Trailing returns the last parameter, or the empty string if the message
+// has no parameters.
+func (m *Message) Trailing() string {
+	return m.Param(len(m.Params) - 1)
+}
+
+// Nick returns the nick of the message's source, or the server name.
+func (m *Message) Nick() string {
+	nick, _, _ := ParseSource(m.Source)
+	return nick
+}
+
+// ParseSource splits a message's source, such as "nick!user@host", into its
+// parts. A server name is returned as the nick.
+func ParseSource(source string) (nick, user, host string) {
+	nick = source
+	if i := strings.IndexByte(nick, '@'); i >= 0 {
+		nick, host = nick[:i], nick[i+1:]
+	}
+	if i := strings.IndexByte(nick, '!'); i >= 0 {
+		nick, user = nick[:i], nick[i+1:]
+	}
+	return nick, user, host
+}
+
+// String returns the message as a line of the IRC protocol, without the
+// trailing CR LF. The last parameter is sent as a trailing parameter if it
+// is empty, contains a space, or begins with ":". The result is only a
+// valid line if Validate returns nil.
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(formatTags(m.Tags))
+	if m.Source != "" {
+		b.WriteString(":" + m.Source + " ")
+	}
+	b.WriteString(m.Command)
+	for i, p := range m.Params {
+		b.WriteByte(' ')
+		if i == len(m.Params)-1 && (p == "" || strings.IndexByte(p, ' ') >= 0 || p[0] == ':') {
+			b.WriteByte(':')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+// Event returns the Event describing the message, as if it was received
+// from the server.
+func (m *Message) Event() *Event {
+	ev := &irc.Event{
+		Code:      m.Command,
+		Raw:       m.String(),
+		Source:    m.Source,
+		Arguments: m.Params,
+		Tags:      m.Tags,
+	}
+	if i, j := strings.IndexByte(m.Source, '!'), strings.IndexByte(m.Source, '@'); i >= 0 && i < j {
+		// like go-ircevent, only a user's source is split into its parts.
+		ev.Nick, ev.User, ev.Host = ParseSource(m.Source)
+	}
+	return NewEvent(ev)
+}
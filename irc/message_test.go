@@ -0,0 +1,185 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *Message
+	}{
+		{"command", "PING", &Message{Command: "PING"}},
+		{"lowercase command", "ping :irc.example.com", &Message{Command: "PING", Params: []string{"irc.example.com"}}},
+		{"crlf", "PING x\r\n", &Message{Command: "PING", Params: []string{"x"}}},
+		{"numeric", ":irc.example.com 001 squishyjones :Welcome to the network", &Message{
+			Source:  "irc.example.com",
+			Command: "001",
+			Params:  []string{"squishyjones", "Welcome to the network"},
+		}},
+		{"privmsg", ":squishy!mrjones@localhost PRIVMSG #squircy :hello there", &Message{
+			Source:  "squishy!mrjones@localhost",
+			Command: "PRIVMSG",
+			Params:  []string{"#squircy", "hello there"},
+		}},
+		{"middle params", "MODE #squircy +ov squishy jones", &Message{
+			Command: "MODE",
+			Params:  []string{"#squircy", "+ov", "squishy", "jones"},
+		}},
+		{"empty trailing", "TOPIC #squircy :", &Message{Command: "TOPIC", Params: []string{"#squircy", ""}}},
+		{"colon in trailing", "PRIVMSG #squircy ::) hi :(", &Message{Command: "PRIVMSG", Params: []string{"#squircy", ":) hi :("}}},
+		{"extra spaces", ":squishy  PRIVMSG   #squircy   hi", &Message{
+			Source:  "squishy",
+			Command: "PRIVMSG",
+			Params:  []string{"#squircy", "hi"},
+		}},
+		{"trailing spaces kept", "PRIVMSG #squircy :hi  ", &Message{Command: "PRIVMSG", Params: []string{"#squircy", "hi  "}}},
+		{"tags", `@time=2021-01-01T00:00:00.000Z;+draft/reply=abc;flag;esc=a\sb\:c\\d\ne\r;bad=\x\ :squishy TAGMSG #squircy`, &Message{
+			Tags: map[string]string{
+				"time":         "2021-01-01T00:00:00.000Z",
+				"+draft/reply": "abc",
+				"flag":         "",
+				"esc":          "a b;c\\d\ne\r",
+				"bad":          "x",
+			},
+			Source:  "squishy",
+			Command: "TAGMSG",
+			Params:  []string{"#squircy"},
+		}},
+		{"empty tags", "@;= PING", &Message{Command: "PING"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseMessage(test.line)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(m, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, m)
+			}
+		})
+	}
+}
+
+func TestParseMessage_invalid(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"   ",
+		"@time=now",
+		":irc.example.com",
+		"PRIVMSG #squircy :a\rb",
+		"PRIVMSG #squircy :a\nb",
+		"PRIVMSG #squircy :a\x00b",
+		"PRIV-MSG #squircy",
+		" @PING",
+	} {
+		if _, err := ParseMessage(line); err == nil {
+			t.Errorf("expected error parsing %q", line)
+		}
+	}
+}
+
+func TestMessage_String(t *testing.T) {
+	tests := []struct {
+		m        *Message
+		expected string
+	}{
+		{NewMessage("PING"), "PING"},
+		{NewMessage("JOIN", "#squircy"), "JOIN #squircy"},
+		{NewMessage("PRIVMSG", "#squircy", "hello there"), "PRIVMSG #squircy :hello there"},
+		{NewMessage("TOPIC", "#squircy", ""), "TOPIC #squircy :"},
+		{NewMessage("PRIVMSG", "#squircy", ":)"), "PRIVMSG #squircy ::)"},
+		{&Message{
+			Tags:    map[string]string{"+draft/reply": "abc", "msgid": "a;b c"},
+			Source:  "squishy!mrjones@localhost",
+			Command: "PRIVMSG",
+			Params:  []string{"#squircy", "hi"},
+		}, `@+draft/reply=abc;msgid=a\:b\sc :squishy!mrjones@localhost PRIVMSG #squircy hi`},
+	}
+	for _, test := range tests {
+		if s := test.m.String(); s != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, s)
+		}
+	}
+}
+
+func TestMessage_Validate(t *testing.T) {
+	for _, m := range []*Message{
+		NewMessage("PING"),
+		NewMessage("TOPIC", "#squircy", ""),
+		NewMessage("PRIVMSG", "#squircy", ":) hello there"),
+		{Source: "squishy!mrjones@localhost", Command: "001", Params: []string{"squishyjones", "Welcome"}},
+	} {
+		if err := m.Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %s", m.String(), err)
+		}
+	}
+	for _, m := range []*Message{
+		NewMessage(""),
+		NewMessage("PRIV MSG", "#squircy"),
+		NewMessage("PRIVMSG", "", "hi"),
+		NewMessage("PRIVMSG", "#squircy #other", "hi"),
+		NewMessage("PRIVMSG", ":#squircy", "hi"),
+		NewMessage("PRIVMSG", "#squircy", "hi\r\nQUIT"),
+		{Source: "squishy jones", Command: "PRIVMSG", Params: []string{"#squircy", "hi"}},
+	} {
+		if err := m.Validate(); errors.Cause(err) != ErrMalformedMessage {
+			t.Errorf("%q: expected ErrMalformedMessage, got %v", m.String(), err)
+		}
+	}
+}
+
+func TestMessage_Params(t *testing.T) {
+	m := NewMessage("PRIVMSG", "#squircy", "hello there")
+	if p := m.Param(0); p != "#squircy" {
+		t.Errorf("expected first param to be #squircy, got %q", p)
+	}
+	if p := m.Param(2); p != "" {
+		t.Errorf("expected missing param to be empty, got %q", p)
+	}
+	if p := m.Trailing(); p != "hello there" {
+		t.Errorf("expected trailing param to be hello there, got %q", p)
+	}
+	if p := NewMessage("PING").Trailing(); p != "" {
+		t.Errorf("expected missing trailing param to be empty, got %q", p)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		source, nick, user, host string
+	}{
+		{"squishy!mrjones@localhost", "squishy", "mrjones", "localhost"},
+		{"squishy@localhost", "squishy", "", "localhost"},
+		{"squishy!mrjones", "squishy", "mrjones", ""},
+		{"irc.example.com", "irc.example.com", "", ""},
+		{"", "", "", ""},
+	}
+	for _, test := range tests {
+		nick, user, host := ParseSource(test.source)
+		if nick != test.nick || user != test.user || host != test.host {
+			t.Errorf("%q: expected %q, %q, %q, got %q, %q, %q", test.source, test.nick, test.user, test.host, nick, user, host)
+		}
+	}
+}
+
+func TestMessage_Event(t *testing.T) {
+	m, err := ParseMessage("@account=squishy;time=2021-01-01T00:00:00Z :squishy!mrjones@localhost PRIVMSG #squircy :hello there")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e := m.Event()
+	if e.Code != "PRIVMSG" || e.Nick != "squishy" || e.User != "mrjones" || e.Host != "localhost" {
+		t.Errorf("unexpected source or code: %#v", e)
+	}
+	if e.Target != "#squircy" || e.Message != "hello there" || e.Account != "squishy" || e.Time.Year() != 2021 {
+		t.Errorf("unexpected event: %#v", e)
+	}
+	if e := NewMessage("NOTICE", "*", "hi").Event(); e.Nick != "" {
+		t.Errorf("expected no nick for a message without a user source, got %q", e.Nick)
+	}
+}
@@ -0,0 +1,225 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"code.dopame.me/veonik/squircy3/config"
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+// DefaultNetworkName is the name of the network described by the [irc]
+// section when no [irc.networks.<name>] sections are configured.
+const DefaultNetworkName = "default"
+
+// ErrUnknownNetwork is returned when addressing a network that is not
+// configured.
+var ErrUnknownNetwork = errors.New("unknown network")
+
+// Networks contains a Manager for each configured network.
+type Networks struct {
+	managers map[string]*Manager
+	names    []string
+	def      string
+
+	mu sync.RWMutex
+}
+
+// NewNetworks returns Networks containing a Manager for each network
+// described by the given Config.
+//
+// If c has no Networks, the returned Networks contains a single Manager
+// named DefaultNetworkName that uses c. Otherwise, each [irc.networks.<name>]
+// section is decoded into a copy of c, inheriting the options it does not
+// set. Events from the default network are emitted both with and without
+// its name, as "irc.<name>.<CODE>" and "irc.<CODE>".
+func NewNetworks(c *Config, ev *event.Dispatcher) (*Networks, error) {
+	cs, err := networkConfigs(c)
+	if err != nil {
+		return nil, err
+	}
+	n := &Networks{managers: make(map[string]*Manager), def: c.DefaultNetwork}
+	for name := range cs {
+		n.names = append(n.names, name)
+	}
+	sort.Strings(n.names)
+	if n.def == "" {
+		n.def = n.names[0]
+	} else if _, ok := cs[n.def]; !ok {
+		return nil, errors.Errorf("%s: default_network %s is not configured", pluginName, n.def)
+	}
+	for _, name := range n.names {
+		n.managers[name] = newNetworkManager(name, cs[name], ev, name == n.def)
+	}
+	return n, nil
+}
+
+// validateNetworkName returns an error if name cannot be used to name a
+// network.
+func validateNetworkName(name string) error {
+	if name == "" {
+		return errors.New("network name must not be empty")
+	}
+	if name == DefaultNetworkName {
+		return errors.Errorf("network name %s is reserved", name)
+	}
+	if strings.ContainsAny(name, ".") || event.IsPattern(name) {
+		return errors.Errorf("network name %s must not contain '.' or pattern characters", name)
+	}
+	return nil
+}
+
+// networkConfigs returns the Config for each network described by c.
+func networkConfigs(c *Config) (map[string]*Config, error) {
+	if len(c.Networks) == 0 {
+		if err := validateNetworkConfig(c); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid configuration", pluginName)
+		}
+		return map[string]*Config{DefaultNetworkName: c}, nil
+	}
+	res := make(map[string]*Config)
+	for name, v := range c.Networks {
+		if err := validateNetworkName(name); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid network", pluginName)
+		}
+		raw, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("%s: expected network %s to be a section, got %T", pluginName, name, v)
+		}
+		nc := *c
+		nc.Networks = nil
+		nc.DefaultNetwork = ""
+		if _, err := config.Wrap(&nc, config.WithValuesFromMap(&raw),
+			config.WithFilteredOption("reconnect_backoff", filterDuration),
+			config.WithFilteredOption("reconnect_max_backoff", filterDuration),
+			config.WithFilteredOption("rejoin_delay", filterDuration)); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid configuration for network %s", pluginName, name)
+		}
+		if err := validateNetworkConfig(&nc); err != nil {
+			return nil, errors.Wrapf(err, "%s: invalid configuration for network %s", pluginName, name)
+		}
+		res[name] = &nc
+	}
+	return res, nil
+}
+
+// validateNetworkConfig returns an error if c is missing a required option
+// or has invalid TLS or SASL options.
+func validateNetworkConfig(c *Config) error {
+	for o, v := range map[string]string{"nick": c.Nick, "user": c.Username, "network": c.Network} {
+		if err := config.ValidateRequired(o, v); err != nil {
+			return err
+		}
+	}
+	return validateTLSConfig(c)
+}
+
+// Get returns the Manager for the named network. An empty name returns the
+// default network's Manager.
+func (n *Networks) Get(name string) (*Manager, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if name == "" {
+		name = n.def
+	}
+	m, ok := n.managers[name]
+	if !ok {
+		return nil, errors.Wrap(ErrUnknownNetwork, name)
+	}
+	return m, nil
+}
+
+// Default returns the Manager for the default network.
+func (n *Networks) Default() *Manager {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.managers[n.def]
+}
+
+// Names returns the name of each network, sorted.
+func (n *Networks) Names() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]string{}, n.names...)
+}
+
+// All returns the Manager for each network, sorted by name.
+func (n *Networks) All() []*Manager {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	res := make([]*Manager, len(n.names))
+	for i, name := range n.names {
+		res[i] = n.managers[name]
+	}
+	return res
+}
+
+// SetVersionString sets the CTCP VERSION reply on every network.
+func (n *Networks) SetVersionString(v string) {
+	for _, m := range n.All() {
+		m.SetVersionString(v)
+	}
+}
+
+// SetDisabled prevents or allows connecting to every network.
+func (n *Networks) SetDisabled(disabled bool) {
+	for _, m := range n.All() {
+		m.SetDisabled(disabled)
+	}
+}
+
+// replace replaces the networks in n with those in o, such as when the irc
+// plugin is reconfigured, so that users of n see the new networks.
+// The replaced networks are shut down: they are disconnected and will not
+// auto-connect or reconnect. If every replaced network was disabled, the
+// new networks are disabled too, and the CTCP VERSION reply is preserved.
+// o must not be used after calling replace.
+func (n *Networks) replace(o *Networks) {
+	old := n.All()
+	disabled := len(old) > 0
+	for _, m := range old {
+		disabled = disabled && m.Disabled()
+	}
+	var version string
+	if len(old) > 0 {
+		old[0].mu.RLock()
+		version = old[0].config.Version
+		old[0].mu.RUnlock()
+	}
+	for _, m := range o.All() {
+		if disabled {
+			m.SetDisabled(true)
+		}
+		if version != "" {
+			m.SetVersionString(version)
+		}
+	}
+	o.mu.RLock()
+	managers, names, def := o.managers, o.names, o.def
+	o.mu.RUnlock()
+	n.mu.Lock()
+	n.managers, n.names, n.def = managers, names, def
+	n.mu.Unlock()
+	for _, m := range old {
+		if err := m.shutdown(); err != nil && err != ErrNotConnected {
+			logrus.Warnf("irc: failed to disconnect from %s: %s", m.Name(), err)
+		}
+	}
+}
+
+// Disconnect closes the connection to every network. Networks that are not
+// connected are skipped. The first error encountered is returned after
+// attempting to disconnect from every network.
+func (n *Networks) Disconnect() error {
+	var res error
+	for _, m := range n.All() {
+		if err := m.Disconnect(); err != nil && err != ErrNotConnected && res == nil {
+			res = errors.Wrapf(err, "failed to disconnect from %s", m.Name())
+		}
+	}
+	return res
+}
@@ -0,0 +1,209 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+func TestNewNetworks_single(t *testing.T) {
+	c := &Config{Nick: "squishyjones", Username: "mrjones", Network: "irc.example.com:6697"}
+	n, err := NewNetworks(c, event.NewDispatcher())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if names := n.Names(); len(names) != 1 || names[0] != DefaultNetworkName {
+		t.Errorf("expected only the default network, got %v", names)
+	}
+	m := n.Default()
+	if m.Name() != DefaultNetworkName || m.config != c {
+		t.Errorf("expected default network to use the [irc] config")
+	}
+	if m.eventName("PRIVMSG") != "irc.PRIVMSG" {
+		t.Errorf("expected default network events to be unqualified, got %s", m.eventName("PRIVMSG"))
+	}
+	if _, err := NewNetworks(&Config{Nick: "squishyjones", Username: "mrjones"}, event.NewDispatcher()); err == nil {
+		t.Errorf("expected error without network")
+	}
+}
+
+func TestNewNetworks_multiple(t *testing.T) {
+	c := &Config{
+		Nick:             "squishyjones",
+		Username:         "mrjones",
+		TLS:              true,
+		ReconnectBackoff: time.Second,
+		Networks: map[string]interface{}{
+			"libera": map[string]interface{}{
+				"network": "irc.libera.chat:6697",
+			},
+			"efnet": map[string]interface{}{
+				"nick":              "squishy",
+				"network":           "irc.efnet.org:6667",
+				"tls":               false,
+				"reconnect_backoff": "10s",
+				// TOML decodes integers as int64.
+				"reconnect_max_attempts": int64(3),
+			},
+		},
+	}
+	n, err := NewNetworks(c, event.NewDispatcher())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if names := n.Names(); len(names) != 2 || names[0] != "efnet" || names[1] != "libera" {
+		t.Errorf("expected efnet and libera networks, got %v", names)
+	}
+	if n.Default().Name() != "efnet" {
+		t.Errorf("expected first network to be the default, got %s", n.Default().Name())
+	}
+	libera, err := n.Get("libera")
+	if err != nil {
+		t.Fatalf("unexpected error getting network: %s", err)
+	}
+	lc := libera.config
+	if lc.Network != "irc.libera.chat:6697" || lc.Nick != "squishyjones" || !lc.TLS || lc.ReconnectBackoff != time.Second {
+		t.Errorf("expected libera to inherit unset options, got %+v", lc)
+	}
+	if libera.eventName("PRIVMSG") != "irc.libera.PRIVMSG" {
+		t.Errorf("expected event name to include network, got %s", libera.eventName("PRIVMSG"))
+	}
+	efnet, _ := n.Get("efnet")
+	ec := efnet.config
+	if ec.Nick != "squishy" || ec.TLS || ec.ReconnectBackoff != 10*time.Second || ec.ReconnectMaxAttempts != 3 {
+		t.Errorf("expected efnet to override options, got %+v", ec)
+	}
+	if _, err := n.Get("oftc"); err == nil {
+		t.Errorf("expected error getting unknown network")
+	}
+
+	c.DefaultNetwork = "libera"
+	if n, err = NewNetworks(c, event.NewDispatcher()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n.Default().Name() != "libera" {
+		t.Errorf("expected libera to be the default, got %s", n.Default().Name())
+	}
+	c.DefaultNetwork = "oftc"
+	if _, err = NewNetworks(c, event.NewDispatcher()); err == nil {
+		t.Errorf("expected error with unknown default_network")
+	}
+}
+
+func TestNewNetworks_invalid(t *testing.T) {
+	for name, v := range map[string]interface{}{
+		DefaultNetworkName: map[string]interface{}{"network": "irc.example.com:6667"},
+		"irc.example":      map[string]interface{}{"network": "irc.example.com:6667"},
+		"ex*":              map[string]interface{}{"network": "irc.example.com:6667"},
+		"example":          "irc.example.com:6667",
+		"nonetwork":        map[string]interface{}{},
+	} {
+		c := &Config{Nick: "squishyjones", Username: "mrjones", Networks: map[string]interface{}{name: v}}
+		if _, err := NewNetworks(c, event.NewDispatcher()); err == nil {
+			t.Errorf("expected error for network %s", name)
+		}
+	}
+}
+
+func TestNetworks_Connect(t *testing.T) {
+	s1 := newFakeServer(t)
+	defer s1.Close()
+	s2 := newFakeServer(t)
+	defer s2.Close()
+	d := event.NewDispatcher()
+	go d.Loop()
+	defer d.Stop()
+	n, err := NewNetworks(&Config{
+		Nick:     "squishyjones",
+		Username: "mrjones",
+		Networks: map[string]interface{}{
+			"one": map[string]interface{}{"network": s1.Addr()},
+			"two": map[string]interface{}{"network": s2.Addr()},
+		},
+	}, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer n.Disconnect()
+	evs := recordEvents(d, "irc.*.PRIVMSG")
+	defEvs := recordEvents(d, "irc.PRIVMSG")
+	for _, m := range n.All() {
+		if err := m.Connect(); err != nil {
+			t.Fatalf("unexpected error connecting to %s: %s", m.Name(), err)
+		}
+	}
+	c1 := s1.Accept()
+	c1.Register()
+	c2 := s2.Accept()
+	c2.Register()
+	c2.Send(":someone!user@host PRIVMSG #squircy :hello from two")
+	ev := expectEvent(t, evs, "irc.two.PRIVMSG")
+	if ev.Data["Network"] != "two" || ev.Data["Message"] != "hello from two" {
+		t.Errorf("unexpected event data: %v", ev.Data)
+	}
+	c1.Send(":someone!user@host PRIVMSG #squircy :hello from one")
+	ev = expectEvent(t, evs, "irc.one.PRIVMSG")
+	if ev.Data["Network"] != "one" {
+		t.Errorf("expected event from network one, got %v", ev.Data["Network"])
+	}
+	// events from the default network are also emitted without its name.
+	ev = expectEvent(t, defEvs, "irc.PRIVMSG")
+	if ev.Data["Network"] != "one" || ev.Data["Message"] != "hello from one" {
+		t.Errorf("expected unqualified event from network one, got %v", ev.Data)
+	}
+	select {
+	case ev := <-defEvs:
+		t.Errorf("expected only the default network to emit unqualified events, got %v", ev.Data)
+	default:
+	}
+
+	two, _ := n.Get("two")
+	go two.Do(func(conn *Connection) error {
+		conn.Privmsg("#squircy", "only on two")
+		return nil
+	})
+	if l := c2.Expect("PRIVMSG"); l != "PRIVMSG #squircy :only on two" {
+		t.Errorf("unexpected line sent to network two: %s", l)
+	}
+}
+
+func TestNetworks_replace(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	d := event.NewDispatcher()
+	go d.Loop()
+	defer d.Stop()
+	n, err := NewNetworks(&Config{Nick: "squishyjones", Username: "mrjones", Network: s.Addr()}, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	old := n.Default()
+	if err := old.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	n.SetDisabled(true)
+	o, err := NewNetworks(&Config{
+		Nick:     "squishyjones",
+		Username: "mrjones",
+		Networks: map[string]interface{}{
+			"one": map[string]interface{}{"network": s.Addr()},
+		},
+	}, d)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	n.replace(o)
+	c.Expect("QUIT")
+	if names := n.Names(); len(names) != 1 || names[0] != "one" {
+		t.Errorf("expected networks to be replaced, got %v", names)
+	}
+	if !n.Default().Disabled() {
+		t.Errorf("expected new networks to stay disabled")
+	}
+	if err := old.Connect(); err != ErrDisabled {
+		t.Errorf("expected replaced network to be disabled, got %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package irc
 
 import (
+	"time"
+
 	"code.dopame.me/veonik/squircy3/config"
 	"code.dopame.me/veonik/squircy3/event"
 	"code.dopame.me/veonik/squircy3/plugin"
@@ -11,28 +13,42 @@ import (
 
 const pluginName = "irc"
 
-func pluginFromPlugins(m *plugin.Manager) (*ircPlugin, error) {
-	p, err := m.Lookup(pluginName)
-	if err != nil {
+// networksService is the name of the service that provides the irc
+// plugin's Networks.
+const networksService = pluginName + ".networks"
+
+// FromPlugins returns the irc plugin's Manager for the default network or an
+// error if it fails.
+func FromPlugins(m *plugin.Manager) (*Manager, error) {
+	var res *Manager
+	if err := m.Resolve(pluginName, &res); err != nil {
 		return nil, err
 	}
-	mp, ok := p.(*ircPlugin)
-	if !ok {
-		return nil, errors.Errorf("%s: received unexpected plugin type", pluginName)
-	}
-	return mp, nil
+	return res, nil
 }
 
-// FromPlugins returns the irc plugin's Manager or an error if it fails.
-func FromPlugins(m *plugin.Manager) (*Manager, error) {
-	mp, err := pluginFromPlugins(m)
-	if err != nil {
+// NetworksFromPlugins returns the irc plugin's Networks or an error if it
+// fails.
+func NetworksFromPlugins(m *plugin.Manager) (*Networks, error) {
+	var res *Networks
+	if err := m.Resolve(networksService, &res); err != nil {
 		return nil, err
 	}
-	if mp.manager == nil {
-		return nil, errors.Errorf("%s: plugin is not configured", pluginName)
-	}
-	return mp.manager, nil
+	return res, nil
+}
+
+// InjectNetworks calls fn with the irc plugin's Networks once the plugin is
+// initialized, and again each time it is reloaded. Use it to apply settings,
+// such as SetDisabled, that should survive the plugin being reloaded.
+func InjectNetworks(m *plugin.Manager, fn func(*Networks)) error {
+	var res *Networks
+	return m.Inject(networksService, &res, func(err error) {
+		if err != nil {
+			// the plugin was unloaded
+			return
+		}
+		fn(res)
+	})
 }
 
 // Initialize is a plugin.Initializer that initializes an irc plugin.
@@ -48,7 +64,7 @@ func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 type ircPlugin struct {
 	events *event.Dispatcher
 
-	manager *Manager
+	networks *Networks
 }
 
 func (p *ircPlugin) Configure(c config.Config) error {
@@ -56,20 +72,64 @@ func (p *ircPlugin) Configure(c config.Config) error {
 	if err != nil {
 		return err
 	}
-	p.manager = NewManager(co, p.events)
+	n, err := NewNetworks(co, p.events)
+	if err != nil {
+		return err
+	}
+	if p.networks == nil {
+		p.networks = n
+		return nil
+	}
+	// shut down the previous networks, keeping the same Networks so that
+	// other plugins see the new configuration.
+	p.networks.replace(n)
 	return nil
 }
 
 func (p *ircPlugin) HandleShutdown() {
-	if p.manager == nil {
+	if p.networks == nil {
 		logrus.Warnln("irc: shutting down uninitialized plugin")
 		return
 	}
-	if err := p.manager.Disconnect(); err != nil {
-		if err != ErrNotConnected {
-			logrus.Warnln("irc: failed to disconnect before shutting down:", err)
+	if err := p.networks.Disconnect(); err != nil {
+		logrus.Warnln("irc: failed to disconnect before shutting down:", err)
+	}
+}
+
+func (p *ircPlugin) Services() map[string]interface{} {
+	if p.networks == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		pluginName:      p.networks.Default(),
+		networksService: p.networks,
+	}
+}
+
+func (p *ircPlugin) Status() plugin.Status {
+	if p.networks == nil {
+		return plugin.Status{}
+	}
+	def := p.networks.Default()
+	networks := make(map[string]interface{})
+	for _, m := range p.networks.All() {
+		networks[m.Name()] = map[string]interface{}{
+			"network":      m.Network(),
+			"connected":    m.Connected(),
+			"queued":       m.SendQueueStats().Queued,
+			"capabilities": m.Capabilities(),
 		}
 	}
+	return plugin.Status{
+		Configured: true,
+		Connected:  def.Connected(),
+		LastError:  def.LastError(),
+		Details:    map[string]interface{}{"network": def.Network(), "networks": networks},
+	}
+}
+
+func (p *ircPlugin) HandleUnload() {
+	p.HandleShutdown()
 }
 
 func configFromGeneric(g config.Config) (c *Config, err error) {
@@ -82,7 +142,30 @@ func configFromGeneric(g config.Config) (c *Config, err error) {
 func (p *ircPlugin) Options() []config.SetupOption {
 	return []config.SetupOption{
 		config.WithInitValue(&Config{}),
-		config.WithRequiredOptions("nick", "user", "network")}
+		config.WithInheritedOption("root_path"),
+		config.WithFilteredOption("reconnect_backoff", filterDuration),
+		config.WithFilteredOption("reconnect_max_backoff", filterDuration),
+		config.WithFilteredOption("rejoin_delay", filterDuration)}
+}
+
+// filterDuration converts a duration string, such as "5s", to a
+// time.Duration.
+func filterDuration(name string, val config.Value) (config.Value, error) {
+	if v, ok := val.(time.Duration); ok {
+		return v, nil
+	}
+	vs, ok := val.(string)
+	if !ok {
+		return nil, errors.Errorf("%s: expected %s to be string but got %T", pluginName, name, val)
+	}
+	if vs == "" {
+		return time.Duration(0), nil
+	}
+	d, err := time.ParseDuration(vs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: failed to parse %s as duration", pluginName, name)
+	}
+	return d, nil
 }
 
 func (p *ircPlugin) Name() string {
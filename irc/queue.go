@@ -0,0 +1,388 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultSendBurst is used when SendBurst is not set.
+	DefaultSendBurst = 5
+	// DefaultSendRate is used when SendRate is not set.
+	DefaultSendRate = 1.0
+	// DefaultSendQueueSize is used when SendQueueSize is not set.
+	DefaultSendQueueSize = 1000
+)
+
+// quitFlushTimeout is the maximum amount of time to wait for queued lines
+// to be sent before sending QUIT.
+var quitFlushTimeout = 2 * time.Second
+
+// SendQueueStats describes the lines waiting in a connection's send queue.
+type SendQueueStats struct {
+	// Queued is the number of lines waiting to be sent.
+	Queued int
+	// Targets contains the number of lines waiting to be sent to each
+	// target. Lines without a target are counted with the empty string.
+	Targets map[string]int
+	// Sent is the number of lines sent through the queue.
+	Sent uint64
+	// Dropped is the number of lines dropped because the queue was full.
+	Dropped uint64
+}
+
+// sendQueue rate limits outgoing lines using a token bucket. Lines are
+// queued by target and each target with waiting lines takes a turn, so a
+// flood of messages to one target does not delay messages to others.
+type sendQueue struct {
+	burst float64
+	// rate is the number of tokens added each second, or zero if lines are
+	// not rate limited.
+	rate float64
+	max  int
+
+	tokens float64
+	last   time.Time
+
+	// lines contains the waiting lines for each target.
+	lines map[string][]string
+	// order contains each target with waiting lines, in the order they
+	// take their turn.
+	order   []string
+	queued  int
+	sent    uint64
+	dropped uint64
+	// sending is true while a popped line is being sent.
+	sending bool
+
+	// wake receives a value when a line is queued.
+	wake chan struct{}
+	now  func() time.Time
+
+	mu sync.Mutex
+}
+
+func newSendQueue(c Config) *sendQueue {
+	burst := c.SendBurst
+	if burst <= 0 {
+		burst = DefaultSendBurst
+	}
+	rate := c.SendRate
+	if rate == 0 {
+		rate = DefaultSendRate
+	} else if rate < 0 {
+		rate = 0
+	}
+	max := c.SendQueueSize
+	if max <= 0 {
+		max = DefaultSendQueueSize
+	}
+	return &sendQueue{
+		burst:  float64(burst),
+		rate:   rate,
+		max:    max,
+		tokens: float64(burst),
+		lines:  make(map[string][]string),
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+	}
+}
+
+// withoutTags returns the line without its message tags, if any.
+func withoutTags(line string) string {
+	if !strings.HasPrefix(line, "@") {
+		return line
+	}
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		return strings.TrimLeft(line[i:], " ")
+	}
+	return ""
+}
+
+// queueTarget returns the key a line is queued with. Messages are queued by
+// their target, all other lines are queued together.
+func queueTarget(line string) string {
+	fs := strings.SplitN(withoutTags(line), " ", 3)
+	if len(fs) < 2 {
+		return ""
+	}
+	switch strings.ToUpper(fs[0]) {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		return strings.ToLower(fs[1])
+	}
+	return ""
+}
+
+// bypassesQueue returns true if the line should be sent immediately.
+//
+// go-ircevent answers PING and sends QUIT itself, bypassing the Connection
+// entirely, so this only applies to such lines sent by scripts and plugins.
+func bypassesQueue(line string) bool {
+	cmd := withoutTags(line)
+	if i := strings.IndexByte(cmd, ' '); i >= 0 {
+		cmd = cmd[:i]
+	}
+	switch strings.ToUpper(cmd) {
+	case "PONG", "QUIT", "CAP", "AUTHENTICATE":
+		return true
+	}
+	return false
+}
+
+// push adds the line to the end of its target's queue. It returns false if
+// the queue is full.
+func (q *sendQueue) push(line string) bool {
+	target := queueTarget(line)
+	q.mu.Lock()
+	if q.queued >= q.max {
+		q.dropped++
+		q.mu.Unlock()
+		return false
+	}
+	if len(q.lines[target]) == 0 {
+		q.order = append(q.order, target)
+	}
+	q.lines[target] = append(q.lines[target], line)
+	q.queued++
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop removes the next line, giving the next target its turn.
+func (q *sendQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.order) == 0 {
+		return "", false
+	}
+	target := q.order[0]
+	q.order = q.order[1:]
+	lines := q.lines[target]
+	line := lines[0]
+	if len(lines) > 1 {
+		q.lines[target] = lines[1:]
+		q.order = append(q.order, target)
+	} else {
+		delete(q.lines, target)
+	}
+	q.queued--
+	q.sent++
+	q.sending = true
+	return line, true
+}
+
+// idle returns true if there are no lines waiting or being sent.
+func (q *sendQueue) idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued == 0 && !q.sending
+}
+
+// flush waits until there are no lines waiting or being sent, the timeout
+// elapses, or stop is closed. It returns true if the queue is idle.
+func (q *sendQueue) flush(timeout time.Duration, stop <-chan struct{}) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for !q.idle() {
+		select {
+		case <-tick.C:
+		case <-deadline.C:
+			return false
+		case <-stop:
+			return false
+		}
+	}
+	return true
+}
+
+// reserve takes a token from the bucket, returning how long to wait before
+// the token is available.
+func (q *sendQueue) reserve() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.rate == 0 {
+		return 0
+	}
+	now := q.now()
+	if !q.last.IsZero() {
+		q.tokens += now.Sub(q.last).Seconds() * q.rate
+		if q.tokens > q.burst {
+			q.tokens = q.burst
+		}
+	}
+	q.last = now
+	q.tokens--
+	if q.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-q.tokens / q.rate * float64(time.Second))
+}
+
+func (q *sendQueue) empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued == 0
+}
+
+// Stats returns the current state of the queue.
+func (q *sendQueue) Stats() SendQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := SendQueueStats{
+		Queued:  q.queued,
+		Targets: make(map[string]int, len(q.lines)),
+		Sent:    q.sent,
+		Dropped: q.dropped,
+	}
+	for t, l := range q.lines {
+		res.Targets[t] = len(l)
+	}
+	return res
+}
+
+// run sends queued lines as tokens become available until stop is closed.
+func (q *sendQueue) run(send func(string), stop <-chan struct{}) {
+	for {
+		if q.empty() {
+			select {
+			case <-q.wake:
+				continue
+			case <-stop:
+				return
+			}
+		}
+		if d := q.reserve(); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-t.C:
+			case <-stop:
+				t.Stop()
+				return
+			}
+		}
+		if line, ok := q.pop(); ok {
+			send(line)
+			q.mu.Lock()
+			q.sending = false
+			q.mu.Unlock()
+		}
+	}
+}
+
+// send queues the line to be sent, or sends it immediately if it bypasses
+// the queue.
+func (conn *Connection) send(line string) {
+	if bypassesQueue(line) {
+		conn.Connection.SendRaw(line)
+		return
+	}
+	if !conn.queue.push(line) {
+		logrus.Warnf("irc: send queue is full, dropping line: %s", line)
+	}
+}
+
+// sendLoop sends queued lines until the connection is closed.
+func (conn *Connection) sendLoop() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-conn.quitting:
+		case <-conn.done:
+		}
+		close(stop)
+	}()
+	conn.queue.run(conn.Connection.SendRaw, stop)
+}
+
+// The following methods replace those of the underlying irc.Connection so
+// that outgoing lines are rate limited, and messages are split to fit.
+
+func (conn *Connection) SendRaw(message string) {
+	conn.sendChecked(message)
+}
+
+func (conn *Connection) SendRawf(format string, a ...interface{}) {
+	conn.sendChecked(fmt.Sprintf(format, a...))
+}
+
+func (conn *Connection) Privmsg(target, message string) {
+	conn.sendMessage(nil, "PRIVMSG", target, "", "", message)
+}
+
+func (conn *Connection) Privmsgf(target, format string, a ...interface{}) {
+	conn.Privmsg(target, fmt.Sprintf(format, a...))
+}
+
+func (conn *Connection) Notice(target, message string) {
+	conn.sendMessage(nil, "NOTICE", target, "", "", message)
+}
+
+func (conn *Connection) Noticef(target, format string, a ...interface{}) {
+	conn.Notice(target, fmt.Sprintf(format, a...))
+}
+
+func (conn *Connection) Action(target, message string) {
+	conn.sendMessage(nil, "PRIVMSG", target, "\001ACTION ", "\001", message)
+}
+
+func (conn *Connection) Actionf(target, format string, a ...interface{}) {
+	conn.Action(target, fmt.Sprintf(format, a...))
+}
+
+func (conn *Connection) Join(channel string) {
+	conn.sendChecked("JOIN " + channel)
+}
+
+func (conn *Connection) Part(channel string) {
+	conn.sendChecked("PART " + channel)
+}
+
+func (conn *Connection) Kick(user, channel, msg string) {
+	if msg == "" {
+		conn.sendChecked(fmt.Sprintf("KICK %s %s", channel, user))
+		return
+	}
+	conn.sendChecked(fmt.Sprintf("KICK %s %s :%s", channel, user, msg))
+}
+
+func (conn *Connection) MultiKick(users []string, channel string, msg string) {
+	conn.Kick(strings.Join(users, ","), channel, msg)
+}
+
+func (conn *Connection) Who(nick string) {
+	conn.sendChecked("WHO " + nick)
+}
+
+func (conn *Connection) Whois(nick string) {
+	conn.sendChecked("WHOIS " + nick)
+}
+
+func (conn *Connection) Mode(target string, modestring ...string) {
+	if len(modestring) > 0 {
+		conn.sendChecked(fmt.Sprintf("MODE %s %s", target, strings.Join(modestring, " ")))
+		return
+	}
+	conn.sendChecked("MODE " + target)
+}
+
+// SendQueueStats returns the state of the current connection's send queue.
+// If the Manager is not connected, the result is empty.
+func (m *Manager) SendQueueStats() SendQueueStats {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+	if conn == nil {
+		return SendQueueStats{Targets: map[string]int{}}
+	}
+	return conn.queue.Stats()
+}
@@ -0,0 +1,247 @@
+package irc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func popAll(q *sendQueue) []string {
+	var res []string
+	for {
+		line, ok := q.pop()
+		if !ok {
+			return res
+		}
+		res = append(res, line)
+	}
+}
+
+func TestSendQueue_fairness(t *testing.T) {
+	q := newSendQueue(Config{})
+	for _, l := range []string{
+		"PRIVMSG #a :1",
+		"PRIVMSG #a :2",
+		"PRIVMSG #a :3",
+		"PRIVMSG #b :1",
+		"JOIN #c",
+		"NOTICE #B :2",
+		"PRIVMSG #a :4",
+	} {
+		q.push(l)
+	}
+	st := q.Stats()
+	if expected := map[string]int{"#a": 4, "#b": 2, "": 1}; st.Queued != 7 || !reflect.DeepEqual(st.Targets, expected) {
+		t.Errorf("expected 7 lines queued by target %v, got %d %v", expected, st.Queued, st.Targets)
+	}
+	expected := []string{
+		"PRIVMSG #a :1",
+		"PRIVMSG #b :1",
+		"JOIN #c",
+		"PRIVMSG #a :2",
+		"NOTICE #B :2",
+		"PRIVMSG #a :3",
+		"PRIVMSG #a :4",
+	}
+	if res := popAll(q); !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected lines in order:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(res, "\n"))
+	}
+	if st := q.Stats(); st.Queued != 0 || st.Sent != 7 || len(st.Targets) != 0 {
+		t.Errorf("expected empty queue after sending 7 lines, got %+v", st)
+	}
+}
+
+func TestSendQueue_full(t *testing.T) {
+	q := newSendQueue(Config{SendQueueSize: 2})
+	for i := 0; i < 3; i++ {
+		ok := q.push(fmt.Sprintf("PRIVMSG #a :%d", i))
+		if ok != (i < 2) {
+			t.Errorf("line %d: expected push to return %v", i, i < 2)
+		}
+	}
+	if st := q.Stats(); st.Queued != 2 || st.Dropped != 1 {
+		t.Errorf("expected 2 queued and 1 dropped, got %+v", st)
+	}
+}
+
+func TestSendQueue_reserve(t *testing.T) {
+	q := newSendQueue(Config{SendBurst: 2, SendRate: 2})
+	now := time.Unix(0, 0)
+	q.now = func() time.Time { return now }
+	for i, expected := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		if d := q.reserve(); d != expected {
+			t.Errorf("reservation %d: expected to wait %s, got %s", i, expected, d)
+		}
+	}
+	// after waiting for the reserved tokens and then some, the bucket is
+	// refilled up to the burst.
+	now = now.Add(10 * time.Second)
+	for i, expected := range []time.Duration{0, 0, 500 * time.Millisecond} {
+		if d := q.reserve(); d != expected {
+			t.Errorf("reservation %d after refill: expected to wait %s, got %s", i, expected, d)
+		}
+	}
+
+	q = newSendQueue(Config{SendRate: -1})
+	for i := 0; i < 100; i++ {
+		if d := q.reserve(); d != 0 {
+			t.Fatalf("expected unlimited queue never to wait, got %s", d)
+		}
+	}
+}
+
+func TestSendQueue_run(t *testing.T) {
+	const burst, rate, perTarget, targets = 3, 50, 5, 3
+	q := newSendQueue(Config{SendBurst: burst, SendRate: rate})
+	var mu sync.Mutex
+	var sent []string
+	var times []time.Time
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	var wg sync.WaitGroup
+	for i := 0; i < targets; i++ {
+		wg.Add(1)
+		go func(target int) {
+			defer wg.Done()
+			for j := 0; j < perTarget; j++ {
+				q.push(fmt.Sprintf("PRIVMSG #%d :%d", target, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	// the queue is filled before sending starts so that the bucket cannot
+	// refill while it is briefly empty.
+	start := time.Now()
+	go q.run(func(line string) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, line)
+		times = append(times, time.Now())
+		if len(sent) == perTarget*targets {
+			close(done)
+		}
+	}, stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for lines to be sent")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	// lines to each target are sent in order.
+	next := make(map[string]int)
+	for _, l := range sent {
+		var target string
+		var n int
+		if _, err := fmt.Sscanf(l, "PRIVMSG %s :%d", &target, &n); err != nil {
+			t.Fatalf("unexpected line %s: %s", l, err)
+		}
+		if n != next[target] {
+			t.Errorf("expected line %d to %s, got %d", next[target], target, n)
+		}
+		next[target] = n + 1
+	}
+	// after the burst, lines are sent no faster than the rate: line i needs
+	// i+1 tokens, and only burst are available at the start.
+	for i := burst; i < len(times); i++ {
+		minimum := time.Duration(float64(i+1-burst) / rate * float64(time.Second))
+		if elapsed := times[i].Sub(start); elapsed < minimum-5*time.Millisecond {
+			t.Errorf("expected line %d to be sent at least %s after starting, took %s", i, minimum, elapsed)
+		}
+	}
+}
+
+func TestConnection_bypassesQueue(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, _, cleanup := newTestManager(&Config{
+		Nick:      "squishyjones",
+		Username:  "mrjones",
+		Network:   s.Addr(),
+		SendBurst: 1,
+		SendRate:  0.01,
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	err := m.Do(func(conn *Connection) error {
+		for i := 0; i < 3; i++ {
+			conn.Privmsg("#squircy", fmt.Sprintf("line %d", i))
+		}
+		conn.SendRaw("PONG :irc.example.com")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the PONG may be sent before or after the first line, but it is not
+	// queued behind the other lines.
+	lines := map[string]bool{c.Expect("P"): true, c.Expect("P"): true}
+	if expected := map[string]bool{"PRIVMSG #squircy :line 0": true, "PONG :irc.example.com": true}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected first line and PONG to be sent immediately, got %v", lines)
+	}
+	if st := m.SendQueueStats(); st.Queued != 2 || st.Targets["#squircy"] != 2 {
+		t.Errorf("expected 2 lines to be queued, got %+v", st)
+	}
+}
+
+func TestConnection_QuitFlushesQueue(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, _, cleanup := newTestManager(&Config{
+		Nick:      "squishyjones",
+		Username:  "mrjones",
+		Network:   s.Addr(),
+		SendBurst: 1,
+		SendRate:  20,
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	err := m.Do(func(conn *Connection) error {
+		for i := 0; i < 3; i++ {
+			conn.Privmsg("#squircy", fmt.Sprintf("line %d", i))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go m.Disconnect()
+	var lines []string
+	for len(lines) < 4 {
+		if l := c.Expect(""); strings.HasPrefix(l, "PRIVMSG") || strings.HasPrefix(l, "QUIT") {
+			lines = append(lines, l)
+		}
+	}
+	expected := []string{"PRIVMSG #squircy :line 0", "PRIVMSG #squircy :line 1", "PRIVMSG #squircy :line 2", "QUIT :farewell"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected queued lines to be sent before QUIT, got %v", lines)
+	}
+}
+
+func TestSendQueue_flush(t *testing.T) {
+	q := newSendQueue(Config{})
+	if !q.flush(time.Millisecond, nil) {
+		t.Errorf("expected empty queue to be idle")
+	}
+	q.push("PRIVMSG #squircy :hello")
+	if q.flush(20*time.Millisecond, nil) {
+		t.Errorf("expected flush to time out while lines are waiting")
+	}
+	stop := make(chan struct{})
+	close(stop)
+	if q.flush(time.Second, stop) {
+		t.Errorf("expected flush to stop when stop is closed")
+	}
+}
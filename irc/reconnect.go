@@ -0,0 +1,132 @@
+package irc
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultReconnectBackoff is used when ReconnectBackoff is not set.
+	DefaultReconnectBackoff = 5 * time.Second
+	// DefaultReconnectMaxBackoff is used when ReconnectMaxBackoff is not set.
+	DefaultReconnectMaxBackoff = 5 * time.Minute
+)
+
+// reconnectDelay returns the amount of time to wait before the given
+// reconnect attempt, starting at 1. rnd returns a random number in [0, 1).
+func reconnectDelay(c Config, attempt int, rnd func() float64) time.Duration {
+	initial := c.ReconnectBackoff
+	if initial <= 0 {
+		initial = DefaultReconnectBackoff
+	}
+	max := c.ReconnectMaxBackoff
+	if max <= 0 {
+		max = DefaultReconnectMaxBackoff
+	}
+	if max < initial {
+		max = initial
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if j := c.ReconnectJitter; j > 0 {
+		if j > 1 {
+			j = 1
+		}
+		// vary by up to j in either direction.
+		d += time.Duration(float64(d) * j * (rnd()*2 - 1))
+	}
+	return d
+}
+
+// cancelReconnect stops a pending reconnect, returning true if there was
+// one. The Manager must be locked when calling this method.
+func (m *Manager) cancelReconnect() bool {
+	m.attempts = 0
+	if m.reconnecting == nil {
+		return false
+	}
+	close(m.reconnecting)
+	m.reconnecting = nil
+	return true
+}
+
+// reconnect attempts to connect until it succeeds, the maximum number of
+// attempts is reached, or it is cancelled.
+//
+// An "irc.RECONNECTING" event is emitted before waiting for each attempt.
+// If the maximum number of attempts is reached, an "irc.RECONNECT_FAILED"
+// event is emitted. Event names include the network name, as described in
+// NewNetworkManager.
+func (m *Manager) reconnect() {
+	m.mu.Lock()
+	if m.reconnecting != nil {
+		// already reconnecting
+		m.mu.Unlock()
+		return
+	}
+	cancel := make(chan struct{})
+	m.reconnecting = cancel
+	c := *m.config
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.reconnecting == cancel {
+			m.reconnecting = nil
+		}
+	}()
+	for {
+		m.mu.Lock()
+		m.attempts++
+		attempt := m.attempts
+		m.mu.Unlock()
+		if c.ReconnectMaxAttempts > 0 && attempt > c.ReconnectMaxAttempts {
+			logrus.Warnf("irc: giving up reconnecting to %s after %d attempts", m.name, attempt-1)
+			m.emit("RECONNECT_FAILED", map[string]interface{}{
+				"Network":  m.name,
+				"Attempts": attempt - 1,
+			})
+			return
+		}
+		delay := reconnectDelay(c, attempt, rand.Float64)
+		logrus.Infof("irc: reconnecting to %s in %s (attempt %d)", m.name, delay, attempt)
+		m.emit("RECONNECTING", map[string]interface{}{
+			"Network":     m.name,
+			"Attempt":     attempt,
+			"MaxAttempts": c.ReconnectMaxAttempts,
+			"Delay":       delay.Seconds(),
+		})
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-cancel:
+			t.Stop()
+			return
+		}
+		m.mu.Lock()
+		select {
+		case <-cancel:
+			// cancelled while waiting for the lock.
+			m.mu.Unlock()
+			return
+		default:
+		}
+		err := m.connect()
+		m.mu.Unlock()
+		switch err {
+		case nil:
+			// if the new connection is lost, reconnecting begins again.
+			return
+		case errAlreadyConnected, ErrDisabled:
+			return
+		}
+		logrus.Warnf("irc: reconnect attempt %d to %s failed: %s", attempt, m.name, err)
+	}
+}
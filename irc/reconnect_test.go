@@ -0,0 +1,165 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	c := Config{ReconnectBackoff: time.Second, ReconnectMaxBackoff: 10 * time.Second}
+	none := func() float64 { return 0.5 }
+	for attempt, expected := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if d := reconnectDelay(c, attempt, none); d != expected {
+			t.Errorf("attempt %d: expected delay of %s, got %s", attempt, expected, d)
+		}
+	}
+	c.ReconnectJitter = 0.5
+	if d := reconnectDelay(c, 1, func() float64 { return 0 }); d != 500*time.Millisecond {
+		t.Errorf("expected minimum jittered delay of 500ms, got %s", d)
+	}
+	if d := reconnectDelay(c, 1, func() float64 { return 0.999999 }); d < 1499*time.Millisecond {
+		t.Errorf("expected maximum jittered delay of almost 1.5s, got %s", d)
+	}
+	if d := reconnectDelay(Config{}, 1, none); d != DefaultReconnectBackoff {
+		t.Errorf("expected default delay of %s, got %s", DefaultReconnectBackoff, d)
+	}
+}
+
+// recordEvents binds a handler that sends the name of each matching event
+// to the returned channel.
+func recordEvents(d *event.Dispatcher, pattern string) chan *event.Event {
+	res := make(chan *event.Event, 100)
+	d.Bind(pattern, event.HandlerFunc(func(ev *event.Event) {
+		res <- ev
+	}))
+	return res
+}
+
+func expectEvent(t *testing.T, evs chan *event.Event, name string) *event.Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-evs:
+			if ev.Name == name {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event", name)
+			return nil
+		}
+	}
+}
+
+// newTestManager returns a Manager and running Dispatcher, and a function
+// that cleans them up.
+func newTestManager(c *Config) (*Manager, *event.Dispatcher, func()) {
+	d := event.NewDispatcher()
+	go d.Loop()
+	m := NewManager(c, d)
+	return m, d, func() {
+		_ = m.Disconnect()
+		d.Stop()
+	}
+}
+
+func TestManager_Reconnect(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:                "squishyjones",
+		Username:            "mrjones",
+		Network:             s.Addr(),
+		Reconnect:           true,
+		ReconnectBackoff:    10 * time.Millisecond,
+		ReconnectMaxBackoff: 50 * time.Millisecond,
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.*")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	expectEvent(t, evs, "irc.001")
+	c.Kill()
+	expectEvent(t, evs, "irc.DISCONNECT")
+	ev := expectEvent(t, evs, "irc.RECONNECTING")
+	if ev.Data["Attempt"] != 1 {
+		t.Errorf("expected first reconnect attempt, got %v", ev.Data["Attempt"])
+	}
+	c = s.Accept()
+	c.Register()
+	// CONNECT is emitted concurrently with the messages received, so it
+	// may follow 001.
+	seen := map[string]bool{}
+	for !seen["irc.CONNECT"] || !seen["irc.001"] {
+		select {
+		case ev := <-evs:
+			seen[ev.Name] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for irc.CONNECT and irc.001 events, got %v", seen)
+		}
+	}
+	if !m.Connected() {
+		t.Errorf("expected manager to be connected after reconnecting")
+	}
+
+	// disconnecting intentionally does not reconnect.
+	go func() {
+		c.Expect("QUIT")
+		c.Kill()
+	}()
+	if err := m.Disconnect(); err != nil {
+		t.Fatalf("unexpected error disconnecting: %s", err)
+	}
+	expectEvent(t, evs, "irc.DISCONNECT")
+	select {
+	case <-s.conns:
+		t.Errorf("did not expect client to reconnect after disconnecting")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestManager_ReconnectMaxAttempts(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:                 "squishyjones",
+		Username:             "mrjones",
+		Network:              s.Addr(),
+		Reconnect:            true,
+		ReconnectBackoff:     10 * time.Millisecond,
+		ReconnectMaxAttempts: 2,
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.*")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	// the server kills each connection before registration, so every
+	// reconnect attempt counts toward the maximum.
+	for i := 0; i < 3; i++ {
+		c := s.Accept()
+		c.Expect("NICK ")
+		c.Kill()
+	}
+	ev := expectEvent(t, evs, "irc.RECONNECT_FAILED")
+	if ev.Data["Attempts"] != 2 {
+		t.Errorf("expected 2 attempts, got %v", ev.Data["Attempts"])
+	}
+	select {
+	case <-s.conns:
+		t.Errorf("did not expect another reconnect attempt")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
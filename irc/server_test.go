@@ -0,0 +1,126 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeServer is a local stand-in for an IRC server.
+type fakeServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	// conns receives each accepted client connection.
+	conns chan *fakeClient
+}
+
+// fakeClient is a client connection accepted by a fakeServer.
+type fakeClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	return newFakeTLSServer(t, nil)
+}
+
+// newFakeTLSServer returns a fakeServer that accepts TLS connections using
+// the given configuration, or plain connections if it is nil.
+func newFakeTLSServer(t *testing.T, tc *tls.Config) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+	s := &fakeServer{t: t, ln: ln, conns: make(chan *fakeClient, 10)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				if tc, ok := c.(*tls.Conn); ok {
+					// the client waits for the handshake before sending
+					// anything, so complete it before the test reads.
+					_ = tc.SetDeadline(time.Now().Add(5 * time.Second))
+					_ = tc.Handshake()
+					_ = tc.SetDeadline(time.Time{})
+				}
+				s.conns <- &fakeClient{t: t, conn: c, r: bufio.NewReader(c)}
+			}(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) Close() {
+	_ = s.ln.Close()
+}
+
+// Accept waits for the next client connection.
+func (s *fakeServer) Accept() *fakeClient {
+	select {
+	case c := <-s.conns:
+		return c
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("timed out waiting for client to connect")
+	}
+	return nil
+}
+
+// Expect reads lines until one starts with the given prefix, returning it.
+func (c *fakeClient) Expect(prefix string) string {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		l, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("error waiting for %s from client: %s", prefix, err)
+		}
+		l = strings.TrimRight(l, "\r\n")
+		if strings.HasPrefix(l, prefix) {
+			return l
+		}
+	}
+}
+
+// Register completes client registration, sending RPL_WELCOME.
+func (c *fakeClient) Register() {
+	nick := strings.TrimPrefix(c.Expect("NICK "), "NICK ")
+	c.Expect("USER ")
+	c.Send(":irc.example.com 001 " + nick + " :Welcome to the fake network")
+}
+
+// Send writes a raw line to the client.
+func (c *fakeClient) Send(line string) {
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatalf("error writing to client: %s", err)
+	}
+}
+
+// PeerCertificates returns the certificates the client presented during the
+// TLS handshake.
+func (c *fakeClient) PeerCertificates() []*x509.Certificate {
+	tc, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	return tc.ConnectionState().PeerCertificates
+}
+
+// Kill closes the client's socket without warning.
+func (c *fakeClient) Kill() {
+	_ = c.conn.Close()
+}
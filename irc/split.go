@@ -0,0 +1,342 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// MaxLineLength is the maximum length of a line sent to or from an IRC
+// server, including the trailing CR LF.
+const MaxLineLength = 512
+
+// maxHostLength is the length assumed for the bot's host when it is not
+// known.
+const maxHostLength = 63
+
+// ErrInvalidLine is returned when sending a line that contains CR, LF, or
+// NUL characters.
+var ErrInvalidLine = errors.New("line contains CR, LF, or NUL")
+
+// MaxMessageLength returns the maximum length of a message sent by source
+// with the given command and target, such as "PRIVMSG" and "#squircy".
+// source is the full prefix the server relays the message with, usually
+// nick!user@host.
+func MaxMessageLength(source, command, target string) int {
+	// :source COMMAND target :message\r\n
+	return MaxLineLength - len(":"+source+" "+command+" "+target+" :\r\n")
+}
+
+// formatting describes the mIRC formatting in effect at some point in a
+// message.
+type formatting struct {
+	bold, italic, underline, strikethrough, monospace, reverse bool
+
+	// color and hexColor contain the parameters of the last color code,
+	// such as "04,12".
+	color    string
+	hexColor string
+}
+
+// apply updates the formatting with the given formatting code.
+func (f *formatting) apply(code string) {
+	switch code[0] {
+	case '\x02':
+		f.bold = !f.bold
+	case '\x1d':
+		f.italic = !f.italic
+	case '\x1f':
+		f.underline = !f.underline
+	case '\x1e':
+		f.strikethrough = !f.strikethrough
+	case '\x11':
+		f.monospace = !f.monospace
+	case '\x16':
+		f.reverse = !f.reverse
+	case '\x0f':
+		*f = formatting{}
+	case '\x03':
+		f.color = mergeColor(f.color, code[1:], 2)
+	case '\x04':
+		f.hexColor = mergeColor(f.hexColor, code[1:], 6)
+	}
+}
+
+// mergeColor returns the color in effect after a color code with the given
+// parameters. A color code without a background keeps the current one.
+func mergeColor(current, params string, width int) string {
+	if params == "" {
+		return ""
+	}
+	fg, bg := params, ""
+	if i := strings.IndexByte(params, ','); i >= 0 {
+		fg, bg = params[:i], params[i+1:]
+	} else if j := strings.IndexByte(current, ','); j >= 0 {
+		bg = current[j+1:]
+	}
+	// always use the widest form so the digits are not confused with any
+	// text that follows.
+	for len(fg) < width {
+		fg = "0" + fg
+	}
+	if bg == "" {
+		return fg
+	}
+	for len(bg) < width {
+		bg = "0" + bg
+	}
+	return fg + "," + bg
+}
+
+// String returns the formatting codes that restore the formatting.
+func (f formatting) String() string {
+	var b strings.Builder
+	for _, v := range []struct {
+		on   bool
+		code byte
+	}{
+		{f.bold, '\x02'},
+		{f.italic, '\x1d'},
+		{f.underline, '\x1f'},
+		{f.strikethrough, '\x1e'},
+		{f.monospace, '\x11'},
+		{f.reverse, '\x16'},
+	} {
+		if v.on {
+			b.WriteByte(v.code)
+		}
+	}
+	if f.color != "" {
+		b.WriteString("\x03" + f.color)
+	}
+	if f.hexColor != "" {
+		b.WriteString("\x04" + f.hexColor)
+	}
+	return b.String()
+}
+
+// An atom is a part of a message that cannot be split: a single character
+// or a complete formatting code.
+type atom struct {
+	s     string
+	space bool
+	code  bool
+}
+
+// isDigit returns true if c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isHexDigit returns true if c is an ASCII hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// colorCodeLength returns the length of the color code at the start of s.
+// width is the maximum number of digits in each color, and valid returns
+// true if a byte is a valid digit.
+func colorCodeLength(s string, width int, valid func(byte) bool) int {
+	n := 1
+	digits := func() int {
+		i := 0
+		for i < width && n+i < len(s) && valid(s[n+i]) {
+			i++
+		}
+		return i
+	}
+	fg := digits()
+	if fg == 0 {
+		return n
+	}
+	n += fg
+	if n+1 < len(s) && s[n] == ',' && valid(s[n+1]) {
+		n++
+		n += digits()
+	}
+	return n
+}
+
+// atomize splits a single line into atoms.
+func atomize(line string) []atom {
+	var res []atom
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch c {
+		case '\x02', '\x1d', '\x1f', '\x1e', '\x11', '\x16', '\x0f':
+			res = append(res, atom{s: line[i : i+1], code: true})
+			i++
+			continue
+		case '\x03':
+			n := colorCodeLength(line[i:], 2, isDigit)
+			res = append(res, atom{s: line[i : i+n], code: true})
+			i += n
+			continue
+		case '\x04':
+			n := colorCodeLength(line[i:], 6, isHexDigit)
+			res = append(res, atom{s: line[i : i+n], code: true})
+			i += n
+			continue
+		}
+		_, n := utf8.DecodeRuneInString(line[i:])
+		res = append(res, atom{s: line[i : i+n], space: c == ' '})
+		i += n
+	}
+	return res
+}
+
+// splitLines splits text into lines on CR, LF, or CR LF, removing empty
+// lines and NUL characters.
+func splitLines(text string) []string {
+	text = strings.Replace(text, "\x00", "", -1)
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	var res []string
+	for _, l := range strings.Split(text, "\n") {
+		if l != "" {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// SplitMessage splits text into messages that are each at most max bytes
+// long.
+//
+// Each line of text is sent as a separate message, and lines longer than
+// max are split between words where possible. Multi-byte characters and
+// formatting codes are never split, and formatting in effect at the end of
+// one message is restored at the start of the next.
+func SplitMessage(text string, max int) []string {
+	var res []string
+	for _, line := range splitLines(text) {
+		res = append(res, splitLine(atomize(line), max)...)
+	}
+	return res
+}
+
+// splitLine splits the atoms of a single line into messages.
+func splitLine(atoms []atom, max int) []string {
+	var res []string
+	var state formatting
+	emit := func(prefix string, as []atom) {
+		var b strings.Builder
+		visible := false
+		for _, a := range as {
+			b.WriteString(a.s)
+			visible = visible || !a.code
+		}
+		if visible {
+			res = append(res, prefix+b.String())
+		}
+	}
+	for start := 0; start < len(atoms); {
+		if start > 0 && atoms[start].code {
+			// formatting codes at the start of a continuation are folded
+			// into the restored formatting.
+			state.apply(atoms[start].s)
+			start++
+			continue
+		}
+		prefix := state.String()
+		size := len(prefix)
+		end := start
+		lastSpace := -1
+		current := state
+		var atSpace formatting
+		for end < len(atoms) {
+			a := atoms[end]
+			if size+len(a.s) > max {
+				if a.space {
+					lastSpace = end
+					atSpace = current
+				}
+				break
+			}
+			size += len(a.s)
+			if a.space {
+				lastSpace = end
+				atSpace = current
+			}
+			if a.code {
+				current.apply(a.s)
+			}
+			end++
+		}
+		switch {
+		case end == len(atoms):
+			emit(prefix, atoms[start:end])
+			return res
+
+		case lastSpace > start:
+			// break between words, dropping the space.
+			emit(prefix, atoms[start:lastSpace])
+			state = atSpace
+			start = lastSpace + 1
+
+		case end > start:
+			// a single word is longer than max.
+			emit(prefix, atoms[start:end])
+			state = current
+			start = end
+
+		default:
+			// max is too small to fit anything, send the atom anyway.
+			emit("", atoms[start:start+1])
+			if atoms[start].code {
+				state.apply(atoms[start].s)
+			}
+			start++
+		}
+	}
+	return res
+}
+
+// source returns the prefix the server relays the bot's messages with. If
+// the bot's user or host are not known, the longest likely values are used.
+func (conn *Connection) source() string {
+	var nick, user, host string
+	if conn.state != nil {
+		nick, user, host = conn.state.Self()
+	}
+	if nick == "" {
+		nick = conn.GetNick()
+	}
+	if user == "" {
+		user = "~" + conn.current.Username
+	}
+	if host == "" {
+		host = strings.Repeat("x", maxHostLength)
+	}
+	return nick + "!" + user + "@" + host
+}
+
+// sendMessage sends the message to target with the given command, split
+// into as many messages as necessary. pre and post are added to each
+// message, such as for CTCP ACTION, and tags, if any, are sent with each.
+func (conn *Connection) sendMessage(tags map[string]string, command, target, pre, post, message string) {
+	max := MaxMessageLength(conn.source(), command, target) - len(pre) - len(post)
+	for _, m := range SplitMessage(message, max) {
+		conn.sendChecked(formatTags(tags) + fmt.Sprintf("%s %s :%s%s%s", command, target, pre, m, post))
+	}
+}
+
+// Send queues the line to be sent. An error is returned if the line
+// contains CR, LF, or NUL characters.
+func (conn *Connection) Send(line string) error {
+	if strings.ContainsAny(line, "\r\n\x00") {
+		return ErrInvalidLine
+	}
+	conn.send(line)
+	return nil
+}
+
+// sendChecked sends the line, logging a warning if it is invalid.
+func (conn *Connection) sendChecked(line string) {
+	if err := conn.Send(line); err != nil {
+		logrus.Warnf("irc: not sending line: %s: %q", err, line)
+	}
+}
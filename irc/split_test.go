@@ -0,0 +1,138 @@
+package irc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMaxMessageLength(t *testing.T) {
+	source := "squishyjones!mrjones@bot.example.com"
+	n := MaxMessageLength(source, "PRIVMSG", "#squircy")
+	line := ":" + source + " PRIVMSG #squircy :" + strings.Repeat("a", n) + "\r\n"
+	if len(line) != MaxLineLength {
+		t.Errorf("expected a message of %d bytes to fill the line exactly, got %d bytes", n, len(line))
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		max      int
+		expected []string
+	}{
+		{"short", "hello world", 20, []string{"hello world"}},
+		{"exact", "hello world", 11, []string{"hello world"}},
+		{"words", "the quick brown fox jumps", 10, []string{"the quick", "brown fox", "jumps"}},
+		{"long word", "abcdefghijkl mn", 5, []string{"abcde", "fghij", "kl mn"}},
+		{"newlines", "one\ntwo\r\nthree\rfour", 10, []string{"one", "two", "three", "four"}},
+		{"empty lines", "\r\n\none\n\n\ntwo\n", 10, []string{"one", "two"}},
+		{"nul", "o\x00ne", 10, []string{"one"}},
+		{"empty", "", 10, nil},
+		{"bold", "\x02bold text here", 10, []string{"\x02bold text", "\x02here"}},
+		{"bold off", "\x02bold\x02 text here", 11, []string{"\x02bold\x02 text", "here"}},
+		{"reset", "\x02\x1dbold\x0f plain words", 11, []string{"\x02\x1dbold\x0f", "plain words"}},
+		{"color", "\x034,12red on blue text", 11, []string{"\x034,12red on", "\x0304,12blue", "\x0304,12text"}},
+		{"color keeps background", "\x031,2a \x033b c", 7, []string{"\x031,2a", "\x0303,02b", "\x0303,02c"}},
+		{"color off", "\x0304red\x03 plain text", 8, []string{"\x0304red\x03", "plain", "text"}},
+		{"hex color", "\x04FF0000red text", 11, []string{"\x04FF0000red", "\x04FF0000text"}},
+		{"codes only", "a \x02\x02", 1, []string{"a"}},
+		{"too small", "\x0304,12abc", 2, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := SplitMessage(test.text, test.max)
+			if !reflect.DeepEqual(res, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, res)
+			}
+		})
+	}
+}
+
+func TestSplitMessage_utf8(t *testing.T) {
+	text := strings.Repeat("日本語ü🙂", 20)
+	for max := 4; max < 20; max++ {
+		res := SplitMessage(text, max)
+		if joined := strings.Join(res, ""); joined != text {
+			t.Errorf("max %d: expected chunks to join to the original text, got %q", max, joined)
+		}
+		for _, m := range res {
+			if len(m) > max {
+				t.Errorf("max %d: chunk %q is %d bytes", max, m, len(m))
+			}
+			if !utf8.ValidString(m) {
+				t.Errorf("max %d: chunk %q is not valid UTF-8", max, m)
+			}
+		}
+	}
+}
+
+func TestConnection_Send(t *testing.T) {
+	conn, err := newConnection(Config{Nick: "squishyjones", Username: "mrjones"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, l := range []string{"PRIVMSG #a :one\r\nQUIT", "PRIVMSG #a :one\nQUIT", "PRIVMSG #a :\x00"} {
+		if err := conn.Send(l); err != ErrInvalidLine {
+			t.Errorf("expected ErrInvalidLine sending %q, got %v", l, err)
+		}
+	}
+	if err := conn.Send("PRIVMSG #a :ok"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if st := conn.queue.Stats(); st.Queued != 1 {
+		t.Errorf("expected only the valid line to be queued, got %+v", st)
+	}
+}
+
+func TestConnection_splitsMessages(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:     "squishyjones",
+		Username: "mrjones",
+		Network:  s.Addr(),
+		SendRate: -1,
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.JOIN")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Register()
+	const source = "squishyjones!mrjones@bot.example.com"
+	c.Send(":" + source + " JOIN #squircy")
+	expectEvent(t, evs, "irc.JOIN")
+
+	words := make([]string, 200)
+	for i := range words {
+		words[i] = "squircy"
+	}
+	text := strings.Join(words, " ")
+	err := m.Do(func(conn *Connection) error {
+		conn.Privmsg("#squircy", text)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var received []string
+	for n := 0; n < len(text); {
+		l := c.Expect("PRIVMSG #squircy :")
+		if relayed := ":" + source + " " + l + "\r\n"; len(relayed) > MaxLineLength {
+			t.Errorf("expected relayed line to fit in %d bytes, got %d", MaxLineLength, len(relayed))
+		}
+		msg := strings.TrimPrefix(l, "PRIVMSG #squircy :")
+		received = append(received, msg)
+		n += len(msg) + 1
+	}
+	if len(received) < 2 {
+		t.Errorf("expected message to be split, got %d lines", len(received))
+	}
+	if joined := strings.Join(received, " "); joined != text {
+		t.Errorf("expected split lines to join to the original message, got %q", joined)
+	}
+}
@@ -0,0 +1,655 @@
+package irc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Member is a user in a channel.
+type Member struct {
+	Nick string
+	// Modes contains the member's channel modes, such as "o" for operator
+	// or "v" for voice, most significant first.
+	Modes string
+	// Prefix contains the prefix for each of the member's modes, such as
+	// "@" for operator or "+" for voice, most significant first.
+	Prefix string
+}
+
+// A Channel describes a channel the bot is in.
+type Channel struct {
+	Name  string
+	Topic string
+	// TopicSetBy is the nick or full source of whoever set the topic.
+	TopicSetBy string
+	// TopicSetAt is the time the topic was set, if known.
+	TopicSetAt time.Time
+	// Modes contains the channel's modes, such as "n" or "k", and their
+	// parameters. Modes without a parameter have an empty value.
+	Modes map[string]string
+	// Members contains each member of the channel, sorted by nick.
+	Members []Member
+}
+
+// Member returns the member of the channel with the given nick.
+func (c *Channel) Member(nick string) (Member, bool) {
+	for _, mb := range c.Members {
+		if strings.EqualFold(mb.Nick, nick) {
+			return mb, true
+		}
+	}
+	return Member{}, false
+}
+
+// A User describes a user that shares a channel with the bot.
+type User struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+	// Account is the account the user is logged in to, if known.
+	Account string
+	// Away is true if the user is known to be away.
+	Away bool
+	// Channels contains the channels the user shares with the bot, sorted.
+	Channels []string
+}
+
+type channelState struct {
+	name    string
+	topic   string
+	topicBy string
+	topicAt time.Time
+	modes   map[byte]string
+	// members contains the modes of each member, by folded nick.
+	members map[string]string
+	// namesDone is true once the end of a NAMES reply is received; the
+	// next NAMES reply replaces the members.
+	namesDone bool
+}
+
+type userState struct {
+	nick     string
+	user     string
+	host     string
+	realName string
+	account  string
+	away     bool
+	// channels contains the folded name of each channel the user is in.
+	channels map[string]struct{}
+}
+
+// State tracks the channels the bot is in, their members, and the users in
+// them. It is updated with each Event received from the server.
+type State struct {
+	me string
+	// meUser and meHost are the bot's own user and host, if known.
+	meUser string
+	meHost string
+
+	// isupport contains the features advertised by the server.
+	isupport *ISupport
+
+	channels map[string]*channelState
+	users    map[string]*userState
+
+	mu sync.RWMutex
+}
+
+// NewState returns an empty State.
+func NewState() *State {
+	s := &State{}
+	s.reset()
+	return s
+}
+
+// reset clears the State and restores the defaults assumed before the
+// server advertises what it supports.
+func (s *State) reset() {
+	s.me = ""
+	s.meUser = ""
+	s.meHost = ""
+	s.isupport = NewISupport()
+	s.channels = make(map[string]*channelState)
+	s.users = make(map[string]*userState)
+}
+
+// Reset clears the State.
+func (s *State) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+// Me returns the bot's current nick.
+func (s *State) Me() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.me
+}
+
+// Self returns the bot's nick, and its user and host if they are known.
+func (s *State) Self() (nick, user, host string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.me, s.meUser, s.meHost
+}
+
+// ISupport returns the features advertised by the server.
+func (s *State) ISupport() *ISupport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isupport.copy()
+}
+
+// fold returns the name in lowercase according to the server's case
+// mapping.
+func (s *State) fold(name string) string {
+	return s.isupport.Fold(name)
+}
+
+func (s *State) isMe(nick string) bool {
+	return s.me != "" && s.fold(nick) == s.fold(s.me)
+}
+
+// IsChannel returns true if name is a channel name.
+func (s *State) IsChannel(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.isChannel(name)
+}
+
+func (s *State) isChannel(name string) bool {
+	return s.isupport.IsChannel(name)
+}
+
+// Channel returns the channel with the given name, if the bot is in it.
+func (s *State) Channel(name string) (*Channel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ch, ok := s.channels[s.fold(name)]
+	if !ok {
+		return nil, false
+	}
+	res := &Channel{
+		Name:       ch.name,
+		Topic:      ch.topic,
+		TopicSetBy: ch.topicBy,
+		TopicSetAt: ch.topicAt,
+		Modes:      make(map[string]string),
+		Members:    make([]Member, 0, len(ch.members)),
+	}
+	for m, p := range ch.modes {
+		res.Modes[string(m)] = p
+	}
+	for n, modes := range ch.members {
+		nick := n
+		if u, ok := s.users[n]; ok {
+			nick = u.nick
+		}
+		res.Members = append(res.Members, Member{Nick: nick, Modes: modes, Prefix: s.prefixFor(modes)})
+	}
+	sort.Slice(res.Members, func(i, j int) bool {
+		return res.Members[i].Nick < res.Members[j].Nick
+	})
+	return res, true
+}
+
+// Channels returns the name of each channel the bot is in, sorted.
+func (s *State) Channels() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]string, 0, len(s.channels))
+	for _, ch := range s.channels {
+		res = append(res, ch.name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// User returns the user with the given nick, if they share a channel with
+// the bot.
+func (s *State) User(nick string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[s.fold(nick)]
+	if !ok {
+		return nil, false
+	}
+	res := &User{
+		Nick:     u.nick,
+		User:     u.user,
+		Host:     u.host,
+		RealName: u.realName,
+		Account:  u.account,
+		Away:     u.away,
+		Channels: make([]string, 0, len(u.channels)),
+	}
+	for c := range u.channels {
+		if ch, ok := s.channels[c]; ok {
+			res.Channels = append(res.Channels, ch.name)
+		}
+	}
+	sort.Strings(res.Channels)
+	return res, true
+}
+
+// prefixFor returns the prefixes for the given membership modes.
+func (s *State) prefixFor(modes string) string {
+	var b strings.Builder
+	for i := 0; i < len(modes); i++ {
+		if j := strings.IndexByte(s.isupport.PrefixModes, modes[i]); j >= 0 && j < len(s.isupport.Prefixes) {
+			b.WriteByte(s.isupport.Prefixes[j])
+		}
+	}
+	return b.String()
+}
+
+// addMode returns modes with the given membership mode added, ordered
+// most significant first.
+func (s *State) addMode(modes string, m byte) string {
+	if strings.IndexByte(modes, m) >= 0 {
+		return modes
+	}
+	modes += string(m)
+	b := []byte(modes)
+	sort.SliceStable(b, func(i, j int) bool {
+		return strings.IndexByte(s.isupport.PrefixModes, b[i]) < strings.IndexByte(s.isupport.PrefixModes, b[j])
+	})
+	return string(b)
+}
+
+// user returns the user with the given nick, adding them if necessary.
+func (s *State) user(nick string) *userState {
+	k := s.fold(nick)
+	u, ok := s.users[k]
+	if !ok {
+		u = &userState{nick: nick, channels: make(map[string]struct{})}
+		s.users[k] = u
+	}
+	return u
+}
+
+// updateSource stores the user and host of a message's source.
+func (s *State) updateSource(e *Event) {
+	if e.Nick == "" || e.User == "" {
+		return
+	}
+	u, ok := s.users[s.fold(e.Nick)]
+	if !ok {
+		return
+	}
+	u.user = e.User
+	u.host = e.Host
+	if e.Account != "" {
+		u.account = e.Account
+	}
+}
+
+// join adds the user to the channel.
+func (s *State) join(ch *channelState, nick string, modes string) *userState {
+	u := s.user(nick)
+	k := s.fold(ch.name)
+	u.channels[k] = struct{}{}
+	if _, ok := ch.members[s.fold(nick)]; !ok || modes != "" {
+		ch.members[s.fold(nick)] = modes
+	}
+	return u
+}
+
+// part removes the user from the channel, or removes the channel if the
+// user is the bot.
+func (s *State) part(channel, nick string) {
+	k := s.fold(channel)
+	ch, ok := s.channels[k]
+	if !ok {
+		return
+	}
+	if s.isMe(nick) {
+		for n := range ch.members {
+			s.leave(n, k)
+		}
+		delete(s.channels, k)
+		return
+	}
+	s.leave(s.fold(nick), k)
+}
+
+// leave removes the user with the given folded nick from the channel with
+// the given folded name, forgetting the user if they share no channels
+// with the bot.
+func (s *State) leave(nick, channel string) {
+	if ch, ok := s.channels[channel]; ok {
+		delete(ch.members, nick)
+	}
+	u, ok := s.users[nick]
+	if !ok {
+		return
+	}
+	delete(u.channels, channel)
+	if len(u.channels) == 0 {
+		delete(s.users, nick)
+	}
+}
+
+// Handle updates the State with the given Event.
+func (s *State) Handle(e *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	args := e.Args
+	arg := func(i int) string {
+		if i < len(args) {
+			return args[i]
+		}
+		return ""
+	}
+	argsFrom := func(i int) []string {
+		if i < len(args) {
+			return args[i:]
+		}
+		return nil
+	}
+	switch e.Code {
+	case "001":
+		s.reset()
+		s.me = arg(0)
+
+	case "005":
+		// skip the bot's nick and the trailing "are supported by this server".
+		if len(args) > 2 {
+			s.isupport.Parse(args[1 : len(args)-1])
+		}
+
+	case "JOIN":
+		if s.isMe(e.Nick) && e.User != "" {
+			s.meUser = e.User
+			s.meHost = e.Host
+		}
+		channel := arg(0)
+		k := s.fold(channel)
+		ch, ok := s.channels[k]
+		if !ok {
+			if !s.isMe(e.Nick) {
+				return
+			}
+			ch = &channelState{name: channel, modes: make(map[byte]string), members: make(map[string]string)}
+			s.channels[k] = ch
+		}
+		u := s.join(ch, e.Nick, "")
+		u.user = e.User
+		u.host = e.Host
+		if e.Account != "" {
+			u.account = e.Account
+		}
+		if len(args) >= 3 {
+			// extended-join includes the account and real name.
+			u.realName = arg(2)
+			if arg(1) == "*" {
+				u.account = ""
+			}
+		}
+
+	case "PART":
+		s.part(arg(0), e.Nick)
+
+	case "KICK":
+		s.part(arg(0), arg(1))
+
+	case "QUIT":
+		k := s.fold(e.Nick)
+		if u, ok := s.users[k]; ok {
+			for c := range u.channels {
+				s.leave(k, c)
+			}
+		}
+
+	case "NICK":
+		s.rename(e.Nick, arg(0))
+
+	case "MODE":
+		if ch, ok := s.channels[s.fold(arg(0))]; ok {
+			s.applyModes(ch, arg(1), argsFrom(2))
+		}
+
+	case "TOPIC":
+		if ch, ok := s.channels[s.fold(arg(0))]; ok {
+			ch.topic = arg(1)
+			ch.topicBy = e.Nick
+			ch.topicAt = time.Now()
+		}
+		s.updateSource(e)
+
+	case "AWAY":
+		if u, ok := s.users[s.fold(e.Nick)]; ok {
+			u.away = len(args) > 0 && arg(0) != ""
+		}
+
+	case "396": // RPL_HOSTHIDDEN
+		s.meHost = arg(1)
+
+	case "324": // RPL_CHANNELMODEIS
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.modes = make(map[byte]string)
+			s.applyModes(ch, arg(2), argsFrom(3))
+		}
+
+	case "331": // RPL_NOTOPIC
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.topic = ""
+			ch.topicBy = ""
+			ch.topicAt = time.Time{}
+		}
+
+	case "332": // RPL_TOPIC
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.topic = arg(2)
+		}
+
+	case "333": // RPL_TOPICWHOTIME
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.topicBy = arg(2)
+			if ts, err := strconv.ParseInt(arg(3), 10, 64); err == nil {
+				ch.topicAt = time.Unix(ts, 0)
+			}
+		}
+
+	case "352": // RPL_WHOREPLY
+		s.handleWho(args)
+
+	case "353": // RPL_NAMREPLY
+		s.handleNames(arg(2), arg(3))
+
+	case "366": // RPL_ENDOFNAMES
+		if ch, ok := s.channels[s.fold(arg(1))]; ok {
+			ch.namesDone = true
+		}
+
+	default:
+		s.updateSource(e)
+	}
+}
+
+// handleNames adds the members listed in a NAMES reply.
+func (s *State) handleNames(channel, names string) {
+	ch, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return
+	}
+	if ch.namesDone {
+		// a new NAMES reply replaces the members.
+		k := s.fold(ch.name)
+		for n := range ch.members {
+			s.leave(n, k)
+		}
+		ch.namesDone = false
+	}
+	for _, name := range strings.Fields(names) {
+		modes := ""
+		for len(name) > 0 {
+			i := strings.IndexByte(s.isupport.Prefixes, name[0])
+			if i < 0 || i >= len(s.isupport.PrefixModes) {
+				break
+			}
+			modes = s.addMode(modes, s.isupport.PrefixModes[i])
+			name = name[1:]
+		}
+		nick, user, host := name, "", ""
+		// userhost-in-names includes the user and host.
+		if i := strings.IndexByte(name, '!'); i >= 0 {
+			nick = name[:i]
+			user = name[i+1:]
+			if j := strings.IndexByte(user, '@'); j >= 0 {
+				host = user[j+1:]
+				user = user[:j]
+			}
+		}
+		if nick == "" {
+			continue
+		}
+		u := s.join(ch, nick, modes)
+		if user != "" {
+			u.user = user
+			u.host = host
+		}
+	}
+}
+
+// handleWho updates a user with the contents of a WHO reply.
+func (s *State) handleWho(args []string) {
+	// <me> <channel> <user> <host> <server> <nick> <flags> :<hopcount> <real name>
+	if len(args) < 8 {
+		return
+	}
+	u, ok := s.users[s.fold(args[5])]
+	if !ok {
+		return
+	}
+	u.user = args[2]
+	u.host = args[3]
+	if i := strings.IndexByte(args[7], ' '); i >= 0 {
+		u.realName = args[7][i+1:]
+	}
+	flags := args[6]
+	u.away = strings.HasPrefix(flags, "G")
+	ch, ok := s.channels[s.fold(args[1])]
+	if !ok {
+		return
+	}
+	modes := ""
+	for i := 0; i < len(flags); i++ {
+		if j := strings.IndexByte(s.isupport.Prefixes, flags[i]); j >= 0 && j < len(s.isupport.PrefixModes) {
+			modes = s.addMode(modes, s.isupport.PrefixModes[j])
+		}
+	}
+	k := s.fold(u.nick)
+	if _, ok := ch.members[k]; ok {
+		ch.members[k] = modes
+	}
+}
+
+// rename changes a user's nick.
+func (s *State) rename(from, to string) {
+	if s.isMe(from) {
+		s.me = to
+	}
+	fk, tk := s.fold(from), s.fold(to)
+	u, ok := s.users[fk]
+	if !ok {
+		return
+	}
+	delete(s.users, fk)
+	u.nick = to
+	s.users[tk] = u
+	for c := range u.channels {
+		if ch, ok := s.channels[c]; ok {
+			modes := ch.members[fk]
+			delete(ch.members, fk)
+			ch.members[tk] = modes
+		}
+	}
+}
+
+// applyModes applies a channel mode change.
+func (s *State) applyModes(ch *channelState, modes string, params []string) {
+	adding := true
+	next := func() string {
+		if len(params) == 0 {
+			return ""
+		}
+		p := params[0]
+		params = params[1:]
+		return p
+	}
+	for i := 0; i < len(modes); i++ {
+		m := modes[i]
+		switch {
+		case m == '+':
+			adding = true
+
+		case m == '-':
+			adding = false
+
+		case strings.IndexByte(s.isupport.PrefixModes, m) >= 0:
+			k := s.fold(next())
+			cur, ok := ch.members[k]
+			if !ok {
+				continue
+			}
+			if adding {
+				ch.members[k] = s.addMode(cur, m)
+			} else {
+				ch.members[k] = strings.Replace(cur, string(m), "", 1)
+			}
+
+		case strings.IndexByte(s.isupport.ChanModes[0], m) >= 0:
+			// list modes, such as bans, are not tracked.
+			next()
+
+		case strings.IndexByte(s.isupport.ChanModes[1], m) >= 0:
+			p := next()
+			if adding {
+				ch.modes[m] = p
+			} else {
+				delete(ch.modes, m)
+			}
+
+		case strings.IndexByte(s.isupport.ChanModes[2], m) >= 0:
+			if adding {
+				ch.modes[m] = next()
+			} else {
+				delete(ch.modes, m)
+			}
+
+		default:
+			if adding {
+				ch.modes[m] = ""
+			} else {
+				delete(ch.modes, m)
+			}
+		}
+	}
+}
+
+// State returns the State of the network the Manager connects to.
+func (m *Manager) State() *State {
+	return m.state
+}
+
+// Channel returns the channel with the given name, if the bot is in it.
+func (m *Manager) Channel(name string) (*Channel, bool) {
+	return m.state.Channel(name)
+}
+
+// User returns the user with the given nick, if they share a channel with
+// the bot.
+func (m *Manager) User(nick string) (*User, bool) {
+	return m.state.User(nick)
+}
+
+// ISupport returns the features advertised by the server.
+func (m *Manager) ISupport() *ISupport {
+	return m.state.ISupport()
+}
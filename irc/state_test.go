@@ -0,0 +1,232 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// parseLine returns the Event for a raw line received from the server.
+func parseLine(raw string) *Event {
+	m, err := ParseMessage(raw)
+	if err != nil {
+		panic(err)
+	}
+	return m.Event()
+}
+
+func newTestState(lines ...string) *State {
+	s := NewState()
+	feed(s, lines...)
+	return s
+}
+
+func feed(s *State, lines ...string) {
+	for _, l := range lines {
+		s.Handle(parseLine(l))
+	}
+}
+
+var registered = []string{
+	":irc.example.com 001 squishyjones :Welcome to the network",
+	":irc.example.com 005 squishyjones CHANTYPES=# PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst CASEMAPPING=rfc1459 :are supported by this server",
+	":squishyjones!mrjones@bot.example.com JOIN #squircy",
+	":irc.example.com 332 squishyjones #squircy :welcome to squircy",
+	":irc.example.com 333 squishyjones #squircy veonik!v@example.com 1600000000",
+	":irc.example.com 353 squishyjones = #squircy :squishyjones ~@veonik +voiced %half",
+	":irc.example.com 366 squishyjones #squircy :End of /NAMES list.",
+}
+
+func expectMembers(t *testing.T, s *State, channel string, expected map[string]string) {
+	t.Helper()
+	ch, ok := s.Channel(channel)
+	if !ok {
+		t.Fatalf("expected to be in %s", channel)
+	}
+	res := make(map[string]string)
+	for _, m := range ch.Members {
+		res[m.Nick] = m.Prefix
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected members of %s to be %v, got %v", channel, expected, res)
+	}
+}
+
+func TestState_Names(t *testing.T) {
+	s := newTestState(registered...)
+	if s.Me() != "squishyjones" {
+		t.Errorf("expected own nick to be squishyjones, got %s", s.Me())
+	}
+	expectMembers(t, s, "#SQUIRCY", map[string]string{
+		"squishyjones": "",
+		"veonik":       "~@",
+		"voiced":       "+",
+		"half":         "%",
+	})
+	ch, _ := s.Channel("#squircy")
+	if ch.Topic != "welcome to squircy" || ch.TopicSetBy != "veonik!v@example.com" || !ch.TopicSetAt.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("unexpected topic: %q by %q at %s", ch.Topic, ch.TopicSetBy, ch.TopicSetAt)
+	}
+	if m, ok := ch.Member("VEONIK"); !ok || m.Modes != "qo" {
+		t.Errorf("expected veonik to have modes qo, got %q", m.Modes)
+	}
+
+	// a later NAMES reply replaces the members.
+	feed(s,
+		":irc.example.com 353 squishyjones = #squircy :squishyjones @veonik",
+		":irc.example.com 366 squishyjones #squircy :End of /NAMES list.",
+	)
+	expectMembers(t, s, "#squircy", map[string]string{"squishyjones": "", "veonik": "@"})
+	if _, ok := s.User("voiced"); ok {
+		t.Errorf("expected voiced to be forgotten")
+	}
+}
+
+func TestState_JoinPartQuitKick(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s,
+		":newbie!n@newbie.example.com JOIN #squircy",
+		":squishyjones!mrjones@bot.example.com JOIN #other",
+		":irc.example.com 353 squishyjones = #other :squishyjones newbie veonik",
+		":irc.example.com 366 squishyjones #other :End of /NAMES list.",
+	)
+	u, ok := s.User("newbie")
+	if !ok {
+		t.Fatalf("expected newbie to be known")
+	}
+	if u.User != "n" || u.Host != "newbie.example.com" || !reflect.DeepEqual(u.Channels, []string{"#other", "#squircy"}) {
+		t.Errorf("unexpected user: %+v", u)
+	}
+	if ch := s.Channels(); !reflect.DeepEqual(ch, []string{"#other", "#squircy"}) {
+		t.Errorf("expected to be in #other and #squircy, got %v", ch)
+	}
+
+	feed(s, ":newbie!n@newbie.example.com PART #squircy :bye")
+	if u, _ := s.User("newbie"); !reflect.DeepEqual(u.Channels, []string{"#other"}) {
+		t.Errorf("expected newbie to be only in #other, got %v", u.Channels)
+	}
+	feed(s, ":newbie!n@newbie.example.com QUIT :gone")
+	if _, ok := s.User("newbie"); ok {
+		t.Errorf("expected newbie to be forgotten after quitting")
+	}
+	expectMembers(t, s, "#other", map[string]string{"squishyjones": "", "veonik": ""})
+
+	feed(s, ":veonik!v@example.com KICK #squircy voiced :bye")
+	if _, ok := s.User("voiced"); ok {
+		t.Errorf("expected voiced to be forgotten after being kicked")
+	}
+	feed(s, ":veonik!v@example.com KICK #other squishyjones :out")
+	if _, ok := s.Channel("#other"); ok {
+		t.Errorf("expected #other to be forgotten after being kicked")
+	}
+	feed(s, ":squishyjones!mrjones@bot.example.com PART #squircy")
+	if ch := s.Channels(); len(ch) != 0 {
+		t.Errorf("expected to be in no channels, got %v", ch)
+	}
+	if _, ok := s.User("veonik"); ok {
+		t.Errorf("expected users to be forgotten after parting every channel")
+	}
+
+	// joins to channels the bot is not in are ignored.
+	feed(s, ":someone!s@example.com JOIN #elsewhere")
+	if _, ok := s.User("someone"); ok {
+		t.Errorf("did not expect to track users in other channels")
+	}
+}
+
+func TestState_Nick(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s, ":veonik!v@example.com NICK :veo")
+	if _, ok := s.User("veonik"); ok {
+		t.Errorf("expected old nick to be forgotten")
+	}
+	expectMembers(t, s, "#squircy", map[string]string{
+		"squishyjones": "",
+		"veo":          "~@",
+		"voiced":       "+",
+		"half":         "%",
+	})
+	feed(s, ":squishyjones!mrjones@bot.example.com NICK squishy")
+	if s.Me() != "squishy" {
+		t.Errorf("expected own nick to change, got %s", s.Me())
+	}
+	// rfc1459 case mapping treats [] as the lowercase of {}.
+	feed(s, ":half!h@example.com NICK :h[a]lf")
+	if u, ok := s.User("H{A}LF"); !ok || u.Nick != "h[a]lf" {
+		t.Errorf("expected to find user using rfc1459 case mapping")
+	}
+}
+
+func TestState_Mode(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s,
+		":veonik!v@example.com MODE #squircy +ov-v+kl voiced voiced voiced secret 10",
+		":veonik!v@example.com MODE #squircy +b-q *!*@bad.example.com veonik",
+		":veonik!v@example.com MODE #squircy +nt",
+		":squishyjones MODE squishyjones :+i",
+	)
+	expectMembers(t, s, "#squircy", map[string]string{
+		"squishyjones": "",
+		"veonik":       "@",
+		"voiced":       "@",
+		"half":         "%",
+	})
+	ch, _ := s.Channel("#squircy")
+	expected := map[string]string{"k": "secret", "l": "10", "n": "", "t": ""}
+	if !reflect.DeepEqual(ch.Modes, expected) {
+		t.Errorf("expected modes %v, got %v", expected, ch.Modes)
+	}
+	feed(s, ":veonik!v@example.com MODE #squircy -lk secret")
+	ch, _ = s.Channel("#squircy")
+	if expected := map[string]string{"n": "", "t": ""}; !reflect.DeepEqual(ch.Modes, expected) {
+		t.Errorf("expected modes %v, got %v", expected, ch.Modes)
+	}
+	feed(s, ":irc.example.com 324 squishyjones #squircy +ks other")
+	ch, _ = s.Channel("#squircy")
+	if expected := map[string]string{"k": "other", "s": ""}; !reflect.DeepEqual(ch.Modes, expected) {
+		t.Errorf("expected modes %v, got %v", expected, ch.Modes)
+	}
+}
+
+func TestState_Topic(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s, ":veonik!v@example.com TOPIC #squircy :a new topic")
+	ch, _ := s.Channel("#squircy")
+	if ch.Topic != "a new topic" || ch.TopicSetBy != "veonik" {
+		t.Errorf("unexpected topic: %q by %q", ch.Topic, ch.TopicSetBy)
+	}
+	feed(s, ":irc.example.com 331 squishyjones #squircy :No topic is set")
+	ch, _ = s.Channel("#squircy")
+	if ch.Topic != "" || ch.TopicSetBy != "" {
+		t.Errorf("expected topic to be cleared, got %q by %q", ch.Topic, ch.TopicSetBy)
+	}
+}
+
+func TestState_Who(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s,
+		":irc.example.com 352 squishyjones #squircy vo voiced.example.com irc.example.com voiced G@ :0 Voiced User",
+		":irc.example.com 352 squishyjones #squircy ~h half.example.com irc.example.com half H :0 Half",
+	)
+	u, _ := s.User("voiced")
+	if u.User != "vo" || u.Host != "voiced.example.com" || u.RealName != "Voiced User" || !u.Away {
+		t.Errorf("unexpected user after WHO: %+v", u)
+	}
+	expectMembers(t, s, "#squircy", map[string]string{
+		"squishyjones": "",
+		"veonik":       "~@",
+		"voiced":       "@",
+		"half":         "",
+	})
+}
+
+func TestState_001ResetsState(t *testing.T) {
+	s := newTestState(registered...)
+	feed(s, ":irc.example.com 001 squishy :Welcome again")
+	if ch := s.Channels(); len(ch) != 0 {
+		t.Errorf("expected state to be reset after registering, got channels %v", ch)
+	}
+	if s.Me() != "squishy" {
+		t.Errorf("expected own nick to be squishy, got %s", s.Me())
+	}
+}
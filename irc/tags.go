@@ -0,0 +1,107 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidTag is returned when sending a message tag with an invalid key.
+var ErrInvalidTag = errors.New("invalid message tag")
+
+// ErrTagsNotSupported is returned when sending message tags to a server
+// that has not enabled the message-tags capability.
+var ErrTagsNotSupported = errors.New("server does not support message-tags")
+
+// tagValueEscaper escapes message tag values.
+var tagValueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\:`,
+	" ", `\s`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+// validTagKey returns true if key can be sent as a message tag key, such as
+// "+draft/reply".
+func validTagKey(key string) bool {
+	return key != "" && key != "+" && !strings.ContainsAny(key, " ;=\r\n\x00")
+}
+
+// formatTags returns the message tags prefix for a line, such as
+// "@+draft/reply=abc;time=... ", or the empty string if there are no tags.
+// Tags are sorted by key.
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('@')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(k)
+		if v := tags[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(tagValueEscaper.Replace(v))
+		}
+	}
+	b.WriteByte(' ')
+	return b.String()
+}
+
+// checkTags returns an error if the tags cannot be sent.
+func (conn *Connection) checkTags(tags map[string]string) error {
+	for k := range tags {
+		if !validTagKey(k) {
+			return errors.Wrapf(ErrInvalidTag, "key %q", k)
+		}
+	}
+	if len(tags) > 0 && !conn.HasCapability("message-tags") {
+		return ErrTagsNotSupported
+	}
+	return nil
+}
+
+// SendTagged queues the line to be sent with the given message tags.
+func (conn *Connection) SendTagged(tags map[string]string, line string) error {
+	if err := conn.checkTags(tags); err != nil {
+		return err
+	}
+	return conn.Send(formatTags(tags) + line)
+}
+
+// TaggedPrivmsg sends the message to target with the given message tags,
+// such as "+draft/reply". The tags are sent with each part of a message
+// that is split to fit.
+func (conn *Connection) TaggedPrivmsg(tags map[string]string, target, message string) error {
+	if err := conn.checkTags(tags); err != nil {
+		return err
+	}
+	conn.sendMessage(tags, "PRIVMSG", target, "", "", message)
+	return nil
+}
+
+// TaggedNotice sends the notice to target with the given message tags.
+func (conn *Connection) TaggedNotice(tags map[string]string, target, message string) error {
+	if err := conn.checkTags(tags); err != nil {
+		return err
+	}
+	conn.sendMessage(tags, "NOTICE", target, "", "", message)
+	return nil
+}
+
+// Tagmsg sends a TAGMSG, a message with only tags, to target.
+func (conn *Connection) Tagmsg(tags map[string]string, target string) error {
+	if len(tags) == 0 {
+		return errors.Wrap(ErrInvalidTag, "TAGMSG requires tags")
+	}
+	return conn.SendTagged(tags, "TAGMSG "+target)
+}
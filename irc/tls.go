@@ -0,0 +1,132 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SASL mechanisms supported by the Connection.
+const (
+	SASLPlain    = "PLAIN"
+	SASLExternal = "EXTERNAL"
+)
+
+// saslMechanism returns the configured SASL mechanism, or an error if it is
+// not supported.
+func saslMechanism(c *Config) (string, error) {
+	switch mech := strings.ToUpper(c.SASLMechanism); mech {
+	case "":
+		return SASLPlain, nil
+	case SASLPlain, SASLExternal:
+		return mech, nil
+	default:
+		return "", errors.Errorf("unsupported sasl_mechanism %s", c.SASLMechanism)
+	}
+}
+
+// resolvePath returns the path relative to the root directory, unless it
+// is absolute.
+func (c *Config) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.RootDir, path)
+}
+
+// parsePin returns the SHA-256 fingerprint described by pin, which is
+// hex-encoded and may contain colons.
+func parsePin(pin string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(pin, ":", "", -1))
+	if err != nil || len(b) != sha256.Size {
+		return nil, errors.Errorf("invalid tls_pins entry %s: expected a hex-encoded SHA-256 fingerprint", pin)
+	}
+	return b, nil
+}
+
+// Fingerprint returns the hex-encoded SHA-256 fingerprint of a DER-encoded
+// certificate, as used for certificate pinning and CertFP.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// tlsConfig returns the TLS configuration described by c.
+func tlsConfig(c *Config) (*tls.Config, error) {
+	res := &tls.Config{InsecureSkipVerify: c.TLSInsecureSkipVerify}
+	if c.TLSCert != "" {
+		key := c.TLSKey
+		if key == "" {
+			// the key may be in the same file as the certificate.
+			key = c.TLSCert
+		}
+		cert, err := tls.LoadX509KeyPair(c.resolvePath(c.TLSCert), c.resolvePath(key))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load tls_cert")
+		}
+		res.Certificates = []tls.Certificate{cert}
+	} else if c.TLSKey != "" {
+		return nil, errors.New("tls_key requires tls_cert")
+	}
+	if c.TLSCA != "" {
+		b, err := ioutil.ReadFile(c.resolvePath(c.TLSCA))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read tls_ca")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in tls_ca %s", c.TLSCA)
+		}
+		res.RootCAs = pool
+	}
+	if len(c.TLSPins) > 0 {
+		var pins [][]byte
+		for _, p := range c.TLSPins {
+			b, err := parsePin(p)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, b)
+		}
+		// the pinned certificate is trusted in place of the usual chain
+		// verification, so self-signed certificates can be used.
+		res.InsecureSkipVerify = true
+		res.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			sum := sha256.Sum256(rawCerts[0])
+			for _, p := range pins {
+				if bytes.Equal(sum[:], p) {
+					return nil
+				}
+			}
+			return errors.Errorf("server certificate %s does not match tls_pins", Fingerprint(rawCerts[0]))
+		}
+	}
+	return res, nil
+}
+
+// validateTLSConfig returns an error if the TLS and SASL options in c are
+// invalid.
+func validateTLSConfig(c *Config) error {
+	mech, err := saslMechanism(c)
+	if err != nil {
+		return err
+	}
+	if c.SASL && mech == SASLExternal && (!c.TLS || c.TLSCert == "") {
+		return errors.New("sasl_mechanism EXTERNAL requires tls and tls_cert")
+	}
+	if !c.TLS {
+		return nil
+	}
+	_, err = tlsConfig(c)
+	return err
+}
@@ -0,0 +1,260 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPKI contains a certificate authority and the server and client
+// certificates it issued, written to PEM files in dir.
+type testPKI struct {
+	dir string
+
+	ca     *x509.Certificate
+	server tls.Certificate
+	client tls.Certificate
+}
+
+// newTestPKI writes ca.pem, server.pem, client.pem, and client.key to a
+// temporary directory.
+func newTestPKI(t *testing.T) *testPKI {
+	dir, err := ioutil.TempDir("", "squircy-irc-tls")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	p := &testPKI{dir: dir}
+	caKey := newTestKey(t)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "squircy test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("unable to create CA certificate: %s", err)
+	}
+	if p.ca, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatalf("unable to parse CA certificate: %s", err)
+	}
+	p.writePEM(t, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(serial int64, name string, usage x509.ExtKeyUsage, certFile, keyFile string) tls.Certificate {
+		key := newTestKey(t)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("unable to create certificate: %s", err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatalf("unable to marshal key: %s", err)
+		}
+		p.writePEM(t, certFile, "CERTIFICATE", der)
+		p.writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	p.server = issue(2, "irc.example.com", x509.ExtKeyUsageServerAuth, "server.pem", "server.pem")
+	p.client = issue(3, "squishyjones", x509.ExtKeyUsageClientAuth, "client.pem", "client.key")
+	return p
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+	return key
+}
+
+// writePEM appends a PEM block to the named file.
+func (p *testPKI) writePEM(t *testing.T, name, typ string, der []byte) {
+	f, err := os.OpenFile(filepath.Join(p.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("unable to open %s: %s", name, err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: typ, Bytes: der}); err != nil {
+		t.Fatalf("unable to write %s: %s", name, err)
+	}
+}
+
+func (p *testPKI) Close() {
+	_ = os.RemoveAll(p.dir)
+}
+
+func TestValidateTLSConfig(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.Close()
+	tests := []struct {
+		name string
+		c    Config
+		err  string
+	}{
+		{"valid", Config{TLS: true, RootDir: p.dir, TLSCert: "client.pem", TLSKey: "client.key", TLSCA: "ca.pem"}, ""},
+		{"combined cert and key", Config{TLS: true, RootDir: p.dir, TLSCert: "server.pem"}, ""},
+		{"absolute path", Config{TLS: true, TLSCA: filepath.Join(p.dir, "ca.pem")}, ""},
+		{"missing cert", Config{TLS: true, RootDir: p.dir, TLSCert: "missing.pem"}, "tls_cert"},
+		{"key without cert", Config{TLS: true, TLSKey: "client.key"}, "requires tls_cert"},
+		{"missing key", Config{TLS: true, RootDir: p.dir, TLSCert: "client.pem"}, "tls_cert"},
+		{"invalid ca", Config{TLS: true, RootDir: p.dir, TLSCA: "client.key"}, "no certificates"},
+		{"invalid pin", Config{TLS: true, TLSPins: []string{"abcd"}}, "tls_pins"},
+		{"pin with colons", Config{TLS: true, TLSPins: []string{strings.Repeat("AB:", 31) + "AB"}}, ""},
+		{"tls disabled", Config{TLSCert: "missing.pem"}, ""},
+		{"unknown mechanism", Config{SASL: true, SASLMechanism: "SCRAM-SHA-256"}, "unsupported sasl_mechanism"},
+		{"external without cert", Config{TLS: true, SASL: true, SASLMechanism: "external"}, "requires tls and tls_cert"},
+		{"external", Config{TLS: true, SASL: true, SASLMechanism: "external", RootDir: p.dir, TLSCert: "client.pem", TLSKey: "client.key"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateTLSConfig(&test.c)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestManager_TLS(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.Close()
+	pin := Fingerprint(p.server.Certificate[0])
+	tests := []struct {
+		name string
+		c    Config
+		ok   bool
+	}{
+		{"untrusted", Config{}, false},
+		{"ca", Config{TLSCA: "ca.pem"}, true},
+		{"insecure", Config{TLSInsecureSkipVerify: true}, true},
+		{"pinned", Config{TLSPins: []string{"00" + pin[2:], strings.ToUpper(pin)}}, true},
+		{"wrong pin", Config{TLSCA: "ca.pem", TLSPins: []string{Fingerprint(p.client.Certificate[0])}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newFakeTLSServer(t, &tls.Config{Certificates: []tls.Certificate{p.server}})
+			defer s.Close()
+			c := test.c
+			c.Nick = "squishyjones"
+			c.Username = "mrjones"
+			c.Network = s.Addr()
+			c.TLS = true
+			c.RootDir = p.dir
+			m, _, cleanup := newTestManager(&c)
+			defer cleanup()
+			err := m.Connect()
+			if !test.ok {
+				if err == nil {
+					t.Errorf("expected error connecting")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error connecting: %s", err)
+			}
+			s.Accept().Register()
+		})
+	}
+}
+
+func TestManager_SASLExternal(t *testing.T) {
+	p := newTestPKI(t)
+	defer p.Close()
+	s := newFakeTLSServer(t, &tls.Config{
+		Certificates: []tls.Certificate{p.server},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})
+	defer s.Close()
+	m, _, cleanup := newTestManager(&Config{
+		Nick:          "squishyjones",
+		Username:      "mrjones",
+		Network:       s.Addr(),
+		TLS:           true,
+		TLSCert:       "client.pem",
+		TLSKey:        "client.key",
+		TLSCA:         "ca.pem",
+		SASL:          true,
+		SASLMechanism: "EXTERNAL",
+		Capabilities:  []string{"server-time"},
+		RootDir:       p.dir,
+	})
+	defer cleanup()
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	certs := c.PeerCertificates()
+	if len(certs) == 0 || Fingerprint(certs[0].Raw) != Fingerprint(p.client.Certificate[0]) {
+		t.Fatalf("expected client to present its certificate")
+	}
+	c.Expect("CAP LS 302")
+	c.Send(":irc.example.com CAP * LS :sasl=PLAIN,EXTERNAL")
+	if l := c.Expect("CAP REQ"); l != "CAP REQ :sasl" {
+		t.Errorf("unexpected request: %s", l)
+	}
+	c.Send(":irc.example.com CAP * ACK :sasl")
+	c.Expect("AUTHENTICATE EXTERNAL")
+	c.Send("AUTHENTICATE +")
+	if l := c.Expect("AUTHENTICATE"); l != "AUTHENTICATE +" {
+		t.Errorf("expected empty EXTERNAL response, got %s", l)
+	}
+	c.Send(":irc.example.com 900 squishyjones squishyjones!mrjones@localhost squishy :You are now logged in as squishy")
+	c.Send(":irc.example.com 903 squishyjones :SASL authentication successful")
+	c.Expect("CAP END")
+}
+
+func TestManager_SASLFailure(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+	m, d, cleanup := newTestManager(&Config{
+		Nick:         "squishyjones",
+		Username:     "mrjones",
+		Network:      s.Addr(),
+		SASL:         true,
+		SASLUsername: "squishy",
+		SASLPassword: "wrong",
+	})
+	defer cleanup()
+	evs := recordEvents(d, "irc.DISCONNECT")
+	if err := m.Connect(); err != nil {
+		t.Fatalf("unexpected error connecting: %s", err)
+	}
+	c := s.Accept()
+	c.Expect("CAP LS 302")
+	c.Send(":irc.example.com CAP * LS :sasl=EXTERNAL")
+	c.Expect("CAP END")
+	c.Expect("QUIT")
+	c.Kill()
+	expectEvent(t, evs, "irc.DISCONNECT")
+	if err := m.LastError(); err == nil || !strings.Contains(err.Error(), "PLAIN") {
+		t.Errorf("expected error about the unsupported mechanism, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// APIVersion is the version of the plugin API provided by this package.
+// It is incremented whenever a change is made that breaks existing plugins.
+const APIVersion = 1
+
+// A Manifest describes a shared library plugin.
+//
+// The manifest of a shared library is stored next to it in a JSON file
+// with the same name and the extension ".manifest.json", such as
+// "babel.manifest.json" for "babel.so". The Manager reads the manifest
+// before the library is opened, checking that the plugin is compatible and
+// using it to determine the plugin's requirements, so reading a manifest
+// never runs any of the plugin's code.
+//
+// Shared library plugins declare their manifest in a package-level
+// variable named "Manifest" and pass it to MainWithManifest in their main
+// function; running the plugin's main package writes the manifest file.
+type Manifest struct {
+	// Name is the name of the Plugin created by the library.
+	Name string `json:"name"`
+	// Version is the version of the plugin itself.
+	Version string `json:"version"`
+	// APIVersion is the plugin API version the library was built against.
+	// If it is zero, the API version is not checked.
+	APIVersion int `json:"api_version"`
+	// Requires contains the names of the plugins that must be loaded before
+	// this plugin is initialized.
+	Requires []string `json:"requires"`
+}
+
+// ManifestPath returns the path of the manifest file for the shared library
+// at the given path.
+func ManifestPath(path string) string {
+	return strings.TrimSuffix(path, ".so") + ".manifest.json"
+}
+
+// An IncompatibleError is returned when a shared library plugin cannot be
+// loaded by the running application.
+type IncompatibleError struct {
+	// Path is the location of the shared library.
+	Path string
+	// Reasons describes each incompatibility found.
+	Reasons []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("plugin (%s) is incompatible: %s", e.Path, strings.Join(e.Reasons, "; "))
+}
+
+// CheckCompatibility inspects the build information embedded in the shared
+// library at the given path and returns an *IncompatibleError if it was
+// built with a different Go version or different versions of modules used by
+// the running application.
+// The library is not loaded.
+func CheckCompatibility(path string) error {
+	bi, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable (%s) to read plugin build info", path)
+	}
+	host, ok := debug.ReadBuildInfo()
+	if !ok {
+		// nothing to compare against
+		return nil
+	}
+	return checkBuildInfo(path, bi, host)
+}
+
+// checkBuildInfo compares the build information of the shared library at
+// path with that of the host application.
+func checkBuildInfo(path string, bi, host *debug.BuildInfo) error {
+	var reasons []string
+	if bi.GoVersion != host.GoVersion {
+		reasons = append(reasons, fmt.Sprintf("built with %s, but the application was built with %s", bi.GoVersion, host.GoVersion))
+	}
+	hostMods := modulesFromBuildInfo(host)
+	mods := modulesFromBuildInfo(bi)
+	paths := make([]string, 0, len(mods))
+	for p := range mods {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		v := mods[p]
+		hv, ok := hostMods[p]
+		if !ok || hv == v || hv == "(devel)" || v == "(devel)" {
+			continue
+		}
+		reasons = append(reasons, fmt.Sprintf("built with %s@%s, but the application has %s@%s", p, v, p, hv))
+	}
+	if len(reasons) > 0 {
+		return &IncompatibleError{Path: path, Reasons: reasons}
+	}
+	return nil
+}
+
+// modulesFromBuildInfo returns the version of each module in the build info,
+// keyed by module path.
+func modulesFromBuildInfo(bi *debug.BuildInfo) map[string]string {
+	res := make(map[string]string)
+	add := func(m *debug.Module) {
+		if m == nil || m.Path == "" {
+			return
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		res[m.Path] = m.Version
+	}
+	add(&bi.Main)
+	for _, m := range bi.Deps {
+		add(m)
+	}
+	return res
+}
+
+// ReadManifest checks the shared library at the given path for
+// compatibility and returns its Manifest. The library is not loaded.
+// If the library has no manifest file, nil is returned.
+func ReadManifest(path string) (*Manifest, error) {
+	if err := CheckCompatibility(path); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(ManifestPath(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "unable (%s) to read plugin manifest", path)
+	}
+	mf := &Manifest{}
+	if err := json.Unmarshal(b, mf); err != nil {
+		return nil, errors.Wrapf(err, "plugin (%s) has an invalid manifest", path)
+	}
+	if mf.APIVersion != 0 && mf.APIVersion != APIVersion {
+		return nil, &IncompatibleError{
+			Path:    path,
+			Reasons: []string{fmt.Sprintf("requires plugin API version %d, but the application provides %d", mf.APIVersion, APIVersion)},
+		}
+	}
+	return mf, nil
+}
+
+// MainWithManifest writes the manifest to stdout as JSON. Shared library
+// plugins call it from their main function so that the manifest file can
+// be generated by running the plugin's main package:
+//
+//	go run ./plugins/babel/plugin > out/babel.manifest.json
+func MainWithManifest(mf Manifest) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(mf); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to write manifest:", err)
+		os.Exit(1)
+	}
+}
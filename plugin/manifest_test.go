@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"testing"
+)
+
+func TestCheckBuildInfo(t *testing.T) {
+	host := &debug.BuildInfo{
+		GoVersion: "go1.20",
+		Main:      debug.Module{Path: "code.dopame.me/veonik/squircy3", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/dop251/goja", Version: "v1.0.0"},
+			{Path: "github.com/pkg/errors", Version: "v0.9.1"},
+		},
+	}
+	tests := []struct {
+		name    string
+		lib     *debug.BuildInfo
+		reasons []string
+	}{
+		{
+			"compatible",
+			&debug.BuildInfo{
+				GoVersion: "go1.20",
+				Deps:      []*debug.Module{{Path: "github.com/pkg/errors", Version: "v0.9.1"}},
+			},
+			nil,
+		},
+		{
+			"go version mismatch",
+			&debug.BuildInfo{GoVersion: "go1.19"},
+			[]string{"built with go1.19, but the application was built with go1.20"},
+		},
+		{
+			"module version mismatch",
+			&debug.BuildInfo{
+				GoVersion: "go1.20",
+				Deps: []*debug.Module{
+					{Path: "github.com/pkg/errors", Version: "v0.8.0"},
+					{Path: "github.com/dop251/goja", Version: "v1.1.0"},
+				},
+			},
+			[]string{
+				"built with github.com/dop251/goja@v1.1.0, but the application has github.com/dop251/goja@v1.0.0",
+				"built with github.com/pkg/errors@v0.8.0, but the application has github.com/pkg/errors@v0.9.1",
+			},
+		},
+		{
+			"replaced module",
+			&debug.BuildInfo{
+				GoVersion: "go1.20",
+				Deps: []*debug.Module{
+					{Path: "github.com/pkg/errors", Version: "v0.8.0", Replace: &debug.Module{Path: "github.com/pkg/errors", Version: "v0.9.1"}},
+				},
+			},
+			nil,
+		},
+		{
+			"development version",
+			&debug.BuildInfo{
+				GoVersion: "go1.20",
+				Main:      debug.Module{Path: "code.dopame.me/veonik/squircy3", Version: "v3.0.0"},
+			},
+			nil,
+		},
+		{
+			"unknown module",
+			&debug.BuildInfo{
+				GoVersion: "go1.20",
+				Deps:      []*debug.Module{{Path: "example.com/other", Version: "v1.0.0"}},
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBuildInfo("test.so", tt.lib, host)
+			if tt.reasons == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			ie, ok := err.(*IncompatibleError)
+			if !ok {
+				t.Fatalf("expected *IncompatibleError, got %T: %v", err, err)
+			}
+			if !reflect.DeepEqual(ie.Reasons, tt.reasons) {
+				t.Errorf("expected reasons %q, got %q", tt.reasons, ie.Reasons)
+			}
+		})
+	}
+}
+
+// copyExecutable copies the running test binary into dir with the given name
+// so that it can stand in for a compatible shared library.
+func copyExecutable(t *testing.T, dir, name string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("unable to find test executable: %s", err)
+	}
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		t.Fatalf("unable to read test executable: %s", err)
+	}
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, b, 0644); err != nil {
+		t.Fatalf("unable to copy test executable: %s", err)
+	}
+	return p
+}
+
+func TestReadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squircy3-manifest")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p := copyExecutable(t, dir, "test.so")
+
+	tests := []struct {
+		name     string
+		manifest string
+		expected *Manifest
+		err      bool
+	}{
+		{"missing manifest", "", nil, false},
+		{
+			"valid manifest",
+			`{"name": "test", "version": "1.0.0", "api_version": 1, "requires": ["vm"]}`,
+			&Manifest{Name: "test", Version: "1.0.0", APIVersion: APIVersion, Requires: []string{"vm"}},
+			false,
+		},
+		{
+			"no api version",
+			`{"name": "test"}`,
+			&Manifest{Name: "test"},
+			false,
+		},
+		{"api version mismatch", `{"name": "test", "api_version": 999}`, nil, true},
+		{"invalid manifest", `{"name": `, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(ManifestPath(p))
+			if tt.manifest != "" {
+				if err := ioutil.WriteFile(ManifestPath(p), []byte(tt.manifest), 0644); err != nil {
+					t.Fatalf("failed to write manifest: %s", err)
+				}
+			}
+			mf, err := ReadManifest(p)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected error, got manifest %v", mf)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(mf, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, mf)
+			}
+		})
+	}
+}
+
+func TestReadManifest_notGo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squircy3-manifest")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "test.so")
+	if err := ioutil.WriteFile(p, []byte("not a shared library"), 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	if err := ioutil.WriteFile(ManifestPath(p), []byte(`{"name": "test"}`), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %s", err)
+	}
+	if _, err := ReadManifest(p); err == nil {
+		t.Error("expected error reading manifest of invalid library")
+	}
+}
+
+func TestInitializeFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "squircy3-manifest")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	p := copyExecutable(t, dir, "test.so")
+	if err := ioutil.WriteFile(ManifestPath(p), []byte(`{"name": "test", "requires": ["vm"]}`), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %s", err)
+	}
+	in := InitializeFromFile(p)
+	// the manifest is read once, so removing it must not change the result
+	if err := os.Remove(ManifestPath(p)); err != nil {
+		t.Fatalf("failed to remove manifest: %s", err)
+	}
+	if n := in.(Plugin).Name(); n != "test" {
+		t.Errorf("expected name test, got %s", n)
+	}
+	if r := in.(Dependent).Requires(); !reflect.DeepEqual(r, []string{"vm"}) {
+		t.Errorf("expected requires [vm], got %v", r)
+	}
+}
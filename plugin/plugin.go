@@ -3,6 +3,8 @@ package plugin // import "code.dopame.me/veonik/squircy3/plugin"
 import (
 	"fmt"
 	"plugin"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,13 +31,78 @@ type PluginInitHandler interface {
 // when the application is shutting down.
 type ShutdownHandler interface {
 	// HandleShutdown is called when the application begins shutting down.
-	// All ShutdownHandlers are invoked concurrently, but each has a limited
-	// amount of time to gracefully clean up before the application forcefully
-	// exits.
+	// ShutdownHandlers are invoked in phases, with each handler in a phase
+	// invoked concurrently, but each has a limited amount of time to
+	// gracefully clean up before the application forcefully exits.
 	HandleShutdown()
 	Name() string
 }
 
+// DefaultShutdownTimeout is the amount of time each ShutdownHandler has to
+// complete unless otherwise configured.
+const DefaultShutdownTimeout = 2 * time.Second
+
+// A TimedShutdownHandler is a ShutdownHandler that specifies how much time
+// it needs to gracefully shut down.
+type TimedShutdownHandler interface {
+	ShutdownHandler
+	// ShutdownTimeout returns the maximum amount of time to wait for the
+	// handler to complete. If it returns zero, the Manager's timeout is used.
+	ShutdownTimeout() time.Duration
+}
+
+// A ShutdownTimeoutError is returned by Shutdown when one or more
+// ShutdownHandlers did not complete within their timeout.
+type ShutdownTimeoutError struct {
+	// TimedOut contains the names of the handlers that timed out.
+	TimedOut []string
+}
+
+func (e *ShutdownTimeoutError) Error() string {
+	return fmt.Sprintf("shutdown timed out for (%s)", strings.Join(e.TimedOut, ", "))
+}
+
+// An UnloadHandler is implemented by plugins that want to perform some
+// action when they are unloaded.
+type UnloadHandler interface {
+	// HandleUnload is called after the plugin is removed from the Manager.
+	// Plugins should release any resources they hold, such as network
+	// connections, when this method is called.
+	HandleUnload()
+}
+
+// PluginUnloadHandler is implemented by types that want to be notified when
+// another plugin is unloaded.
+type PluginUnloadHandler interface {
+	// HandlePluginUnload is called after each other Plugin is unloaded.
+	HandlePluginUnload(Plugin)
+}
+
+// A Status describes the current state of a loaded plugin.
+type Status struct {
+	// Name is the name of the plugin.
+	Name string
+	// Configured is true if the plugin has been successfully configured.
+	Configured bool
+	// Connected is true if the plugin has an active connection to a remote
+	// service. It is always false for plugins without a connection.
+	Connected bool
+	// LastError is the most recent error encountered by the plugin, if any.
+	LastError error
+	// Uptime is the amount of time since the plugin was loaded.
+	Uptime time.Duration
+	// Details contains additional, plugin-specific information.
+	Details map[string]interface{}
+}
+
+// A StatusReporter is implemented by plugins that can report on their
+// current state.
+type StatusReporter interface {
+	// Status returns the current status of the plugin.
+	// The Name and Uptime fields are populated by the Manager.
+	Status() Status
+}
+
 // An Initializer is a type that can create a Plugin implementation.
 type Initializer interface {
 	// Initialize creates the corresponding Plugin for this Initializer.
@@ -49,6 +116,52 @@ func (f InitializerFunc) Initialize(m *Manager) (Plugin, error) {
 	return f(m)
 }
 
+// A Dependent is an Initializer that requires other plugins to be loaded
+// before it is initialized.
+//
+// The Manager defers the initialization of a Dependent until all of its
+// required plugins are loaded, regardless of the order in which the
+// Initializers were registered.
+type Dependent interface {
+	// Requires returns the names of the plugins that must be loaded first.
+	Requires() []string
+}
+
+type dependentInitializer struct {
+	Initializer
+
+	name     string
+	requires []string
+}
+
+func (d dependentInitializer) Name() string {
+	return d.name
+}
+
+func (d dependentInitializer) Requires() []string {
+	return d.requires
+}
+
+// InitializeWithRequires returns an Initializer that creates the named
+// plugin using fn once all of the required plugins have been loaded.
+func InitializeWithRequires(name string, fn func(*Manager) (Plugin, error), requires ...string) Initializer {
+	return dependentInitializer{InitializerFunc(fn), name, requires}
+}
+
+type fileInitializer struct {
+	path string
+
+	// manifest and err are the result of reading the library's manifest
+	// when the initializer was created.
+	manifest *Manifest
+	err      error
+
+	// requires contains the requirements declared by a library without a
+	// manifest, looked up at most once.
+	requires     []string
+	requiresOnce sync.Once
+}
+
 // InitializeFromFile returns an Initializer that will attempt to load the
 // specified plugin shared library from the filesystem.
 // Shared library plugins must be built as a `main` package and must have
@@ -56,26 +169,112 @@ func (f InitializerFunc) Initialize(m *Manager) (Plugin, error) {
 // function must be compatible with the Initialize method on the Initializer
 // interface. That is, it must have the signature:
 //   func Initialize(*Manager) (Plugin, error)
+// Shared library plugins should also have a manifest file, which is read
+// once when the Initializer is created and is used to check compatibility
+// and determine the plugin's requirements before the library is loaded. See
+// Manifest for details. Shared library plugins without a manifest may
+// instead define a "Requires" function with the signature:
+//   func Requires() []string
+// Either way, the plugin will not be initialized until the plugins it
+// requires are loaded.
 func InitializeFromFile(p string) Initializer {
-	return InitializerFunc(func(m *Manager) (Plugin, error) {
-		pl, err := plugin.Open(p)
+	mf, err := ReadManifest(p)
+	return &fileInitializer{path: p, manifest: mf, err: err}
+}
+
+func (f *fileInitializer) String() string {
+	return f.path
+}
+
+// Name returns the name in the shared library's manifest, or its path if
+// the library has no valid manifest.
+func (f *fileInitializer) Name() string {
+	if f.manifest == nil || f.manifest.Name == "" {
+		return f.path
+	}
+	return f.manifest.Name
+}
+
+// Requires returns the names of the plugins required by the shared library,
+// or nil if the library does not declare any valid requirements.
+// The library is loaded only if it has no manifest.
+func (f *fileInitializer) Requires() []string {
+	if f.err != nil {
+		// the error will be reported when the plugin is initialized
+		return nil
+	}
+	if f.manifest != nil {
+		return f.manifest.Requires
+	}
+	f.requiresOnce.Do(func() {
+		pl, err := plugin.Open(f.path)
 		if err != nil {
-			return nil, errors.Wrapf(err, "unable (%s) to open plugin", p)
+			return
 		}
-		in, err := pl.Lookup("Initialize")
+		in, err := pl.Lookup("Requires")
 		if err != nil {
-			return nil, errors.Wrapf(err, "plugin (%s) does not export Initialize", p)
+			return
 		}
-		fn, ok := in.(func(*Manager) (Plugin, error))
+		fn, ok := in.(func() []string)
 		if !ok {
-			return nil, errors.Errorf("plugin (%s) has invalid type for Initialize: expected func(*plugin.Manager) (plugin.Plugin, error), got %T", p, in)
+			logrus.Warnf("plugin (%s) has invalid type for Requires: expected func() []string, got %T", f.path, in)
+			return
 		}
-		plg, err := fn(m)
-		if err != nil {
-			return nil, errors.Wrapf(err, "plugin (%s) init failed", p)
-		}
-		return plg, nil
+		f.requires = fn()
 	})
+	return f.requires
+}
+
+func (f *fileInitializer) Initialize(m *Manager) (Plugin, error) {
+	p := f.path
+	if f.err != nil {
+		return nil, f.err
+	}
+	pl, err := plugin.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable (%s) to open plugin", p)
+	}
+	in, err := pl.Lookup("Initialize")
+	if err != nil {
+		return nil, errors.Wrapf(err, "plugin (%s) does not export Initialize", p)
+	}
+	fn, ok := in.(func(*Manager) (Plugin, error))
+	if !ok {
+		return nil, errors.Errorf("plugin (%s) has invalid type for Initialize: expected func(*plugin.Manager) (plugin.Plugin, error), got %T", p, in)
+	}
+	plg, err := fn(m)
+	if err != nil {
+		return nil, errors.Wrapf(err, "plugin (%s) init failed", p)
+	}
+	if mf := f.manifest; mf != nil && mf.Name != "" && mf.Name != plg.Name() {
+		logrus.Warnf("plugin (%s) manifest name %s does not match plugin name %s", p, mf.Name, plg.Name())
+	}
+	return plg, nil
+}
+
+// A MissingDependencyError is returned by Configure when a plugin requires
+// other plugins that could not be loaded.
+type MissingDependencyError struct {
+	// Plugin is the name of the plugin that could not be initialized.
+	Plugin string
+	// Missing contains the names of the required plugins that are not loaded.
+	Missing []string
+}
+
+func (e *MissingDependencyError) Error() string {
+	return fmt.Sprintf("plugin (%s) required dependency missing (%s)", e.Plugin, strings.Join(e.Missing, ", "))
+}
+
+// A DependencyCycleError is returned by Configure when two or more plugins
+// require each other, directly or indirectly.
+type DependencyCycleError struct {
+	// Cycle contains the names of the plugins in the cycle, starting and
+	// ending with the same plugin.
+	Cycle []string
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("plugin dependency cycle detected (%s)", strings.Join(e.Cycle, " -> "))
 }
 
 // A Manager controls the loading and configuration of plugins.
@@ -83,10 +282,25 @@ type Manager struct {
 	plugins []Initializer
 
 	loaded map[string]Plugin
+	// initializers contains the Initializer used to create each loaded plugin.
+	initializers map[string]Initializer
+	// loadedAt contains the time each plugin was loaded.
+	loadedAt map[string]time.Time
+	// failed contains each plugin that was unloaded by Reload but could not
+	// be initialized again.
+	failed map[string]failedPlugin
 
 	onInit     []PluginInitHandler
 	onShutdown []ShutdownHandler
 
+	// shutdownTimeout is the default amount of time for each ShutdownHandler.
+	shutdownTimeout time.Duration
+
+	services map[string]service
+	// injections contains the injections for each service, performed each
+	// time the service is provided or withdrawn.
+	injections map[string][]func(interface{})
+
 	mu sync.RWMutex
 }
 
@@ -96,8 +310,15 @@ func NewManager(plugins ...string) *Manager {
 		plgs[i] = InitializeFromFile(n)
 	}
 	return &Manager{
-		plugins: plgs,
-		loaded:  make(map[string]Plugin),
+		plugins:      plgs,
+		loaded:       make(map[string]Plugin),
+		initializers: make(map[string]Initializer),
+		loadedAt:     make(map[string]time.Time),
+		failed:       make(map[string]failedPlugin),
+		services:     make(map[string]service),
+		injections:   make(map[string][]func(interface{})),
+
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
 }
 
@@ -118,33 +339,124 @@ func (m *Manager) OnShutdown(h ShutdownHandler) {
 }
 
 // Shutdown begins the shut down process.
-// Each ShutdownHandler is called concurrently and this method returns after
-// all handlers have completed.
-func (m *Manager) Shutdown() {
+// ShutdownHandlers are called in phases derived from plugin dependencies: a
+// plugin is shut down before the plugins it requires. Handlers within the
+// same phase are called concurrently, and each phase begins after all the
+// handlers in the previous phase have completed or timed out.
+// A *ShutdownTimeoutError is returned if any handler timed out.
+func (m *Manager) Shutdown() error {
 	m.mu.RLock()
 	hs := make([]ShutdownHandler, len(m.onShutdown))
 	copy(hs, m.onShutdown)
+	phases := m.shutdownPhases(hs)
+	timeout := m.shutdownTimeout
 	m.mu.RUnlock()
+	var timedOut []string
+	for _, phase := range phases {
+		timedOut = append(timedOut, shutdownPhase(phase, timeout)...)
+	}
+	if len(timedOut) > 0 {
+		return &ShutdownTimeoutError{TimedOut: timedOut}
+	}
+	return nil
+}
+
+// shutdownPhases groups the given handlers into the order they should be
+// shut down.
+// Handlers for plugins with the most levels of requirements are shut down
+// first; handlers that do not belong to a loaded plugin are placed in the
+// first phase.
+// The Manager must be locked when calling this method.
+func (m *Manager) shutdownPhases(hs []ShutdownHandler) [][]ShutdownHandler {
+	depths := make(map[string]int)
+	var depth func(n string, seen map[string]bool) int
+	depth = func(n string, seen map[string]bool) int {
+		if d, ok := depths[n]; ok {
+			return d
+		}
+		if seen[n] {
+			// guard against cycles, though they should never be loaded.
+			return 0
+		}
+		seen[n] = true
+		res := 0
+		if d, ok := m.initializers[n].(Dependent); ok {
+			for _, r := range d.Requires() {
+				if _, ok := m.loaded[r]; !ok {
+					continue
+				}
+				if rd := depth(r, seen) + 1; rd > res {
+					res = rd
+				}
+			}
+		}
+		depths[n] = res
+		return res
+	}
+	max := 0
+	for n := range m.loaded {
+		if d := depth(n, make(map[string]bool)); d > max {
+			max = d
+		}
+	}
+	phases := make([][]ShutdownHandler, max+1)
+	for _, h := range hs {
+		i := 0
+		if d, ok := depths[h.Name()]; ok {
+			i = max - d
+		}
+		phases[i] = append(phases[i], h)
+	}
+	return phases
+}
+
+// shutdownPhase calls each handler concurrently and returns the names of
+// the handlers that did not complete in time.
+func shutdownPhase(hs []ShutdownHandler, timeout time.Duration) []string {
+	var timedOut []string
+	mu := &sync.Mutex{}
 	wg := &sync.WaitGroup{}
-	for _, h := range m.onShutdown {
+	for _, h := range hs {
 		wg.Add(1)
-		wait := make(chan struct{})
-		go func(sh ShutdownHandler) {
-			sh.HandleShutdown()
-			close(wait)
-		}(h)
 		go func(sh ShutdownHandler) {
+			defer wg.Done()
+			t := timeout
+			if th, ok := sh.(TimedShutdownHandler); ok {
+				if d := th.ShutdownTimeout(); d > 0 {
+					t = d
+				}
+			}
+			wait := make(chan struct{})
+			go func() {
+				sh.HandleShutdown()
+				close(wait)
+			}()
 			select {
 			case <-wait:
 				break
-			case <-time.After(2 * time.Second):
-				logrus.Warnln("shutdown of", sh.Name(), "timed out after 2 seconds")
-				break
+			case <-time.After(t):
+				logrus.Warnln("shutdown of", sh.Name(), "timed out after", t)
+				mu.Lock()
+				timedOut = append(timedOut, sh.Name())
+				mu.Unlock()
 			}
-			wg.Done()
 		}(h)
 	}
 	wg.Wait()
+	sort.Strings(timedOut)
+	return timedOut
+}
+
+// SetShutdownTimeout sets the amount of time each ShutdownHandler has to
+// complete, unless it specifies its own timeout.
+// If d is zero or less, DefaultShutdownTimeout is used.
+func (m *Manager) SetShutdownTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultShutdownTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shutdownTimeout = d
 }
 
 // Loaded returns a list of plugins currently loaded.
@@ -158,6 +470,39 @@ func (m *Manager) Loaded() []string {
 	return ns
 }
 
+// Status returns the status of each loaded plugin, sorted by name.
+// Plugins that do not implement StatusReporter are reported as configured.
+// Plugins that failed to initialize when reloaded are also included; they
+// are reported as not configured, with the reason as the LastError.
+func (m *Manager) Status() []Status {
+	m.mu.RLock()
+	plgs := make([]Plugin, 0, len(m.loaded))
+	loadedAt := make(map[string]time.Time, len(m.loadedAt))
+	for n, plg := range m.loaded {
+		plgs = append(plgs, plg)
+		loadedAt[n] = m.loadedAt[n]
+	}
+	res := make([]Status, 0, len(plgs)+len(m.failed))
+	for n, f := range m.failed {
+		res = append(res, Status{Name: n, LastError: f.err})
+	}
+	m.mu.RUnlock()
+	for _, plg := range plgs {
+		st := Status{Configured: true}
+		if sr, ok := plg.(StatusReporter); ok {
+			// plugin is not locked while it reports its status
+			st = sr.Status()
+		}
+		st.Name = plg.Name()
+		st.Uptime = time.Since(loadedAt[st.Name])
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
 // Lookup returns the given plugin by name, or an error if it isn't loaded.
 func (m *Manager) Lookup(name string) (Plugin, error) {
 	m.mu.RLock()
@@ -183,58 +528,325 @@ func (m *Manager) RegisterFunc(initfn func(m *Manager) (Plugin, error)) {
 }
 
 // Configure attempts to load and configure all registered plugins.
-// An error will be returned for each failed initialization.
+// Plugins are initialized in the order they were registered, except that
+// an Initializer implementing Dependent is deferred until all the plugins it
+// requires are loaded.
+// An error will be returned for each failed initialization, including a
+// MissingDependencyError or DependencyCycleError for each plugin whose
+// requirements could not be satisfied.
 func (m *Manager) Configure() []error {
 	var errs []error
 	m.mu.Lock()
-	// copy the pointer to the current plugins slice
-	plugins := m.plugins
+	// copy the current plugins slice
+	pending := append([]Initializer{}, m.plugins...)
 	// and reset the list of pending plugin inits on the Manager.
 	m.plugins = nil
 	m.mu.Unlock()
-	if len(plugins) == 0 {
-		return errs
-	}
-	for _, p := range plugins {
-		m.mu.RLock()
-		// get a fresh copy of init handlers before each init;
-		// plugins may add handlers in this loop and those should be accounted
-		// for on subsequent inits.
-		inits := append([]PluginInitHandler{}, m.onInit...)
-		m.mu.RUnlock()
-		// Manager should be unlocked while the plugin initializes; the plugin
-		// is free to use the Manager itself during init.
-		plg, err := p.Initialize(m)
-		if err != nil {
-			errs = append(errs, errors.Wrapf(err, "plugin (unknown) init failed"))
+	for len(pending) > 0 {
+		i := m.nextReady(pending)
+		if i < 0 {
+			// nothing else can be initialized
+			break
+		}
+		p := pending[i]
+		pending = append(pending[:i], pending[i+1:]...)
+		if err := m.initialize(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(pending) > 0 {
+		errs = append(errs, m.unresolved(pending)...)
+	}
+	return errs
+}
+
+// nextReady returns the index of the first Initializer whose requirements
+// are all loaded, or -1 if there is none.
+func (m *Manager) nextReady(pending []Initializer) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i, p := range pending {
+		if len(m.missing(p)) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// missing returns the requirements of the Initializer that are not loaded.
+// The Manager must be locked when calling this method.
+func (m *Manager) missing(p Initializer) []string {
+	d, ok := p.(Dependent)
+	if !ok {
+		return nil
+	}
+	var res []string
+	for _, n := range d.Requires() {
+		if _, ok := m.loaded[n]; !ok {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+// unresolved returns an error for each Initializer that could not be
+// initialized because of its requirements.
+func (m *Manager) unresolved(pending []Initializer) []error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var names []string
+	deps := make(map[string][]string)
+	for _, p := range pending {
+		n := initializerName(p)
+		if _, ok := p.(Plugin); !ok {
+			// only named Initializers can be part of a cycle
 			continue
 		}
-		pn := plg.Name()
-		m.mu.Lock()
-		_, ok := m.loaded[pn]
-		if !ok {
-			// not already loaded, add it
-			m.loaded[pn] = plg
-			if ih, ok := plg.(PluginInitHandler); ok {
-				m.onInit = append(m.onInit, ih)
+		if _, ok := deps[n]; !ok {
+			names = append(names, n)
+		}
+		deps[n] = m.missing(p)
+	}
+	var errs []error
+	inCycle := make(map[string]bool)
+	for _, c := range findCycles(names, deps) {
+		for _, n := range c {
+			inCycle[n] = true
+		}
+		errs = append(errs, &DependencyCycleError{Cycle: c})
+	}
+	for _, p := range pending {
+		n := initializerName(p)
+		if inCycle[n] {
+			continue
+		}
+		errs = append(errs, &MissingDependencyError{Plugin: n, Missing: m.missing(p)})
+	}
+	return errs
+}
+
+// findCycles returns each cycle found in the given dependency graph.
+func findCycles(names []string, deps map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycles [][]string
+	var visit func(n string)
+	visit = func(n string) {
+		state[n] = visiting
+		stack = append(stack, n)
+		for _, d := range deps[n] {
+			if _, ok := deps[d]; !ok {
+				continue
 			}
-			if sh, ok := plg.(ShutdownHandler); ok {
-				m.onShutdown = append(m.onShutdown, sh)
+			switch state[d] {
+			case unvisited:
+				visit(d)
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == d {
+						c := append(append([]string{}, stack[i:]...), d)
+						cycles = append(cycles, c)
+						break
+					}
+				}
 			}
 		}
-		// unlock outside of any conditional
+		stack = stack[:len(stack)-1]
+		state[n] = visited
+	}
+	for _, n := range names {
+		if state[n] == unvisited {
+			visit(n)
+		}
+	}
+	return cycles
+}
+
+// initializerName returns a name suitable for identifying the Initializer.
+func initializerName(p Initializer) string {
+	switch v := p.(type) {
+	case Plugin:
+		return v.Name()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return "unknown"
+}
+
+// initialize initializes and loads the plugin created by the Initializer.
+func (m *Manager) initialize(p Initializer) error {
+	m.mu.RLock()
+	// get a fresh copy of init handlers before each init;
+	// plugins may add handlers during init and those should be accounted
+	// for on subsequent inits.
+	inits := append([]PluginInitHandler{}, m.onInit...)
+	m.mu.RUnlock()
+	// Manager should be unlocked while the plugin initializes; the plugin
+	// is free to use the Manager itself during init.
+	plg, err := p.Initialize(m)
+	if err != nil {
+		return errors.Wrapf(err, "plugin (%s) init failed", initializerName(p))
+	}
+	pn := plg.Name()
+	m.mu.Lock()
+	_, ok := m.loaded[pn]
+	if !ok {
+		// not already loaded, add it
+		m.loaded[pn] = plg
+		m.initializers[pn] = p
+		m.loadedAt[pn] = time.Now()
+		if ih, ok := plg.(PluginInitHandler); ok {
+			m.onInit = append(m.onInit, ih)
+		}
+		if sh, ok := plg.(ShutdownHandler); ok {
+			m.onShutdown = append(m.onShutdown, sh)
+		}
+	}
+	// unlock outside of any conditional
+	m.mu.Unlock()
+	if ok {
+		// plugin was already loaded
+		return errors.Errorf("plugin (%s) already loaded", pn)
+	}
+	// run other plugin init handlers
+	for _, h := range inits {
+		h.HandlePluginInit(plg)
+	}
+	// publish services after the plugin is configured by the init handlers
+	if sp, ok := plg.(ServiceProvider); ok {
+		for n, svc := range sp.Services() {
+			if isNil(svc) {
+				continue
+			}
+			if err := m.provide(pn, n, svc); err != nil {
+				return errors.Wrapf(err, "plugin (%s) failed to provide service", pn)
+			}
+		}
+	}
+	return nil
+}
+
+// Unload removes the named plugin from the Manager.
+// An error is returned if the plugin is not loaded or if another loaded
+// plugin requires it.
+// If the plugin implements UnloadHandler, its HandleUnload method is called
+// after it is removed, then each PluginUnloadHandler is notified.
+// Unloading a plugin that failed to reload only removes its failed status.
+func (m *Manager) Unload(name string) error {
+	m.mu.Lock()
+	plg, ok := m.loaded[name]
+	if !ok {
+		_, failed := m.failed[name]
+		delete(m.failed, name)
 		m.mu.Unlock()
-		if ok {
-			// plugin was already loaded
-			errs = append(errs, errors.Errorf("plugin (%s) already loaded", pn))
+		if failed {
+			return nil
+		}
+		return errors.Errorf("no plugin named %s", name)
+	}
+	if ds := m.dependents(name); len(ds) > 0 {
+		m.mu.Unlock()
+		return errors.Errorf("plugin (%s) is required by (%s)", name, strings.Join(ds, ", "))
+	}
+	delete(m.loaded, name)
+	delete(m.initializers, name)
+	delete(m.loadedAt, name)
+	notify := m.withdraw(name)
+	var inits []PluginInitHandler
+	for _, h := range m.onInit {
+		if p, ok := h.(Plugin); ok && p.Name() == name {
 			continue
 		}
-		// run other plugin init handlers
-		for _, h := range inits {
-			h.HandlePluginInit(plg)
+		inits = append(inits, h)
+	}
+	m.onInit = inits
+	var shutdowns []ShutdownHandler
+	for _, h := range m.onShutdown {
+		if h.Name() == name {
+			continue
 		}
+		shutdowns = append(shutdowns, h)
 	}
-	return errs
+	m.onShutdown = shutdowns
+	var unloads []PluginUnloadHandler
+	for _, h := range m.onInit {
+		if uh, ok := h.(PluginUnloadHandler); ok {
+			unloads = append(unloads, uh)
+		}
+	}
+	m.mu.Unlock()
+	notify()
+	if uh, ok := plg.(UnloadHandler); ok {
+		uh.HandleUnload()
+	}
+	for _, h := range unloads {
+		h.HandlePluginUnload(plg)
+	}
+	return nil
+}
+
+// Reload unloads the named plugin and initializes it again using the same
+// Initializer that originally created it.
+//
+// If the plugin cannot be initialized again, it remains unloaded and the
+// error is reported by Status until the plugin is reloaded successfully or
+// unloaded. A plugin that failed to reload can be reloaded again.
+func (m *Manager) Reload(name string) error {
+	m.mu.RLock()
+	in, ok := m.initializers[name]
+	f, failed := m.failed[name]
+	m.mu.RUnlock()
+	if !ok && !failed {
+		return errors.Errorf("no plugin named %s", name)
+	}
+	if ok {
+		if err := m.Unload(name); err != nil {
+			return err
+		}
+	} else {
+		in = f.initializer
+	}
+	err := m.initialize(in)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.loaded[name]; ok {
+		delete(m.failed, name)
+	} else if err != nil {
+		m.failed[name] = failedPlugin{initializer: in, err: err}
+	}
+	return err
+}
+
+// A failedPlugin is a plugin that could not be initialized when reloaded.
+type failedPlugin struct {
+	initializer Initializer
+	err         error
+}
+
+// dependents returns the names of the loaded plugins that require the named
+// plugin.
+// The Manager must be locked when calling this method.
+func (m *Manager) dependents(name string) []string {
+	var res []string
+	for n, in := range m.initializers {
+		d, ok := in.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, r := range d.Requires() {
+			if r == name {
+				res = append(res, n)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
 }
 
 // Main is a helper function that can be used to provide a consistent main
@@ -0,0 +1,391 @@
+package plugin_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dopame.me/veonik/squircy3/plugin"
+)
+
+type testPlugin struct {
+	name string
+}
+
+func (p testPlugin) Name() string {
+	return p.name
+}
+
+// initializer returns an Initializer for a testPlugin with the given name
+// that records the order of initialization in order.
+func initializer(name string, order *[]string, requires ...string) plugin.Initializer {
+	return plugin.InitializeWithRequires(name, func(*plugin.Manager) (plugin.Plugin, error) {
+		*order = append(*order, name)
+		return testPlugin{name}, nil
+	}, requires...)
+}
+
+func TestManager_ConfigureDependencyOrder(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("c", &order, "b"))
+	m.Register(initializer("b", &order, "a"))
+	m.Register(initializer("d", &order))
+	m.Register(initializer("a", &order))
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	expected := []string{"d", "a", "b", "c"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected plugins to be initialized in order %v, got %v", expected, order)
+	}
+}
+
+func TestManager_ConfigureAlreadyLoadedDependency(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("a", &order))
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	m.Register(initializer("b", &order, "a"))
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if _, err := m.Lookup("b"); err != nil {
+		t.Errorf("expected b to be loaded: %s", err)
+	}
+}
+
+func TestManager_ConfigureMissingDependency(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("a", &order, "missing"))
+	m.Register(initializer("b", &order))
+	errs := m.Configure()
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+	}
+	err, ok := errs[0].(*plugin.MissingDependencyError)
+	if !ok {
+		t.Fatalf("expected *plugin.MissingDependencyError, got %T: %s", errs[0], errs[0])
+	}
+	if err.Plugin != "a" || !reflect.DeepEqual(err.Missing, []string{"missing"}) {
+		t.Errorf("unexpected error contents: %s", err)
+	}
+	if _, err := m.Lookup("b"); err != nil {
+		t.Errorf("expected b to be loaded: %s", err)
+	}
+}
+
+func TestManager_ConfigureDependencyCycle(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("a", &order, "b"))
+	m.Register(initializer("b", &order, "c"))
+	m.Register(initializer("c", &order, "a"))
+	m.Register(initializer("d", &order, "a"))
+	errs := m.Configure()
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	cerr, ok := errs[0].(*plugin.DependencyCycleError)
+	if !ok {
+		t.Fatalf("expected *plugin.DependencyCycleError, got %T: %s", errs[0], errs[0])
+	}
+	expected := []string{"a", "b", "c", "a"}
+	if !reflect.DeepEqual(cerr.Cycle, expected) {
+		t.Errorf("expected cycle %v, got %v", expected, cerr.Cycle)
+	}
+	if _, ok := errs[1].(*plugin.MissingDependencyError); !ok {
+		t.Errorf("expected *plugin.MissingDependencyError, got %T: %s", errs[1], errs[1])
+	}
+	if len(order) > 0 {
+		t.Errorf("expected no plugins to be initialized, got %v", order)
+	}
+}
+
+type unloadablePlugin struct {
+	name string
+
+	unloaded chan struct{}
+	shutdown chan struct{}
+}
+
+func newUnloadablePlugin(name string) *unloadablePlugin {
+	return &unloadablePlugin{name, make(chan struct{}, 1), make(chan struct{}, 1)}
+}
+
+func (p *unloadablePlugin) Name() string {
+	return p.name
+}
+
+func (p *unloadablePlugin) HandleUnload() {
+	p.unloaded <- struct{}{}
+}
+
+func (p *unloadablePlugin) HandleShutdown() {
+	p.shutdown <- struct{}{}
+}
+
+type watcherPlugin struct {
+	unloads []string
+}
+
+func (p *watcherPlugin) Name() string {
+	return "watcher"
+}
+
+func (p *watcherPlugin) HandlePluginInit(plugin.Plugin) {}
+
+func (p *watcherPlugin) HandlePluginUnload(o plugin.Plugin) {
+	p.unloads = append(p.unloads, o.Name())
+}
+
+func TestManager_Unload(t *testing.T) {
+	w := &watcherPlugin{}
+	p := newUnloadablePlugin("a")
+	m := plugin.NewManager()
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return w, nil
+	})
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return p, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Unload("a"); err != nil {
+		t.Fatalf("unexpected error unloading plugin: %s", err)
+	}
+	select {
+	case <-p.unloaded:
+	default:
+		t.Errorf("expected HandleUnload to be called")
+	}
+	if !reflect.DeepEqual(w.unloads, []string{"a"}) {
+		t.Errorf("expected watcher to be notified of unload, got %v", w.unloads)
+	}
+	if _, err := m.Lookup("a"); err == nil {
+		t.Errorf("expected a to no longer be loaded")
+	}
+	m.Shutdown()
+	select {
+	case <-p.shutdown:
+		t.Errorf("did not expect HandleShutdown to be called on unloaded plugin")
+	default:
+	}
+	if err := m.Unload("a"); err == nil {
+		t.Errorf("expected error unloading plugin that is not loaded")
+	}
+}
+
+func TestManager_UnloadRequired(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("a", &order))
+	m.Register(initializer("b", &order, "a"))
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Unload("a"); err == nil {
+		t.Errorf("expected error unloading plugin required by another plugin")
+	}
+	if err := m.Unload("b"); err != nil {
+		t.Fatalf("unexpected error unloading plugin: %s", err)
+	}
+	if err := m.Unload("a"); err != nil {
+		t.Fatalf("unexpected error unloading plugin: %s", err)
+	}
+}
+
+func TestManager_Reload(t *testing.T) {
+	var ps []*unloadablePlugin
+	m := plugin.NewManager()
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		p := newUnloadablePlugin("a")
+		ps = append(ps, p)
+		return p, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Reload("a"); err != nil {
+		t.Fatalf("unexpected error reloading plugin: %s", err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("expected plugin to be initialized twice, got %d", len(ps))
+	}
+	select {
+	case <-ps[0].unloaded:
+	default:
+		t.Errorf("expected HandleUnload to be called on the original plugin")
+	}
+	plg, err := m.Lookup("a")
+	if err != nil {
+		t.Fatalf("expected a to be loaded: %s", err)
+	}
+	if plg != ps[1] {
+		t.Errorf("expected the reloaded plugin to be loaded")
+	}
+	m.Shutdown()
+	select {
+	case <-ps[1].shutdown:
+	default:
+		t.Errorf("expected HandleShutdown to be called on the reloaded plugin")
+	}
+}
+
+func TestManager_ReloadFailed(t *testing.T) {
+	var fail error
+	m := plugin.NewManager()
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		if fail != nil {
+			return nil, fail
+		}
+		return newUnloadablePlugin("a"), nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	fail = errors.New("oops")
+	if err := m.Reload("a"); err == nil {
+		t.Fatalf("expected error reloading plugin")
+	}
+	st := m.Status()
+	if len(st) != 1 || st[0].Name != "a" || st[0].Configured || st[0].LastError == nil {
+		t.Fatalf("expected status to report the failed plugin, got %+v", st)
+	}
+	if !strings.Contains(st[0].LastError.Error(), "oops") {
+		t.Errorf("expected the init error to be reported, got %s", st[0].LastError)
+	}
+	// a failed plugin can be reloaded again
+	fail = nil
+	if err := m.Reload("a"); err != nil {
+		t.Fatalf("unexpected error reloading plugin: %s", err)
+	}
+	if _, err := m.Lookup("a"); err != nil {
+		t.Fatalf("expected a to be loaded: %s", err)
+	}
+	if st := m.Status(); len(st) != 1 || !st[0].Configured || st[0].LastError != nil {
+		t.Errorf("expected status to report the reloaded plugin, got %+v", st)
+	}
+	// unloading a failed plugin removes its status
+	fail = errors.New("oops")
+	if err := m.Reload("a"); err == nil {
+		t.Fatalf("expected error reloading plugin")
+	}
+	if err := m.Unload("a"); err != nil {
+		t.Fatalf("unexpected error unloading failed plugin: %s", err)
+	}
+	if st := m.Status(); len(st) != 0 {
+		t.Errorf("expected no status, got %+v", st)
+	}
+	if err := m.Reload("a"); err == nil {
+		t.Errorf("expected error reloading unloaded plugin")
+	}
+}
+
+type reportingPlugin struct {
+	err error
+}
+
+func (p reportingPlugin) Name() string {
+	return "reporting"
+}
+
+func (p reportingPlugin) Status() plugin.Status {
+	return plugin.Status{Name: "ignored", Connected: true, LastError: p.err}
+}
+
+func TestManager_Status(t *testing.T) {
+	var order []string
+	m := plugin.NewManager()
+	m.Register(initializer("plain", &order))
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return reportingPlugin{errors.New("oops")}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	st := m.Status()
+	if len(st) != 2 {
+		t.Fatalf("expected 2 statuses, got %d", len(st))
+	}
+	if st[0].Name != "plain" || !st[0].Configured || st[0].Connected {
+		t.Errorf("unexpected status for plain plugin: %+v", st[0])
+	}
+	if st[1].Name != "reporting" || st[1].Configured || !st[1].Connected || st[1].LastError == nil {
+		t.Errorf("unexpected status for reporting plugin: %+v", st[1])
+	}
+}
+
+type shutdownPlugin struct {
+	name  string
+	order chan string
+	block chan struct{}
+}
+
+func (p shutdownPlugin) Name() string {
+	return p.name
+}
+
+func (p shutdownPlugin) HandleShutdown() {
+	if p.block != nil {
+		<-p.block
+	}
+	p.order <- p.name
+}
+
+func (p shutdownPlugin) ShutdownTimeout() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func TestManager_ShutdownPhases(t *testing.T) {
+	order := make(chan string, 3)
+	m := plugin.NewManager()
+	for _, v := range [][]string{{"top", "mid"}, {"mid", "base"}, {"base"}} {
+		p := shutdownPlugin{name: v[0], order: order}
+		m.Register(plugin.InitializeWithRequires(v[0], func(*plugin.Manager) (plugin.Plugin, error) {
+			return p, nil
+		}, v[1:]...))
+	}
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("unexpected error shutting down: %s", err)
+	}
+	close(order)
+	var res []string
+	for n := range order {
+		res = append(res, n)
+	}
+	expected := []string{"top", "mid", "base"}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected plugins to shut down in order %v, got %v", expected, res)
+	}
+}
+
+func TestManager_ShutdownTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	m := plugin.NewManager()
+	m.SetShutdownTimeout(time.Hour)
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return shutdownPlugin{name: "slow", order: make(chan string, 1), block: block}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	err := m.Shutdown()
+	terr, ok := err.(*plugin.ShutdownTimeoutError)
+	if !ok {
+		t.Fatalf("expected *plugin.ShutdownTimeoutError, got %T: %v", err, err)
+	}
+	if !reflect.DeepEqual(terr.TimedOut, []string{"slow"}) {
+		t.Errorf("expected slow to time out, got %v", terr.TimedOut)
+	}
+}
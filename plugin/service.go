@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// A ServiceProvider is implemented by plugins that publish services for use
+// by other plugins.
+type ServiceProvider interface {
+	// Services returns the services to publish, keyed by name.
+	// This method is called after the plugin is loaded and all
+	// PluginInitHandlers have run, so the plugin will be configured.
+	// It is called again each time the plugin is reloaded, and the
+	// services are injected again into their consumers.
+	// Services with a nil value are not published.
+	Services() map[string]interface{}
+}
+
+// A ServiceNotFoundError is returned when resolving a service that has not
+// been provided.
+type ServiceNotFoundError struct {
+	Name string
+}
+
+func (e *ServiceNotFoundError) Error() string {
+	return fmt.Sprintf("no service named %s; the providing plugin may not be loaded or configured", e.Name)
+}
+
+// A ServiceTypeError is returned when a service cannot be assigned to the
+// target it is being resolved into.
+type ServiceTypeError struct {
+	Name     string
+	Expected reflect.Type
+	Actual   reflect.Type
+}
+
+func (e *ServiceTypeError) Error() string {
+	return fmt.Sprintf("service (%s) has unexpected type: expected %s, got %s", e.Name, e.Expected, e.Actual)
+}
+
+type service struct {
+	value interface{}
+	// provider is the name of the plugin that provided the service, or
+	// empty if it was provided directly.
+	provider string
+}
+
+// Provide publishes the given service with the given name.
+// Each injection for the service is performed before this method returns.
+func (m *Manager) Provide(name string, svc interface{}) error {
+	return m.provide("", name, svc)
+}
+
+func (m *Manager) provide(provider, name string, svc interface{}) error {
+	if isNil(svc) {
+		return errors.Errorf("service (%s) cannot be nil", name)
+	}
+	m.mu.Lock()
+	if _, ok := m.services[name]; ok {
+		m.mu.Unlock()
+		return errors.Errorf("service (%s) already provided", name)
+	}
+	m.services[name] = service{value: svc, provider: provider}
+	injections := append([]func(interface{}){}, m.injections[name]...)
+	m.mu.Unlock()
+	for _, fn := range injections {
+		fn(svc)
+	}
+	return nil
+}
+
+// withdraw removes all services provided by the named plugin, returning a
+// function that notifies the injections for each withdrawn service.
+// The Manager must be locked when calling this method, and the returned
+// function must be called after it is unlocked.
+func (m *Manager) withdraw(provider string) func() {
+	var injections []func(interface{})
+	for n, svc := range m.services {
+		if svc.provider == provider {
+			delete(m.services, n)
+			injections = append(injections, m.injections[n]...)
+		}
+	}
+	return func() {
+		for _, fn := range injections {
+			fn(nil)
+		}
+	}
+}
+
+// Resolve stores the named service in the value pointed to by target.
+// Target must be a non-nil pointer to a type that the service is assignable
+// to, such as a pointer to the service's concrete type or a pointer to an
+// interface it implements.
+// A *ServiceNotFoundError is returned if the service has not been provided,
+// and a *ServiceTypeError is returned if the service is not assignable to
+// the target.
+func (m *Manager) Resolve(name string, target interface{}) error {
+	rv, err := serviceTarget(target)
+	if err != nil {
+		return err
+	}
+	m.mu.RLock()
+	svc, ok := m.services[name]
+	m.mu.RUnlock()
+	if !ok {
+		return &ServiceNotFoundError{Name: name}
+	}
+	return assignService(name, rv, svc.value)
+}
+
+// Inject stores the named service in the value pointed to by target each
+// time it is provided, then calls fn with the result.
+// If the service is already provided, target is set and fn is called before
+// this method returns. Otherwise, target is set and fn is called from the
+// goroutine that provides the service; fn should synchronize access to
+// target as necessary. The error passed to fn is nil or a *ServiceTypeError.
+//
+// When the service is withdrawn, such as when the providing plugin is
+// unloaded, target is set to its zero value and fn is called with a
+// *ServiceNotFoundError. If the plugin is reloaded, the new service is
+// injected again, so consumers never keep a withdrawn service.
+// Inject returns an error if target is not a valid pointer.
+func (m *Manager) Inject(name string, target interface{}, fn func(error)) error {
+	rv, err := serviceTarget(target)
+	if err != nil {
+		return err
+	}
+	inject := func(svc interface{}) {
+		var err error
+		if svc == nil {
+			rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+			err = &ServiceNotFoundError{Name: name}
+		} else {
+			err = assignService(name, rv, svc)
+		}
+		if fn != nil {
+			fn(err)
+		}
+	}
+	m.mu.Lock()
+	svc, ok := m.services[name]
+	m.injections[name] = append(m.injections[name], inject)
+	m.mu.Unlock()
+	if ok {
+		inject(svc.value)
+	}
+	return nil
+}
+
+// serviceTarget returns the reflected value of target, which must be a
+// non-nil pointer.
+func serviceTarget(target interface{}) (reflect.Value, error) {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return rv, errors.Errorf("service target must be a non-nil pointer, got %T", target)
+	}
+	return rv, nil
+}
+
+// assignService sets the value pointed to by rv to svc.
+func assignService(name string, rv reflect.Value, svc interface{}) error {
+	sv := reflect.ValueOf(svc)
+	et := rv.Elem().Type()
+	if !sv.Type().AssignableTo(et) {
+		return &ServiceTypeError{Name: name, Expected: et, Actual: sv.Type()}
+	}
+	rv.Elem().Set(sv)
+	return nil
+}
+
+// isNil returns true if v is nil or a nil value of a nillable type.
+func isNil(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+		return rv.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package plugin_test
+
+import (
+	"fmt"
+	"testing"
+
+	"code.dopame.me/veonik/squircy3/plugin"
+)
+
+type greeter interface {
+	Greet(name string) string
+}
+
+type englishGreeter struct{}
+
+func (englishGreeter) Greet(name string) string {
+	return fmt.Sprintf("Hello, %s!", name)
+}
+
+type greeterPlugin struct{}
+
+func (greeterPlugin) Name() string {
+	return "greeter"
+}
+
+func (greeterPlugin) Services() map[string]interface{} {
+	return map[string]interface{}{"greeter": &englishGreeter{}}
+}
+
+func TestManager_Resolve(t *testing.T) {
+	m := plugin.NewManager()
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return greeterPlugin{}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+
+	var g greeter
+	if err := m.Resolve("greeter", &g); err != nil {
+		t.Fatalf("unexpected error resolving service: %s", err)
+	}
+	if v := g.Greet("Steve"); v != "Hello, Steve!" {
+		t.Errorf("unexpected greeting: %s", v)
+	}
+
+	var eg *englishGreeter
+	if err := m.Resolve("greeter", &eg); err != nil {
+		t.Errorf("unexpected error resolving service by concrete type: %s", err)
+	}
+
+	var s fmt.Stringer
+	err := m.Resolve("greeter", &s)
+	if _, ok := err.(*plugin.ServiceTypeError); !ok {
+		t.Errorf("expected *plugin.ServiceTypeError, got %T: %v", err, err)
+	}
+
+	err = m.Resolve("missing", &g)
+	if _, ok := err.(*plugin.ServiceNotFoundError); !ok {
+		t.Errorf("expected *plugin.ServiceNotFoundError, got %T: %v", err, err)
+	}
+
+	if err := m.Unload("greeter"); err != nil {
+		t.Fatalf("unexpected error unloading plugin: %s", err)
+	}
+	err = m.Resolve("greeter", &g)
+	if _, ok := err.(*plugin.ServiceNotFoundError); !ok {
+		t.Errorf("expected service to be withdrawn after unload, got %T: %v", err, err)
+	}
+}
+
+func TestManager_Inject(t *testing.T) {
+	m := plugin.NewManager()
+	var g greeter
+	injected := false
+	err := m.Inject("greeter", &g, func(err error) {
+		if err != nil {
+			t.Errorf("unexpected error injecting service: %s", err)
+		}
+		injected = true
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if injected {
+		t.Fatalf("did not expect service to be injected before it is provided")
+	}
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return greeterPlugin{}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if !injected || g == nil {
+		t.Fatalf("expected service to be injected once provided")
+	}
+
+	var g2 greeter
+	if err := m.Inject("greeter", &g2, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g2 == nil {
+		t.Errorf("expected already provided service to be injected immediately")
+	}
+	if err := m.Inject("greeter", englishGreeter{}, nil); err == nil {
+		t.Errorf("expected error injecting into non-pointer target")
+	}
+}
+
+func TestManager_InjectReload(t *testing.T) {
+	m := plugin.NewManager()
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return greeterPlugin{}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	var g *englishGreeter
+	var errs []error
+	if err := m.Inject("greeter", &g, func(err error) { errs = append(errs, err) }); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := m.Unload("greeter"); err != nil {
+		t.Fatalf("unexpected error unloading plugin: %s", err)
+	}
+	if g != nil {
+		t.Errorf("expected withdrawn service to be cleared")
+	}
+	if len(errs) != 2 {
+		t.Fatalf("expected consumer to be notified of the withdrawn service, got %v", errs)
+	}
+	if _, ok := errs[1].(*plugin.ServiceNotFoundError); !ok {
+		t.Errorf("expected *plugin.ServiceNotFoundError, got %T: %v", errs[1], errs[1])
+	}
+
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return greeterPlugin{}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	if err := m.Reload("greeter"); err != nil {
+		t.Fatalf("unexpected error reloading plugin: %s", err)
+	}
+	if g == nil {
+		t.Errorf("expected the reloaded plugin's service to be injected")
+	}
+	if len(errs) != 5 || errs[4] != nil {
+		t.Errorf("expected service to be injected on each load, got %v", errs)
+	}
+}
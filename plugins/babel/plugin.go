@@ -15,6 +15,12 @@ import (
 
 const PluginName = "babel"
 
+// Requires returns the names of the plugins that must be loaded before the
+// babel plugin is initialized.
+func Requires() []string {
+	return []string{"vm"}
+}
+
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	v, err := vm.FromPlugins(m)
 	if err != nil {
@@ -6,9 +6,20 @@ import (
 )
 
 func main() {
-	plugin.Main(babel.PluginName)
+	plugin.MainWithManifest(Manifest)
 }
 
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return babel.Initialize(m)
 }
+
+// Version is the version of the plugin, set at build time.
+var Version = "SNAPSHOT"
+
+// Manifest describes the babel plugin.
+var Manifest = plugin.Manifest{
+	Name:       babel.PluginName,
+	Version:    Version,
+	APIVersion: plugin.APIVersion,
+	Requires:   babel.Requires(),
+}
@@ -27,6 +27,9 @@ type Manager struct {
 
 	channels map[string]*discordgo.Channel
 
+	// lastErr is the most recent error encountered while connecting.
+	lastErr error
+
 	mu sync.Mutex
 }
 
@@ -53,13 +56,45 @@ func (m *Manager) Connect() error {
 	}
 	s, err := discordgo.New("Bot " + m.conf.Token)
 	if err != nil {
+		m.lastErr = err
 		return err
 	}
 	m.session = s
 	m.session.AddHandler(m.onMessageCreate)
 	m.session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentGuildMessageTyping | discordgo.IntentsDirectMessages
 	m.session.Identify.Presence.Game = discordgo.Activity{Name: m.conf.ActivityName}
-	return m.session.Open()
+	if err := m.session.Open(); err != nil {
+		m.lastErr = err
+		return err
+	}
+	return nil
+}
+
+// Enabled returns true if the Manager is configured with a token.
+func (m *Manager) Enabled() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conf.enabled
+}
+
+// Connected returns true if the Manager has an open session.
+func (m *Manager) Connected() bool {
+	m.mu.Lock()
+	s := m.session
+	m.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return s.DataReady
+}
+
+// LastError returns the most recent connection error, or nil if there is none.
+func (m *Manager) LastError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lastErr
 }
 
 func (m *Manager) Disconnect() error {
@@ -73,13 +108,6 @@ func (m *Manager) Disconnect() error {
 	return s.Close()
 }
 
-func userToMap(user *discordgo.User) map[string]interface{} {
-	return map[string]interface{}{
-		"ID":       user.ID,
-		"Username": user.Username,
-	}
-}
-
 func (m *Manager) onMessageCreate(s *discordgo.Session, e *discordgo.MessageCreate) {
 	ch, err := m.getChannel(e.ChannelID)
 	isDM := false
@@ -88,15 +116,16 @@ func (m *Manager) onMessageCreate(s *discordgo.Session, e *discordgo.MessageCrea
 	} else {
 		isDM = ch.Type == discordgo.ChannelTypeDM
 	}
-	m.ev.Emit("discord.MESSAGE", map[string]interface{}{
-		"ID":        e.ID,
-		"Content":   e.Content,
-		"ChannelID": e.ChannelID,
-		"GuildID":   e.GuildID,
-		"Author":    userToMap(e.Author),
-		"FromSelf":  e.Author.ID == s.State.User.ID,
-		"IsDM":      isDM,
-	})
+	ev := &MessageEvent{
+		ID:        e.ID,
+		Content:   e.Content,
+		ChannelID: e.ChannelID,
+		GuildID:   e.GuildID,
+		Author:    NewUser(e.Author),
+		FromSelf:  e.Author.ID == s.State.User.ID,
+		IsDM:      isDM,
+	}
+	m.ev.Emit("discord.MESSAGE", ev.Map())
 }
 
 func (m *Manager) MessageChannel(channelID, message string) error {
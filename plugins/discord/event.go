@@ -0,0 +1,148 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+
+	"code.dopame.me/veonik/squircy3/event"
+)
+
+// A User is a Discord user, as included in event payloads.
+type User struct {
+	ID       string
+	Username string
+}
+
+// NewUser returns the User describing the given discordgo User.
+func NewUser(u *discordgo.User) User {
+	if u == nil {
+		return User{}
+	}
+	return User{ID: u.ID, Username: u.Username}
+}
+
+// Map returns the representation of the User used in event data.
+func (u User) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"ID":       u.ID,
+		"Username": u.Username,
+	}
+}
+
+// UserFromMap returns the User represented by the given event data.
+func UserFromMap(data map[string]interface{}) (User, error) {
+	u := User{}
+	if err := decodeStrings(data, map[string]*string{
+		"ID":       &u.ID,
+		"Username": &u.Username,
+	}); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// A MessageEvent is the payload of the "discord.MESSAGE" event emitted for
+// each message created in a channel the bot can see.
+//
+// Events are emitted with the map representation returned by Map so that
+// they remain accessible to scripts. Go handlers can use DecodeMessageEvent
+// or MessageHandlerFunc to get the typed value.
+type MessageEvent struct {
+	ID        string
+	Content   string
+	ChannelID string
+	GuildID   string
+	Author    User
+	// FromSelf is true if the message was sent by the bot.
+	FromSelf bool
+	// IsDM is true if the message was sent in a direct message channel.
+	IsDM bool
+}
+
+// Map returns the representation of the MessageEvent used as event data.
+func (e *MessageEvent) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"ID":        e.ID,
+		"Content":   e.Content,
+		"ChannelID": e.ChannelID,
+		"GuildID":   e.GuildID,
+		"Author":    e.Author.Map(),
+		"FromSelf":  e.FromSelf,
+		"IsDM":      e.IsDM,
+	}
+}
+
+// MessageEventFromMap returns the MessageEvent represented by the given
+// event data. Missing fields are left empty; an error is returned if a field
+// has an unexpected type.
+func MessageEventFromMap(data map[string]interface{}) (*MessageEvent, error) {
+	e := &MessageEvent{}
+	if err := decodeStrings(data, map[string]*string{
+		"ID":        &e.ID,
+		"Content":   &e.Content,
+		"ChannelID": &e.ChannelID,
+		"GuildID":   &e.GuildID,
+	}); err != nil {
+		return nil, err
+	}
+	for k, p := range map[string]*bool{"FromSelf": &e.FromSelf, "IsDM": &e.IsDM} {
+		v, ok := data[k]
+		if !ok || v == nil {
+			continue
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.Errorf("%s: expected event field %s to be bool, got %T", PluginName, k, v)
+		}
+		*p = b
+	}
+	switch v := data["Author"].(type) {
+	case nil:
+	case map[string]interface{}:
+		u, err := UserFromMap(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid Author")
+		}
+		e.Author = u
+	case User:
+		e.Author = v
+	default:
+		return nil, errors.Errorf("%s: expected event field Author to be a map, got %T", PluginName, v)
+	}
+	return e, nil
+}
+
+// DecodeMessageEvent returns the MessageEvent carried by the given
+// discord.MESSAGE event.
+func DecodeMessageEvent(ev *event.Event) (*MessageEvent, error) {
+	return MessageEventFromMap(ev.Data)
+}
+
+// MessageHandlerFunc returns an event.Handler that decodes the MessageEvent
+// before calling fn. Events that cannot be decoded are logged and skipped.
+func MessageHandlerFunc(fn func(ev *event.Event, e *MessageEvent)) event.Handler {
+	return event.HandlerFunc(func(ev *event.Event) {
+		e, err := DecodeMessageEvent(ev)
+		if err != nil {
+			ev.Fail(errors.Wrapf(err, "unable to decode %s event", ev.Name))
+			return
+		}
+		fn(ev, e)
+	})
+}
+
+// decodeStrings sets each string field from the event data.
+func decodeStrings(data map[string]interface{}, fields map[string]*string) error {
+	for k, p := range fields {
+		v, ok := data[k]
+		if !ok || v == nil {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return errors.Errorf("%s: expected event field %s to be string, got %T", PluginName, k, v)
+		}
+		*p = s
+	}
+	return nil
+}
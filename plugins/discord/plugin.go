@@ -11,6 +11,12 @@ import (
 
 const PluginName = "discord"
 
+// Requires returns the names of the plugins that must be loaded before the
+// discord plugin is initialized.
+func Requires() []string {
+	return []string{"event"}
+}
+
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	ev, err := event.FromPlugins(m)
 	if err != nil {
@@ -19,6 +25,15 @@ func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return &discordPlugin{NewManager(ev)}, nil
 }
 
+// FromPlugins returns the discord plugin's Manager or an error if it fails.
+func FromPlugins(m *plugin.Manager) (*Manager, error) {
+	var res *Manager
+	if err := m.Resolve(PluginName, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 type discordPlugin struct {
 	manager *Manager
 }
@@ -72,3 +87,19 @@ func (p *discordPlugin) HandleShutdown() {
 		}
 	}
 }
+
+func (p *discordPlugin) Services() map[string]interface{} {
+	return map[string]interface{}{PluginName: p.manager}
+}
+
+func (p *discordPlugin) Status() plugin.Status {
+	return plugin.Status{
+		Configured: p.manager.Enabled(),
+		Connected:  p.manager.Connected(),
+		LastError:  p.manager.LastError(),
+	}
+}
+
+func (p *discordPlugin) HandleUnload() {
+	p.HandleShutdown()
+}
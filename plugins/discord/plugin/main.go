@@ -6,9 +6,20 @@ import (
 )
 
 func main() {
-	plugin.Main(discord.PluginName)
+	plugin.MainWithManifest(Manifest)
 }
 
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return discord.Initialize(m)
 }
+
+// Version is the version of the plugin, set at build time.
+var Version = "SNAPSHOT"
+
+// Manifest describes the discord plugin.
+var Manifest = plugin.Manifest{
+	Name:       discord.PluginName,
+	Version:    Version,
+	APIVersion: plugin.APIVersion,
+	Requires:   discord.Requires(),
+}
@@ -16,6 +16,12 @@ import (
 
 const PluginName = "node_compat"
 
+// Requires returns the names of the plugins that must be loaded before the
+// node_compat plugin is initialized.
+func Requires() []string {
+	return []string{"vm", "babel"}
+}
+
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	vmp, err := vm.FromPlugins(m)
 	if err != nil {
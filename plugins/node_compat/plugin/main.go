@@ -6,9 +6,20 @@ import (
 )
 
 func main() {
-	plugin.Main(node_compat.PluginName)
+	plugin.MainWithManifest(Manifest)
 }
 
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return node_compat.Initialize(m)
 }
+
+// Version is the version of the plugin, set at build time.
+var Version = "SNAPSHOT"
+
+// Manifest describes the node_compat plugin.
+var Manifest = plugin.Manifest{
+	Name:       node_compat.PluginName,
+	Version:    Version,
+	APIVersion: plugin.APIVersion,
+	Requires:   node_compat.Requires(),
+}
@@ -2,6 +2,7 @@ package script
 
 import (
 	"path/filepath"
+	"sync"
 
 	"code.dopame.me/veonik/squircy3/config"
 	"code.dopame.me/veonik/squircy3/plugin"
@@ -18,6 +19,12 @@ func main() {
 	plugin.Main(PluginName)
 }
 
+// Requires returns the names of the plugins that must be loaded before the
+// script plugin is initialized.
+func Requires() []string {
+	return []string{"vm"}
+}
+
 // Initialize is a valid plugin.Initializer
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	vp, err := vm.FromPlugins(m)
@@ -28,32 +35,34 @@ func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return p, nil
 }
 
+// FromPlugins returns the script plugin's Manager or an error if it fails.
 func FromPlugins(m *plugin.Manager) (*Manager, error) {
-	mp, err := pluginFromPlugins(m)
-	if err != nil {
-		return nil, err
-	}
-	if mp.manager == nil {
-		return nil, errors.Errorf("%s: plugin is not configured", PluginName)
-	}
-	return mp.manager, nil
-}
-
-func pluginFromPlugins(m *plugin.Manager) (*scriptPlugin, error) {
-	p, err := m.Lookup(PluginName)
-	if err != nil {
+	var res *Manager
+	if err := m.Resolve(PluginName, &res); err != nil {
 		return nil, err
 	}
-	mp, ok := p.(*scriptPlugin)
-	if !ok {
-		return nil, errors.Errorf("%s: received unexpected plugin type", PluginName)
-	}
-	return mp, nil
+	return res, nil
 }
 
 type scriptPlugin struct {
 	vm      *vm.VM
 	manager *Manager
+
+	// loaded is the number of scripts run during the last runtime init.
+	loaded int
+	// lastErr is the most recent error encountered loading or running scripts.
+	lastErr error
+
+	mu sync.Mutex
+}
+
+func (p *scriptPlugin) setResult(loaded int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loaded = loaded
+	if err != nil {
+		p.lastErr = err
+	}
 }
 
 func (p *scriptPlugin) HandleRuntimeInit(r *goja.Runtime) {
@@ -61,19 +70,45 @@ func (p *scriptPlugin) HandleRuntimeInit(r *goja.Runtime) {
 	ss, err := p.manager.LoadAll()
 	if err != nil {
 		logrus.Warnf("script: failed to list directory contents of '%s': %s", p.manager.rootDir, err)
+		p.setResult(0, err)
 		return
 	}
+	loaded := 0
 	for _, s := range ss {
 		logrus.Infoln("Running script", s.Name)
 		pr, err := p.vm.Compile(s.Name, s.Body)
 		if err != nil {
 			logrus.Warnf("script: failed to compile script (%s): %s", s.Name, err)
+			p.setResult(loaded, errors.Wrapf(err, "failed to compile script (%s)", s.Name))
 			return
 		}
 		_, err = r.RunProgram(pr)
 		if err != nil {
 			logrus.Warnf("script: error while running script (%s): %s", s.Name, err)
+			p.setResult(loaded, errors.Wrapf(err, "error while running script (%s)", s.Name))
 		}
+		loaded++
+	}
+	p.setResult(loaded, nil)
+}
+
+func (p *scriptPlugin) Services() map[string]interface{} {
+	return map[string]interface{}{PluginName: p.manager}
+}
+
+func (p *scriptPlugin) Status() plugin.Status {
+	if p.manager == nil {
+		return plugin.Status{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return plugin.Status{
+		Configured: true,
+		LastError:  p.lastErr,
+		Details: map[string]interface{}{
+			"scripts_path": p.manager.rootDir,
+			"loaded":       p.loaded,
+		},
 	}
 }
 
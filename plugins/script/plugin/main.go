@@ -6,10 +6,21 @@ import (
 )
 
 func main() {
-	plugin.Main(script.PluginName)
+	plugin.MainWithManifest(Manifest)
 }
 
 // Initialize initializes the script plugin.
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return script.Initialize(m)
 }
+
+// Version is the version of the plugin, set at build time.
+var Version = "SNAPSHOT"
+
+// Manifest describes the script plugin.
+var Manifest = plugin.Manifest{
+	Name:       script.PluginName,
+	Version:    Version,
+	APIVersion: plugin.APIVersion,
+	Requires:   script.Requires(),
+}
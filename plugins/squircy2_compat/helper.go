@@ -43,13 +43,15 @@ type HelperSet struct {
 	irc  ircHelper
 
 	funcs map[string]*callback
+	// waiting contains the handlers bound by waitFor.
+	waiting *event.Group
 }
 
-func NewHelperSet(e *event.Dispatcher, v *vm.VM, i *irc.Manager) *HelperSet {
+func NewHelperSet(e *event.Dispatcher, v *vm.VM, i *irc.Networks) *HelperSet {
 	return &HelperSet{
 		events: e,
 		vm:     v,
-		irc:    ircHelper{i},
+		irc:    ircHelper{i.Default(), i},
 		http: httpHelper{
 			Client: &http.Client{Transport: &http.Transport{}},
 		},
@@ -163,7 +165,21 @@ func (h *configHelper) OwnerHost() string {
 }
 
 type ircHelper struct {
-	manager *irc.Manager
+	manager  *irc.Manager
+	networks *irc.Networks
+}
+
+// Network returns an ircHelper for the named network.
+func (h *ircHelper) Network(name string) (*ircHelper, error) {
+	m, err := h.networks.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return &ircHelper{m, h.networks}, nil
+}
+
+func (h *ircHelper) Name() string {
+	return h.manager.Name()
 }
 
 func (h *ircHelper) Connect() error {
@@ -181,20 +197,96 @@ func (h *ircHelper) Privmsg(target, message string) error {
 	})
 }
 
-func (h *ircHelper) Action(target, message string) error {
+// TaggedPrivmsg sends the message with the given IRCv3 message tags.
+func (h *ircHelper) TaggedPrivmsg(tags map[string]string, target, message string) error {
 	return h.manager.Do(func(conn *irc.Connection) error {
-		conn.Action(target, message)
+		return conn.TaggedPrivmsg(tags, target, message)
+	})
+}
+
+// Capabilities returns the IRCv3 capabilities enabled on the connection.
+func (h *ircHelper) Capabilities() []string {
+	return h.manager.Capabilities()
+}
+
+// ISupport returns the features advertised by the server.
+func (h *ircHelper) ISupport() *irc.ISupport {
+	return h.manager.ISupport()
+}
+
+// CTCP sends a CTCP request, such as VERSION, to target.
+func (h *ircHelper) CTCP(target, command, params string) error {
+	return h.manager.Do(func(conn *irc.Connection) error {
+		conn.CTCP(target, command, params)
 		return nil
 	})
 }
 
-func (h *ircHelper) Join(target string) error {
+// CTCPReply sends a reply to a CTCP request from target.
+func (h *ircHelper) CTCPReply(target, command, params string) error {
 	return h.manager.Do(func(conn *irc.Connection) error {
-		conn.Join(target)
+		conn.CTCPReply(target, command, params)
 		return nil
 	})
 }
 
+// ParseMessage parses a raw IRC line.
+func (h *ircHelper) ParseMessage(line string) (*irc.Message, error) {
+	return irc.ParseMessage(line)
+}
+
+// NewMessage returns a message with the given command and parameters, or
+// an error if the message cannot be sent.
+func (h *ircHelper) NewMessage(command string, params ...string) (*irc.Message, error) {
+	m := irc.NewMessage(command, params...)
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DecodeCTCP returns the command and parameters of a CTCP message, or nil
+// if message is not a CTCP message.
+func (h *ircHelper) DecodeCTCP(message string) interface{} {
+	command, params, ok := irc.DecodeCTCP(message)
+	if !ok {
+		return nil
+	}
+	return map[string]string{"Command": command, "Params": params}
+}
+
+func (h *ircHelper) Action(target, message string) error {
+	return h.manager.Do(func(conn *irc.Connection) error {
+		conn.Action(target, message)
+		return nil
+	})
+}
+
+func (h *ircHelper) Join(target string) error {
+	return h.manager.Join(target)
+}
+
+func (h *ircHelper) Channels() []string {
+	return h.manager.Channels()
+}
+
+// Channel returns the named channel, or nil if the bot is not in it.
+func (h *ircHelper) Channel(name string) interface{} {
+	if ch, ok := h.manager.Channel(name); ok {
+		return ch
+	}
+	return nil
+}
+
+// User returns the user with the given nick, or nil if they do not share a
+// channel with the bot.
+func (h *ircHelper) User(nick string) interface{} {
+	if u, ok := h.manager.User(nick); ok {
+		return u
+	}
+	return nil
+}
+
 func (h *ircHelper) Part(target string) error {
 	return h.manager.Do(func(conn *irc.Connection) error {
 		conn.Part(target)
@@ -220,8 +312,7 @@ func (h *ircHelper) Nick(newNick string) error {
 
 func (h *ircHelper) Raw(command string) error {
 	return h.manager.Do(func(conn *irc.Connection) error {
-		conn.SendRaw(command)
-		return nil
+		return conn.Send(command)
 	})
 }
 
@@ -13,8 +13,14 @@ import (
 
 const PluginName = "squircy2_compat"
 
+// Requires returns the names of the plugins that must be loaded before the
+// squircy2_compat plugin is initialized.
+func Requires() []string {
+	return []string{"irc", "event", "vm"}
+}
+
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
-	im, err := irc.FromPlugins(m)
+	im, err := irc.NetworksFromPlugins(m)
 	if err != nil {
 		return nil, errors.Wrapf(err, "%s: required dependency missing (irc)", PluginName)
 	}
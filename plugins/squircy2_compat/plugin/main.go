@@ -6,9 +6,20 @@ import (
 )
 
 func main() {
-	plugin.Main(squircy2_compat.PluginName)
+	plugin.MainWithManifest(Manifest)
 }
 
 func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
 	return squircy2_compat.Initialize(m)
 }
+
+// Version is the version of the plugin, set at build time.
+var Version = "SNAPSHOT"
+
+// Manifest describes the squircy2_compat plugin.
+var Manifest = plugin.Manifest{
+	Name:       squircy2_compat.PluginName,
+	Version:    Version,
+	APIVersion: plugin.APIVersion,
+	Requires:   squircy2_compat.Requires(),
+}
@@ -2,8 +2,11 @@ package squircy2_compat
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"code.dopame.me/veonik/squircy3/event"
+	"code.dopame.me/veonik/squircy3/irc"
 	"code.dopame.me/veonik/squircy3/vm"
 
 	"code.dopame.me/veonik/squircy3/plugins/squircy2_compat/data"
@@ -30,11 +33,21 @@ func (p *HelperSet) Enable(gr *goja.Runtime) {
 	gr.Set("File", p.fileHelper(gr))
 }
 
+// syncCallbackTimeout is the maximum amount of time the dispatcher will wait
+// for a synchronous callback to complete. The dispatcher worker handling the
+// event is blocked while waiting, so this is kept short.
+const syncCallbackTimeout = time.Second
+
 type callback struct {
 	vm        *vm.VM
 	id        string
 	eventType string
 	callable  goja.Callable
+
+	// sync is true if the dispatcher should wait for the callback to
+	// complete, allowing it to stop propagation of the event.
+	// Callbacks are always waited on for synchronous events.
+	sync bool
 }
 
 func (p *HelperSet) newCallback(eventType string, id string, callable goja.Callable) *callback {
@@ -50,18 +63,90 @@ func (cb *callback) ID() string {
 	return cb.id
 }
 
+// Handle runs the callback in the VM.
+//
+// If the callback is waited on and does not complete in time, it is
+// abandoned: if it has not started, it is not run at all, and if it is
+// running, it can no longer modify the event. Modifying the event after
+// the dispatcher has moved on would race with other handlers.
+//
+// Calling stopPropagation in a callback that is not waited on, that is one
+// bound without a priority for an event that is not synchronous, has no
+// effect and logs a warning.
+//
+// squircy2 scripts never saw the bot's own messages, so irc events echoed
+// back by the server are not passed to callbacks.
 func (cb *callback) Handle(ev *event.Event) {
+	if echo, _ := ev.Data["Echo"].(bool); echo {
+		return
+	}
 	dat := make(map[string]interface{}, len(ev.Data))
 	for k, v := range ev.Data {
 		dat[k] = v
 	}
+	wait := cb.sync || ev.Synchronous()
+	done := make(chan struct{})
+	// mu guards abandoned and every modification of ev by the callback.
+	var mu sync.Mutex
+	abandoned := false
+	withEvent := func(fn func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			logrus.Debugf("squircy2_compat: ignoring change to %s from abandoned handler", ev.Name)
+			return
+		}
+		fn()
+	}
 	cb.vm.Do(func(r *goja.Runtime) {
+		defer close(done)
+		mu.Lock()
+		skip := abandoned
+		mu.Unlock()
+		if skip {
+			logrus.Debugf("squircy2_compat: not running abandoned handler for %s", ev.Name)
+			return
+		}
 		d := r.ToValue(dat)
-		_, err := cb.callable(nil, d)
+		e := r.NewObject()
+		must("setting event.name", e.Set("name", ev.Name))
+		must("setting event.stopPropagation", e.Set("stopPropagation", func() {
+			if !wait {
+				logrus.Warnf("squircy2_compat: stopPropagation has no effect in handler for %s bound without a priority", ev.Name)
+				return
+			}
+			withEvent(ev.StopPropagation)
+		}))
+		v, err := cb.callable(nil, d, e)
 		if err != nil {
+			if ev.Synchronous() {
+				withEvent(func() { ev.Fail(err) })
+				return
+			}
 			logrus.Warnln("error running callback:", err)
+			return
+		}
+		if ev.Synchronous() && v != nil && !goja.IsUndefined(v) {
+			// the value returned by the callback is the reply.
+			withEvent(func() { ev.Reply(v.Export()) })
 		}
 	})
+	if !wait {
+		return
+	}
+	t := time.NewTimer(syncCallbackTimeout)
+	defer t.Stop()
+	select {
+	case <-done:
+		return
+	case <-ev.Context().Done():
+		logrus.Warnf("squircy2_compat: gave up waiting for handler for %s: %s", ev.Name, ev.Context().Err())
+	case <-t.C:
+		logrus.Warnf("squircy2_compat: timed out waiting for handler for %s", ev.Name)
+	}
+	mu.Lock()
+	abandoned = true
+	mu.Unlock()
 }
 
 func (p *HelperSet) setDispatcher(gr *goja.Runtime) {
@@ -71,14 +156,25 @@ func (p *HelperSet) setDispatcher(gr *goja.Runtime) {
 		}
 	}
 	p.funcs = map[string]*callback{}
+	if p.waiting != nil {
+		p.waiting.UnbindAll()
+	}
+	p.waiting = p.events.NewGroup()
 	gr.Set("bind", func(call goja.FunctionCall) goja.Value {
 		eventType := call.Argument(0).String()
 		arg1 := call.Argument(1)
 		if fn, ok := goja.AssertFunction(arg1); ok {
 			id := fmt.Sprintf("%p", arg1.ToObject(gr))
 			cb := p.newCallback(eventType, id, fn)
+			priority := event.DefaultPriority
+			if arg2 := call.Argument(2); !goja.IsUndefined(arg2) && !goja.IsNull(arg2) {
+				// handlers bound with a priority are run synchronously so
+				// that they may stop propagation of the event.
+				priority = int(arg2.ToInteger())
+				cb.sync = true
+			}
 			p.funcs[id] = cb
-			p.events.Bind(eventType, cb)
+			p.events.BindPriority(eventType, cb, priority)
 			return gr.ToValue(id)
 		}
 		panic(gr.NewTypeError("expected arg1 to be Function"))
@@ -108,6 +204,72 @@ func (p *HelperSet) setDispatcher(gr *goja.Runtime) {
 	}
 	gr.Set("trigger", emit)
 	gr.Set("emit", emit)
+	gr.Set("waitFor", p.waitFor(gr))
+}
+
+// waitFor returns a function that returns a Promise that is resolved with
+// the data of the next event matching the optional predicate, or rejected
+// if the optional timeout (in milliseconds) elapses first.
+func (p *HelperSet) waitFor(gr *goja.Runtime) func(goja.FunctionCall) goja.Value {
+	return func(call goja.FunctionCall) goja.Value {
+		eventType := call.Argument(0).String()
+		var predicate goja.Callable
+		if arg1 := call.Argument(1); !goja.IsUndefined(arg1) && !goja.IsNull(arg1) {
+			fn, ok := goja.AssertFunction(arg1)
+			if !ok {
+				panic(gr.NewTypeError("expected arg1 to be Function"))
+			}
+			predicate = fn
+		}
+		timeout := time.Duration(call.Argument(2).ToInteger()) * time.Millisecond
+		promise, resolve, reject := gr.NewPromise()
+		// settled is only accessed from within the vm.
+		settled := false
+		var h event.Handler
+		h = p.waiting.BindWithTimeout(eventType, event.HandlerFunc(func(ev *event.Event) {
+			dat := make(map[string]interface{}, len(ev.Data))
+			for k, v := range ev.Data {
+				dat[k] = v
+			}
+			name := ev.Name
+			p.vm.Do(func(r *goja.Runtime) {
+				if settled {
+					return
+				}
+				d := r.ToValue(dat)
+				if predicate != nil {
+					e := r.NewObject()
+					must("setting event.name", e.Set("name", name))
+					v, err := predicate(nil, d, e)
+					if err != nil {
+						settled = true
+						p.waiting.Unbind(eventType, h)
+						if ex, ok := err.(*goja.Exception); ok {
+							reject(ex.Value())
+						} else {
+							reject(r.NewGoError(err))
+						}
+						return
+					}
+					if !v.ToBoolean() {
+						return
+					}
+				}
+				settled = true
+				p.waiting.Unbind(eventType, h)
+				resolve(d)
+			})
+		}), timeout, func() {
+			p.vm.Do(func(r *goja.Runtime) {
+				if settled {
+					return
+				}
+				settled = true
+				reject(r.NewGoError(fmt.Errorf("timed out after %s waiting for %s", timeout, eventType)))
+			})
+		})
+		return gr.ToValue(promise)
+	}
 }
 
 func (p *HelperSet) setAsyncFunc(gr *goja.Runtime) {
@@ -205,16 +367,46 @@ func (p *HelperSet) setDataHelper(gr *goja.Runtime) {
 }
 
 func (p *HelperSet) ircHelper(gr *goja.Runtime) *goja.Object {
+	v := ircObject(gr, &p.irc)
+	must("binding Irc.Networks", v.Set("Networks", p.irc.networks.Names))
+	must("binding Irc.Network", v.Set("Network", func(name string) (*goja.Object, error) {
+		h, err := p.irc.Network(name)
+		if err != nil {
+			return nil, err
+		}
+		return ircObject(gr, h), nil
+	}))
+	return v
+}
+
+// ircObject returns a javascript object with methods that use the given
+// ircHelper's network.
+func ircObject(gr *goja.Runtime, h *ircHelper) *goja.Object {
 	v := gr.NewObject()
-	must("binding Irc.Connect", v.Set("Connect", (&p.irc).Connect))
-	must("binding Irc.Disconnect", v.Set("Disconnect", (&p.irc).Disconnect))
-	must("binding Irc.Privmsg", v.Set("Privmsg", (&p.irc).Privmsg))
-	must("binding Irc.Nick", v.Set("Nick", (&p.irc).Nick))
-	must("binding Irc.CurrentNick", v.Set("CurrentNick", (&p.irc).CurrentNick))
-	must("binding Irc.Action", v.Set("Action", (&p.irc).Action))
-	must("binding Irc.Join", v.Set("Join", (&p.irc).Join))
-	must("binding Irc.Part", v.Set("Part", (&p.irc).Part))
-	must("binding Irc.Raw", v.Set("Raw", (&p.irc).Raw))
+	must("binding Irc.Name", v.Set("Name", h.Name))
+	must("binding Irc.Connect", v.Set("Connect", h.Connect))
+	must("binding Irc.Disconnect", v.Set("Disconnect", h.Disconnect))
+	must("binding Irc.Privmsg", v.Set("Privmsg", h.Privmsg))
+	must("binding Irc.TaggedPrivmsg", v.Set("TaggedPrivmsg", h.TaggedPrivmsg))
+	must("binding Irc.Capabilities", v.Set("Capabilities", h.Capabilities))
+	must("binding Irc.Nick", v.Set("Nick", h.Nick))
+	must("binding Irc.CurrentNick", v.Set("CurrentNick", h.CurrentNick))
+	must("binding Irc.Action", v.Set("Action", h.Action))
+	must("binding Irc.Join", v.Set("Join", h.Join))
+	must("binding Irc.Part", v.Set("Part", h.Part))
+	must("binding Irc.Channels", v.Set("Channels", h.Channels))
+	must("binding Irc.Channel", v.Set("Channel", h.Channel))
+	must("binding Irc.User", v.Set("User", h.User))
+	must("binding Irc.Raw", v.Set("Raw", h.Raw))
+	must("binding Irc.ISupport", v.Set("ISupport", h.ISupport))
+	must("binding Irc.CTCP", v.Set("CTCP", h.CTCP))
+	must("binding Irc.CTCPReply", v.Set("CTCPReply", h.CTCPReply))
+	must("binding Irc.ParseMessage", v.Set("ParseMessage", h.ParseMessage))
+	must("binding Irc.NewMessage", v.Set("NewMessage", h.NewMessage))
+	must("binding Irc.EncodeCTCP", v.Set("EncodeCTCP", irc.EncodeCTCP))
+	must("binding Irc.DecodeCTCP", v.Set("DecodeCTCP", h.DecodeCTCP))
+	must("binding Irc.StripFormatting", v.Set("StripFormatting", irc.StripFormatting))
+	must("binding Irc.FormattingToANSI", v.Set("FormattingToANSI", irc.FormattingToANSI))
 	return v
 }
 
@@ -3,6 +3,8 @@ package vm
 import (
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 
 	"code.dopame.me/veonik/squircy3/config"
 	"code.dopame.me/veonik/squircy3/plugin"
@@ -14,38 +16,46 @@ import (
 
 const pluginName = "vm"
 
-func pluginFromPlugins(m *plugin.Manager) (*vmPlugin, error) {
-	p, err := m.Lookup(pluginName)
-	if err != nil {
-		return nil, err
-	}
-	mp, ok := p.(*vmPlugin)
-	if !ok {
-		return nil, errors.Errorf("vm: received unexpected plugin type")
-	}
-	return mp, nil
-}
-
 // FromPlugins returns the vm plugin's VM or an error if it fails.
 func FromPlugins(m *plugin.Manager) (*VM, error) {
-	mp, err := pluginFromPlugins(m)
-	if err != nil {
+	var res *VM
+	if err := m.Resolve(pluginName, &res); err != nil {
 		return nil, err
 	}
-	if mp.vm == nil {
-		return nil, errors.New("vm: plugin is not configured")
-	}
-	return mp.vm, nil
+	return res, nil
 }
 
 // Initialize is a plugin.Initializer that initializes a vm plugin.
-func Initialize(*plugin.Manager) (plugin.Plugin, error) {
-	p := &vmPlugin{}
+func Initialize(m *plugin.Manager) (plugin.Plugin, error) {
+	p := &vmPlugin{plugins: m, runtimeInits: make(map[string]*pluginRuntimeInit)}
 	return p, nil
 }
 
 type vmPlugin struct {
-	vm *VM
+	vm      *VM
+	plugins *plugin.Manager
+
+	// runtimeInits contains the RuntimeInitHandler added for each plugin.
+	runtimeInits map[string]*pluginRuntimeInit
+	mu           sync.Mutex
+}
+
+// pluginRuntimeInit wraps a plugin's RuntimeInitHandler so that it can be
+// disabled when the plugin is unloaded.
+type pluginRuntimeInit struct {
+	handler  RuntimeInitHandler
+	disabled int32
+}
+
+func (h *pluginRuntimeInit) HandleRuntimeInit(r *goja.Runtime) {
+	if atomic.LoadInt32(&h.disabled) == 1 {
+		return
+	}
+	h.handler.HandleRuntimeInit(r)
+}
+
+func (h *pluginRuntimeInit) disable() {
+	atomic.StoreInt32(&h.disabled, 1)
 }
 
 func (p *vmPlugin) Configure(conf config.Config) error {
@@ -66,10 +76,60 @@ func (p *vmPlugin) Configure(conf config.Config) error {
 	if err != nil {
 		return err
 	}
+	vm.OnRuntimeInit(p.setPluginsObject)
 	p.vm = vm
 	return nil
 }
 
+// setPluginsObject adds the "plugins" object to the runtime, allowing
+// scripts to inspect the loaded plugins.
+func (p *vmPlugin) setPluginsObject(r *goja.Runtime) {
+	v := r.NewObject()
+	if err := v.Set("loaded", p.plugins.Loaded); err != nil {
+		logrus.Warnln("vm: error setting plugins.loaded:", err)
+	}
+	if err := v.Set("status", func() []map[string]interface{} {
+		var res []map[string]interface{}
+		for _, st := range p.plugins.Status() {
+			var lastErr interface{}
+			if st.LastError != nil {
+				lastErr = st.LastError.Error()
+			}
+			res = append(res, map[string]interface{}{
+				"Name":       st.Name,
+				"Configured": st.Configured,
+				"Connected":  st.Connected,
+				"LastError":  lastErr,
+				"Uptime":     st.Uptime.Seconds(),
+				"Details":    st.Details,
+			})
+		}
+		return res
+	}); err != nil {
+		logrus.Warnln("vm: error setting plugins.status:", err)
+	}
+	if err := r.Set("plugins", v); err != nil {
+		logrus.Warnln("vm: error setting plugins:", err)
+	}
+}
+
+func (p *vmPlugin) Services() map[string]interface{} {
+	return map[string]interface{}{pluginName: p.vm}
+}
+
+func (p *vmPlugin) Status() plugin.Status {
+	if p.vm == nil {
+		return plugin.Status{}
+	}
+	return plugin.Status{
+		Configured: true,
+		Details: map[string]interface{}{
+			"running": p.vm.Running(),
+			"pending": p.vm.Pending(),
+		},
+	}
+}
+
 func (p *vmPlugin) Options() []config.SetupOption {
 	return []config.SetupOption{
 		config.WithRequiredOption("modules_path"),
@@ -101,14 +161,28 @@ func (p *vmPlugin) HandlePluginInit(o plugin.Plugin) {
 		return
 	}
 	if ih, ok := o.(RuntimeInitHandler); ok {
+		h := &pluginRuntimeInit{handler: ih}
+		p.mu.Lock()
+		p.runtimeInits[o.Name()] = h
+		p.mu.Unlock()
 		if oh, ok := ih.(PrependRuntimeInitHandler); ok && oh.PrependRuntimeInitHandler() {
-			p.vm.PrependRuntimeInit(ih.HandleRuntimeInit)
+			p.vm.PrependRuntimeInit(h.HandleRuntimeInit)
 		} else {
-			p.vm.OnRuntimeInit(ih.HandleRuntimeInit)
+			p.vm.OnRuntimeInit(h.HandleRuntimeInit)
 		}
 	}
 }
 
+func (p *vmPlugin) HandlePluginUnload(o plugin.Plugin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.runtimeInits[o.Name()]; ok {
+		// the handler will no longer be called when the runtime is initialized
+		h.disable()
+		delete(p.runtimeInits, o.Name())
+	}
+}
+
 func (p *vmPlugin) HandleShutdown() {
 	if p.vm == nil {
 		logrus.Warnln("vm: shutting down uninitialized plugin")
@@ -0,0 +1,61 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"code.dopame.me/veonik/squircy3/config"
+	"code.dopame.me/veonik/squircy3/plugin"
+	"code.dopame.me/veonik/squircy3/vm"
+)
+
+type reportingPlugin struct{}
+
+func (p reportingPlugin) Name() string {
+	return "reporting"
+}
+
+func (p reportingPlugin) Status() plugin.Status {
+	return plugin.Status{
+		Connected: true,
+		LastError: errors.New("oops"),
+		Details:   map[string]interface{}{"server": "irc.example.com"},
+	}
+}
+
+func TestPlugin_status(t *testing.T) {
+	m := plugin.NewManager()
+	m.RegisterFunc(config.Initialize)
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors loading config plugin: %v", errs)
+	}
+	if err := config.ConfigurePlugin(m, config.WithOption("root_path"), config.WithValuesFromTOMLFile("../testdata/vm.toml")); err != nil {
+		t.Fatalf("unexpected error configuring: %s", err)
+	}
+	m.RegisterFunc(vm.Initialize)
+	m.RegisterFunc(func(*plugin.Manager) (plugin.Plugin, error) {
+		return reportingPlugin{}, nil
+	})
+	if errs := m.Configure(); len(errs) > 0 {
+		t.Fatalf("unexpected errors configuring plugins: %v", errs)
+	}
+	v, err := vm.FromPlugins(m)
+	if err != nil {
+		t.Fatalf("unable to get vm: %s", err)
+	}
+	if err := v.Start(); err != nil {
+		t.Fatalf("unable to start vm: %s", err)
+	}
+	defer v.Shutdown()
+	res, err := v.RunString(`
+		var st = plugins.status().filter(function(s) { return s.Name === "reporting"; })[0];
+		[st.Connected, st.Configured, st.LastError, st.Details.server, plugins.loaded().length].join("|")
+	`).Await()
+	if err != nil {
+		t.Fatalf("error evaluating script: %s", err)
+	}
+	if s := res.String(); s != "true|false|oops|irc.example.com|3" {
+		t.Errorf("unexpected status from script: %s", s)
+	}
+}
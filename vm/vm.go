@@ -131,6 +131,18 @@ func (vm *VM) RunProgram(p *goja.Program) *AsyncResult {
 	return newAsyncResult(res, vmdone, vm.Do)
 }
 
+// Running returns true if the VM is started.
+func (vm *VM) Running() bool {
+	vm.scheduler.mu.Lock()
+	defer vm.scheduler.mu.Unlock()
+	return vm.scheduler.running
+}
+
+// Pending returns the number of jobs waiting to be run by the VM.
+func (vm *VM) Pending() int {
+	return len(vm.scheduler.jobs)
+}
+
 func (vm *VM) Do(fn func(*goja.Runtime)) {
 	vm.scheduler.run(fn)
 }